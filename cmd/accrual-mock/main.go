@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual/mock"
+)
+
+var bind = flag.String("a", "127.0.0.1:8080", "address of accrual mock. RUN_ADDRESS overwrites it")
+var steps = flag.Int("steps", 1, "count of polls the order stays in REGISTERED and PROCESSING statuses")
+var debug = flag.Bool("debug", false, "set log level to debug")
+
+func main() {
+	flag.Parse()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if *debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+	address := *bind
+	if env := os.Getenv("RUN_ADDRESS"); env != "" {
+		address = env
+	}
+	server := mock.New(*steps)
+	r := chi.NewRouter()
+	r.Route("/", server.Router)
+	log.Info().Msgf("Accrual mock listening on %v", address)
+
+	err := http.ListenAndServe(address, r)
+	log.Fatal().Err(err).Msg("Fatal error in starting web server")
+}
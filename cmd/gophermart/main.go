@@ -1,24 +1,25 @@
 package main
 
 import (
-	"net/http"
+	"flag"
 
-	"github.com/go-chi/chi"
-	"github.com/rs/zerolog/log"
-
-	"github.com/t1mon-ggg/gophermart/internal/pkg/handlers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
 )
 
 func main() {
-	app := handlers.NewGopherMart()
-	log.Info().Msg("New app struct created")
-
-	r := chi.NewRouter()
-	log.Info().Msg("Chi reouter created")
-
-	r.Route("/", app.Router)
-	log.Info().Msg("Chi router configured. Starting web bind")
+	cfg := config.New()
+	if flag.Arg(0) == "migrate" {
+		migrate(cfg, flag.Arg(1))
+		return
+	}
+	if flag.Arg(0) == "unlock" {
+		unlock(cfg, flag.Arg(1), flag.Arg(2))
+		return
+	}
+	if flag.Arg(0) == "role" {
+		role(cfg, flag.Arg(1), flag.Arg(2))
+		return
+	}
 
-	err := http.ListenAndServe(app.Config.Bind, r)
-	log.Fatal().Err(err).Msg("Fatal error in starting web server")
+	serve(cfg)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/migrations"
+)
+
+//migrate - running "gophermart migrate up|down|status" command
+func migrate(cfg *config.Config, command string) {
+	ctx := context.Background()
+	pool, err := pgxpool.Connect(ctx, cfg.DBPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error while connecting to Posgres database")
+	}
+	defer pool.Close()
+	m, err := migrations.New(pool)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error while loading migrations")
+	}
+	switch command {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		err = m.Down(ctx)
+	case "status":
+		err = printStatus(ctx, m)
+	default:
+		log.Fatal().Msgf("Unknown migrate command %q. Allowed commands are up, down and status", command)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Error in migrate %s", command)
+	}
+}
+
+//printStatus - printing state of every migration to stdout
+func printStatus(ctx context.Context, m *migrations.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied {
+			applied = "applied at " + s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d %-20s %s\n", s.Version, s.Name, applied)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/storage"
+)
+
+//role - running "gophermart role <login> <role>" command. Role of the user is changed and the change is audited
+func role(cfg *config.Config, login, role string) {
+	if login == "" || role == "" {
+		log.Fatal().Msg("Usage: gophermart role <login> user|support|admin")
+	}
+	if role != models.RoleUser && role != models.RoleSupport && role != models.RoleAdmin {
+		log.Fatal().Msgf("Unknown role %q. Allowed roles are user, support and admin", role)
+	}
+	db, err := storage.New(cfg.DBPath, false)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error while connecting to Posgres database")
+	}
+	err = db.SetRole(context.Background(), login, role)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Error while setting role %s to user %s", role, login)
+	}
+	err = db.CreateAuditEvent(context.Background(), models.AuditEvent{
+		Actor:   "cli",
+		Action:  "user.role",
+		Target:  "user:" + login,
+		Reason:  "role set to " + role,
+		Created: time.Now(),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Audit event of role change is lost")
+	}
+	log.Info().Msgf("User %s now has role %s", login, role)
+}
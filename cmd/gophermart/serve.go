@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/handlers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
+)
+
+//serve - running the server until SIGINT or SIGTERM. On signal readiness probe fails for SHUTDOWN_DELAY, then new connections are refused,
+//in-flight requests are finished, workers are stopped and the storage is closed. Everything after the delay must stop in SHUTDOWN_TIMEOUT.
+//If METRICS_ADDRESS is set metrics are served on it by separate server which is stopped last. Recorded spans are flushed on exit
+func serve(cfg *config.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stopTracing, err := tracing.Setup(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Tracing configuration error")
+	}
+
+	app := handlers.NewGopherMart(cfg)
+	log.Info().Msg("New app struct created")
+
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	app.StartWorkers(workers)
+	log.Info().Msg("Accrual workers started")
+
+	r := chi.NewRouter()
+	log.Info().Msg("Chi reouter created")
+
+	r.Route("/", app.Router)
+	log.Info().Msg("Chi router configured. Starting web bind")
+
+	server := &http.Server{
+		Addr:              cfg.Bind,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	server.RegisterOnShutdown(app.Drain)
+	failed := make(chan error, 2)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+	var metrics *http.Server
+	if cfg.MetricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", app.MetricsHandler())
+		metrics = &http.Server{
+			Addr:              cfg.MetricsAddress,
+			Handler:           mux,
+			ReadHeaderTimeout: cfg.ReadTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		}
+		go func() {
+			failed <- metrics.ListenAndServe()
+		}()
+		log.Info().Msgf("Metrics are served on %v", cfg.MetricsAddress)
+	}
+
+	clean := true
+	select {
+	case err := <-failed:
+		log.Error().Err(err).Msg("Fatal error in starting web server")
+		clean = false
+	case <-ctx.Done():
+		log.Info().Msgf("Shutdown signal received. Waiting up to %v for requests and workers", cfg.ShutdownTimeout)
+	}
+	stop()
+
+	app.Drain()
+	if clean && cfg.ShutdownDelay > 0 {
+		log.Info().Msgf("Reporting not ready for %v before closing the listener", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	}
+	deadline, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(deadline)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error().Err(err).Msg("In-flight requests did not finish in time")
+		clean = false
+	}
+	stopWorkers()
+	err = app.Shutdown(deadline)
+	if err != nil {
+		clean = false
+	}
+	if metrics != nil {
+		err = metrics.Shutdown(deadline)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Metrics server did not stop in time")
+			clean = false
+		}
+	}
+	err = stopTracing(deadline)
+	if err != nil {
+		log.Error().Err(err).Msg("Spans were not exported")
+	}
+	if !clean {
+		log.Error().Msg("Server stopped with errors")
+		os.Exit(1)
+	}
+	log.Info().Msg("Server stopped")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/storage"
+)
+
+//unlock - running "gophermart unlock user|ip <key>" command. Failed login attempts and lock of the account or the address are removed and the unlock is audited
+func unlock(cfg *config.Config, scope, key string) {
+	if scope != models.ThrottleUser && scope != models.ThrottleIP {
+		log.Fatal().Msgf("Unknown unlock scope %q. Allowed scopes are user and ip", scope)
+	}
+	if key == "" {
+		log.Fatal().Msgf("Usage: gophermart unlock %s <%s>", scope, scope)
+	}
+	db, err := storage.New(cfg.DBPath, false)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error while connecting to Posgres database")
+	}
+	err = db.ResetThrottle(context.Background(), scope, key)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Error while unlocking %s %s", scope, key)
+	}
+	err = db.CreateAuditEvent(context.Background(), models.AuditEvent{
+		Actor:   "cli",
+		Action:  "user.unlock",
+		Target:  scope + ":" + key,
+		Created: time.Now(),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Audit event of unlock is lost")
+	}
+	log.Info().Msgf("Login for %s %s unlocked", scope, key)
+}
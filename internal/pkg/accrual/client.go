@@ -0,0 +1,278 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/metrics"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
+)
+
+const (
+	retries     = 4                      //Count of retries of failed request before giving up
+	backoffBase = 100 * time.Millisecond //First retry delay. Every next retry doubles it
+	backoffMax  = 5 * time.Second        //Upper limit of retry delay
+	defaultWait = 60 * time.Second       //Pause on 429 without valid Retry-After header
+	maxWait     = 2 * time.Minute        //Upper limit of pause on 429. Longer Retry-After values are cut to it
+)
+
+var (
+	ErrNotRegistered = errors.New("order not registered in accrual system") //Accrual system answered 204
+	ErrRateLimited   = errors.New("accrual system rate limit exceeded")     //Accrual system answered 429. Returned errors are *RateLimitError
+	ErrBadResponse   = errors.New("unexpected answer from accrual system")  //Accrual system answered with unknown status or invalid body
+)
+
+var sublog = log.With().Str("component", "accrual").Logger()
+
+//tracer - tracer of requests to accrual system
+var tracer = otel.Tracer("github.com/t1mon-ggg/gophermart/internal/pkg/accrual")
+
+//orderKey - span attribute with order number
+const orderKey = attribute.Key("gophermart.order")
+
+//Client - accrual system client
+type Client interface {
+	GetOrder(ctx context.Context, number string) (models.Accrual, error) //Requesting order state from accrual system
+	Ping(ctx context.Context) error                                      //Checking that accrual system is reachable
+}
+
+//RateLimitError - accrual system answered 429. Requests should not be repeated until Retry passes
+type RateLimitError struct {
+	Retry time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v. Retry after %v", ErrRateLimited, e.Retry)
+}
+
+//Is - matching RateLimitError with ErrRateLimited
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+//HTTPClient - client of accrual system shared by all workers. Requests wait for one token bucket and the whole client pauses on 429
+type HTTPClient struct {
+	address string
+	client  *http.Client
+	limiter *rate.Limiter
+	slots   chan struct{}
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+var _ Client = (*HTTPClient)(nil)
+
+//New - creating accrual system client from configuration
+func New(cfg *config.Config) *HTTPClient {
+	rps := cfg.AccrualRPS
+	if rps <= 0 {
+		rps = 1
+	}
+	concurrency := cfg.AccrualConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &HTTPClient{
+		address: cfg.AccSystem,
+		client:  &http.Client{Timeout: cfg.AccrualTimeout},
+		limiter: rate.NewLimiter(rate.Limit(rps), rps),
+		slots:   make(chan struct{}, concurrency),
+	}
+}
+
+//pause - stopping all requests for d
+func (c *HTTPClient) pause(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(c.pausedUntil) {
+		sublog.Info().Msgf("Accrual system requests paused for %v", d)
+		c.pausedUntil = until
+	}
+}
+
+//wait - waiting for the end of pause and for the free token
+func (c *HTTPClient) wait(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		d := time.Until(c.pausedUntil)
+		c.mu.Unlock()
+		if d <= 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	return c.limiter.Wait(ctx)
+}
+
+//backoff - exponential retry delay with full jitter
+func backoff(attempt int) time.Duration {
+	d := backoffBase << uint(attempt)
+	if d <= 0 || d > backoffMax {
+		d = backoffMax
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+//retryAfter - parsing Retry-After header in seconds. Result is not longer than maxWait
+func retryAfter(header string) time.Duration {
+	t, err := strconv.Atoi(header)
+	if err != nil || t <= 0 {
+		sublog.Debug().Err(err).Msgf("Invalid Retry-After header %q", header)
+		return defaultWait
+	}
+	if time.Duration(t) > maxWait/time.Second {
+		sublog.Debug().Msgf("Retry-After header %q is cut to %v", header, maxWait)
+		return maxWait
+	}
+	return time.Duration(t) * time.Second
+}
+
+//MaxPoll - longest duration of GetOrder with the timeout of one request: the longest pause on 429, all attempts and their backoff.
+//Waiting for rate limit tokens and free connection slots is not counted, callers should bound GetOrder with this deadline
+func MaxPoll(timeout time.Duration) time.Duration {
+	return maxWait + time.Duration(retries+1)*timeout + time.Duration(retries)*backoffMax
+}
+
+//GetOrder - requesting order state from accrual system. 5xx answers and network errors are retried with backoff
+func (c *HTTPClient) GetOrder(ctx context.Context, order string) (models.Accrual, error) {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			d := backoff(attempt)
+			sublog.Debug().Err(err).Msgf("Retrying accrual request for order %v in %v", order, d)
+			select {
+			case <-ctx.Done():
+				return models.Accrual{}, ctx.Err()
+			case <-time.After(d):
+			}
+		}
+		var acc models.Accrual
+		var retry bool
+		acc, retry, err = c.request(ctx, order)
+		if !retry {
+			return acc, err
+		}
+	}
+	return models.Accrual{}, err
+}
+
+//Ping - checking that accrual system answers on its address. Any answer except 5xx means the system is reachable. Ping is not limited by rate limit and pause
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, c.address, nil)
+	if err != nil {
+		return err
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: status code %d", ErrBadResponse, response.StatusCode)
+	}
+	return nil
+}
+
+//outcome - metric label of finished request to accrual system
+func outcome(ctx context.Context, retry bool, err error) string {
+	switch {
+	case err == nil:
+		return metrics.AccrualOK
+	case ctx.Err() != nil:
+		return metrics.AccrualCanceled
+	case errors.Is(err, ErrNotRegistered):
+		return metrics.AccrualNotRegistered
+	case errors.Is(err, ErrRateLimited):
+		return metrics.AccrualRateLimited
+	case errors.Is(err, ErrBadResponse) && retry:
+		return metrics.AccrualServerError
+	case errors.Is(err, ErrBadResponse):
+		return metrics.AccrualBadResponse
+	}
+	return metrics.AccrualNetworkError
+}
+
+//request - one request to accrual system. Returns true if the request may be retried
+func (c *HTTPClient) request(ctx context.Context, order string) (models.Accrual, bool, error) {
+	acc := models.Accrual{}
+	err := c.wait(ctx)
+	if err != nil {
+		return acc, false, err
+	}
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return acc, false, ctx.Err()
+	}
+	defer func() { <-c.slots }()
+	ctx, span := tracer.Start(ctx, "accrual GET /api/orders/{number}", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(orderKey.String(order)))
+	defer span.End()
+	url := fmt.Sprintf("%s/api/orders/%s", c.address, order)
+	sublog.Debug().Msgf("Actual accrual system request is '%s'", url)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return acc, false, err
+	}
+	span.SetAttributes(semconv.HTTPClientAttributesFromHTTPRequest(request)...)
+	tracing.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	acc, retry, err := c.do(request)
+	result := outcome(ctx, retry, err)
+	metrics.AccrualRequests.WithLabelValues(result).Inc()
+	if err != nil && result != metrics.AccrualNotRegistered {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return acc, retry, err
+}
+
+//do - sending prepared request to accrual system and parsing the answer. Returns true if the request may be retried
+func (c *HTTPClient) do(request *http.Request) (models.Accrual, bool, error) {
+	acc := models.Accrual{}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return acc, request.Context().Err() == nil, err
+	}
+	defer response.Body.Close()
+	trace.SpanFromContext(request.Context()).SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(response.StatusCode)...)
+	switch {
+	case response.StatusCode == http.StatusOK:
+		err = json.NewDecoder(response.Body).Decode(&acc)
+		if err != nil {
+			return acc, false, fmt.Errorf("%w: %v", ErrBadResponse, err)
+		}
+		return acc, false, nil
+	case response.StatusCode == http.StatusNoContent:
+		return acc, false, ErrNotRegistered
+	case response.StatusCode == http.StatusTooManyRequests:
+		d := retryAfter(response.Header.Get("Retry-After"))
+		c.pause(d)
+		return acc, false, &RateLimitError{Retry: d}
+	case response.StatusCode >= http.StatusInternalServerError:
+		return acc, true, fmt.Errorf("%w: status code %d", ErrBadResponse, response.StatusCode)
+	}
+	return acc, false, fmt.Errorf("%w: status code %d", ErrBadResponse, response.StatusCode)
+}
@@ -0,0 +1,178 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/metrics"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestHTTPClient_GetOrder(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tests := []struct {
+		name    string
+		answers []int
+		want    models.Accrual
+		wantErr error
+		calls   int32
+	}{
+		{
+			name:    "Processed order",
+			answers: []int{http.StatusOK},
+			want:    models.Accrual{Order: "123455", Status: "PROCESSED", Value: 500 * models.Point},
+			calls:   1,
+		},
+		{
+			name:    "Not registered order",
+			answers: []int{http.StatusNoContent},
+			wantErr: ErrNotRegistered,
+			calls:   1,
+		},
+		{
+			name:    "Server errors are retried",
+			answers: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK},
+			want:    models.Accrual{Order: "123455", Status: "PROCESSED", Value: 500 * models.Point},
+			calls:   3,
+		},
+		{
+			name:    "Retries are limited",
+			answers: []int{http.StatusInternalServerError},
+			wantErr: ErrBadResponse,
+			calls:   retries + 1,
+		},
+		{
+			name:    "Client errors are not retried",
+			answers: []int{http.StatusBadRequest},
+			wantErr: ErrBadResponse,
+			calls:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&calls, 1)) - 1
+				if n >= len(tt.answers) {
+					n = len(tt.answers) - 1
+				}
+				w.WriteHeader(tt.answers[n])
+				if tt.answers[n] == http.StatusOK {
+					fmt.Fprint(w, `{"order":"123455","status":"PROCESSED","accrual":500}`)
+				}
+			}))
+			defer ts.Close()
+			c := New(&config.Config{AccSystem: ts.URL, AccrualRPS: 100, AccrualConcurrency: 1, AccrualTimeout: time.Second})
+			got, err := c.GetOrder(context.Background(), "123455")
+			if tt.wantErr != nil {
+				require.True(t, errors.Is(err, tt.wantErr))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.want, got)
+			}
+			require.Equal(t, tt.calls, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func Test_outcome(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		retry bool
+		err   error
+		want  string
+	}{
+		{name: "Success", ctx: context.Background(), want: metrics.AccrualOK},
+		{name: "Not registered", ctx: context.Background(), err: ErrNotRegistered, want: metrics.AccrualNotRegistered},
+		{name: "Rate limited", ctx: context.Background(), err: &RateLimitError{Retry: time.Second}, want: metrics.AccrualRateLimited},
+		{name: "Server error", ctx: context.Background(), retry: true, err: fmt.Errorf("%w: status code 500", ErrBadResponse), want: metrics.AccrualServerError},
+		{name: "Bad response", ctx: context.Background(), err: fmt.Errorf("%w: status code 400", ErrBadResponse), want: metrics.AccrualBadResponse},
+		{name: "Network error", ctx: context.Background(), retry: true, err: errors.New("connection refused"), want: metrics.AccrualNetworkError},
+		{name: "Cancelled", ctx: canceled, err: context.Canceled, want: metrics.AccrualCanceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, outcome(tt.ctx, tt.retry, tt.err))
+		})
+	}
+}
+
+func TestHTTPClient_pause(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"order":"123455","status":"PROCESSING"}`)
+	}))
+	defer ts.Close()
+	limitedBefore := testutil.ToFloat64(metrics.AccrualRequests.WithLabelValues(metrics.AccrualRateLimited))
+	c := New(&config.Config{AccSystem: ts.URL, AccrualRPS: 100, AccrualConcurrency: 4, AccrualTimeout: time.Second})
+	_, err := c.GetOrder(context.Background(), "123455")
+	var limited *RateLimitError
+	require.True(t, errors.As(err, &limited))
+	require.Equal(t, limitedBefore+1, testutil.ToFloat64(metrics.AccrualRequests.WithLabelValues(metrics.AccrualRateLimited)))
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.Equal(t, time.Second, limited.Retry)
+	start := time.Now()
+	got, err := c.GetOrder(context.Background(), "123455")
+	require.NoError(t, err)
+	require.Equal(t, "PROCESSING", got.Status)
+	require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.pause(time.Minute)
+	_, err = c.GetOrder(ctx, "123455")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func Test_retryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{header: "5", want: 5 * time.Second},
+		{header: "", want: defaultWait},
+		{header: "-1", want: defaultWait},
+		{header: "86400", want: maxWait},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			require.Equal(t, tt.want, retryAfter(tt.header))
+		})
+	}
+}
+
+func TestHTTPClient_Ping(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	var answer int32 = http.StatusNotFound
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&answer)))
+	}))
+	c := New(&config.Config{AccSystem: ts.URL, AccrualRPS: 1, AccrualConcurrency: 1, AccrualTimeout: time.Second})
+	c.pause(time.Minute)
+	require.NoError(t, c.Ping(context.Background()))
+	atomic.StoreInt32(&answer, http.StatusServiceUnavailable)
+	require.ErrorIs(t, c.Ping(context.Background()), ErrBadResponse)
+	ts.Close()
+	require.Error(t, c.Ping(context.Background()))
+}
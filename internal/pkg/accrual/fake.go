@@ -0,0 +1,82 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//answer - prepared answer of fake accrual system
+type answer struct {
+	acc models.Accrual
+	err error
+}
+
+//Fake - accrual system client returning prepared answers. Answers of the order are returned one by one, the last one repeats
+type Fake struct {
+	mu      sync.Mutex
+	answers map[string][]answer
+	calls   map[string]int
+	down    error
+}
+
+var _ Client = (*Fake)(nil)
+
+//NewFake - creating fake client without prepared answers. Unknown orders are not registered
+func NewFake() *Fake {
+	return &Fake{
+		answers: make(map[string][]answer),
+		calls:   make(map[string]int),
+	}
+}
+
+//Add - adding next answer for the order
+func (f *Fake) Add(number string, acc models.Accrual, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers[number] = append(f.answers[number], answer{acc: acc, err: err})
+}
+
+//Calls - count of GetOrder calls for the order
+func (f *Fake) Calls(number string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[number]
+}
+
+//SetDown - making Ping fail with err. Nil err makes fake system reachable again
+func (f *Fake) SetDown(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = err
+}
+
+//Ping - fake system is reachable unless SetDown was called with error
+func (f *Fake) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.down
+}
+
+//GetOrder - returning next prepared answer for the order
+func (f *Fake) GetOrder(ctx context.Context, number string) (models.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Accrual{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := f.calls[number]
+	f.calls[number]++
+	answers := f.answers[number]
+	if len(answers) == 0 {
+		return models.Accrual{}, ErrNotRegistered
+	}
+	if n >= len(answers) {
+		n = len(answers) - 1
+	}
+	return answers[n].acc, answers[n].err
+}
@@ -0,0 +1,41 @@
+package accrual
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestFake_GetOrder(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	f := NewFake()
+	f.Add("123455", models.Accrual{Order: "123455", Status: "PROCESSING"}, nil)
+	f.Add("123455", models.Accrual{Order: "123455", Status: "PROCESSED", Value: 10 * models.Point}, nil)
+	tests := []struct {
+		name    string
+		order   string
+		want    string
+		wantErr error
+	}{
+		{name: "First answer", order: "123455", want: "PROCESSING"},
+		{name: "Second answer", order: "123455", want: "PROCESSED"},
+		{name: "Last answer repeats", order: "123455", want: "PROCESSED"},
+		{name: "Unknown order", order: "12345678903", wantErr: ErrNotRegistered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.GetOrder(context.Background(), tt.order)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got.Status)
+		})
+	}
+	require.Equal(t, 3, f.Calls("123455"))
+}
@@ -0,0 +1,317 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi"
+	chiMiddleware "github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//Order statuses of accrual system
+const (
+	StatusRegistered = "REGISTERED" //Order registered, calculation not started
+	StatusProcessing = "PROCESSING" //Calculation in progress
+	StatusProcessed  = "PROCESSED"  //Calculation finished. Final status
+	StatusInvalid    = "INVALID"    //Order is not eligible for accrual. Final status
+)
+
+//Reward types
+const (
+	RewardPercent = "%"  //Reward is percent of the good's price
+	RewardPoints  = "pt" //Reward is fixed count of points
+)
+
+var sublog = log.With().Str("component", "accrual mock").Logger()
+
+//Good - one good of registered order
+type Good struct {
+	Description string       `json:"description"` //Good's description. Reward rules are matched against it
+	Price       models.Money `json:"price"`       //Good's price
+}
+
+//Reward - reward rule for goods with description containing Match
+type Reward struct {
+	Match  string       `json:"match"`       //Substring of good's description
+	Reward models.Money `json:"reward"`      //Reward value. Percent or points according to Type
+	Type   string       `json:"reward_type"` //Reward type. Allowed values are "%" and "pt"
+}
+
+//Fault - injected answer for next Count order requests
+type Fault struct {
+	Status     int `json:"status"`      //Answer status code. Allowed values are 204, 429 and 500
+	RetryAfter int `json:"retry_after"` //Value of Retry-After header in seconds for 429
+	Count      int `json:"count"`       //Count of requests answered with the fault
+}
+
+//order - registered order state
+type order struct {
+	models.Accrual
+	final string //Status the order gets after processing
+	polls int    //Count of polls in current status
+}
+
+//Server - accrual system mock. Order moves to the next status every Steps polls: REGISTERED, PROCESSING and then PROCESSED or INVALID
+type Server struct {
+	Steps int //Count of polls the order stays in every intermediate status
+
+	mu      sync.Mutex
+	orders  map[string]*order
+	rewards []Reward
+	faults  []Fault
+}
+
+//New - creating new mock without orders and reward rules
+func New(steps int) *Server {
+	if steps <= 0 {
+		steps = 1
+	}
+	return &Server{
+		Steps:  steps,
+		orders: make(map[string]*order),
+	}
+}
+
+//Router - creating mock router
+func (s *Server) Router(r chi.Router) {
+	r.Use(chiMiddleware.RequestID)
+	r.Use(chiMiddleware.Logger)
+	r.Use(chiMiddleware.Recoverer)
+
+	r.Get("/api/orders/{number}", s.getOrder) //Order state
+	r.Post("/api/orders", s.postOrder)        //Order registration
+	r.Post("/api/goods", s.postGoods)         //Reward rule registration
+
+	r.Post("/mock/faults", s.postFaults)             //Injecting faults into order requests
+	r.Delete("/mock/faults", s.deleteFaults)         //Removing injected faults
+	r.Put("/mock/orders/{number}", s.putOrder)       //Setting order state directly
+	r.Delete("/mock/orders/{number}", s.deleteOrder) //Forgetting order
+	r.Post("/mock/reset", s.postReset)               //Removing all orders, rules and faults
+}
+
+//writeJSON - writing value as JSON answer
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		sublog.Debug().Err(err).Msg("Error in http.ResponseWriter")
+	}
+}
+
+//nextFault - taking injected fault for the current request
+func (s *Server) nextFault() (Fault, bool) {
+	if len(s.faults) == 0 {
+		return Fault{}, false
+	}
+	f := s.faults[0]
+	s.faults[0].Count--
+	if s.faults[0].Count <= 0 {
+		s.faults = s.faults[1:]
+	}
+	return f, true
+}
+
+//advance - moving order to the next status if it was polled Steps times
+func (s *Server) advance(o *order) {
+	if o.Status == StatusProcessed || o.Status == StatusInvalid {
+		return
+	}
+	o.polls++
+	if o.polls <= s.Steps {
+		return
+	}
+	o.polls = 1
+	switch o.Status {
+	case StatusRegistered:
+		o.Status = StatusProcessing
+	case StatusProcessing:
+		o.Status = o.final
+		if o.final == StatusInvalid {
+			o.Value = 0
+		}
+	}
+}
+
+//getOrder - handling /api/orders/{number} on method GET
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.nextFault(); ok {
+		sublog.Info().Msgf("Injected fault %d for order %v", f.Status, number)
+		switch f.Status {
+		case http.StatusTooManyRequests:
+			w.Header().Set("Retry-After", strconv.Itoa(f.RetryAfter))
+			http.Error(w, "No more than N requests per minute allowed", http.StatusTooManyRequests)
+		case http.StatusNoContent:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Internal server error", f.Status)
+		}
+		return
+	}
+	o, ok := s.orders[number]
+	if !ok {
+		sublog.Info().Msgf("Order %v not registered", number)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.advance(o)
+	acc := o.Accrual
+	if acc.Status != StatusProcessed {
+		acc.Value = 0
+	}
+	writeJSON(w, http.StatusOK, acc)
+}
+
+//accrual - calculating order accrual by reward rules. The first matching rule is applied to every good
+func (s *Server) accrual(goods []Good) (models.Money, bool) {
+	var sum models.Money
+	matched := false
+	for _, g := range goods {
+		for _, rule := range s.rewards {
+			if !strings.Contains(g.Description, rule.Match) {
+				continue
+			}
+			matched = true
+			if rule.Type == RewardPercent {
+				sum += g.Price * rule.Reward / (100 * models.Point)
+			} else {
+				sum += rule.Reward
+			}
+			break
+		}
+	}
+	return sum, matched
+}
+
+//postOrder - handling /api/orders on method POST. Orders without goods matching any rule become INVALID
+func (s *Server) postOrder(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Order string `json:"order"`
+		Goods []Good `json:"goods"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Order == "" {
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[req.Order]; ok {
+		http.Error(w, "Order already registered", http.StatusConflict)
+		return
+	}
+	value, matched := s.accrual(req.Goods)
+	final := StatusProcessed
+	if !matched {
+		final = StatusInvalid
+	}
+	s.orders[req.Order] = &order{
+		Accrual: models.Accrual{Order: req.Order, Status: StatusRegistered, Value: value},
+		final:   final,
+	}
+	sublog.Info().Msgf("Order %v registered with accrual %v", req.Order, value)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+//postGoods - handling /api/goods on method POST
+func (s *Server) postGoods(w http.ResponseWriter, r *http.Request) {
+	rule := Reward{}
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil || rule.Match == "" || (rule.Type != RewardPercent && rule.Type != RewardPoints) {
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.rewards {
+		if existing.Match == rule.Match {
+			http.Error(w, "Reward already registered", http.StatusConflict)
+			return
+		}
+	}
+	s.rewards = append(s.rewards, rule)
+	sublog.Info().Msgf("Reward %v%v for %q registered", rule.Reward, rule.Type, rule.Match)
+	w.WriteHeader(http.StatusOK)
+}
+
+//postFaults - handling /mock/faults on method POST
+func (s *Server) postFaults(w http.ResponseWriter, r *http.Request) {
+	f := Fault{}
+	err := json.NewDecoder(r.Body).Decode(&f)
+	if err != nil {
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	if f.Status != http.StatusNoContent && f.Status != http.StatusTooManyRequests && f.Status != http.StatusInternalServerError {
+		http.Error(w, fmt.Sprintf("Unsupported fault status %d", f.Status), http.StatusBadRequest)
+		return
+	}
+	if f.Count <= 0 {
+		f.Count = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, f)
+	w.WriteHeader(http.StatusOK)
+}
+
+//deleteFaults - handling /mock/faults on method DELETE
+func (s *Server) deleteFaults(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+	w.WriteHeader(http.StatusOK)
+}
+
+//putOrder - handling /mock/orders/{number} on method PUT. Order gets the status and accrual from the body
+func (s *Server) putOrder(w http.ResponseWriter, r *http.Request) {
+	acc := models.Accrual{}
+	err := json.NewDecoder(r.Body).Decode(&acc)
+	if err != nil {
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	switch acc.Status {
+	case StatusRegistered, StatusProcessing, StatusProcessed, StatusInvalid:
+	default:
+		http.Error(w, fmt.Sprintf("Unknown status %q", acc.Status), http.StatusBadRequest)
+		return
+	}
+	acc.Order = chi.URLParam(r, "number")
+	final := acc.Status
+	if final != StatusInvalid {
+		final = StatusProcessed
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders[acc.Order] = &order{Accrual: acc, final: final}
+	w.WriteHeader(http.StatusOK)
+}
+
+//deleteOrder - handling /mock/orders/{number} on method DELETE
+func (s *Server) deleteOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.orders, chi.URLParam(r, "number"))
+	w.WriteHeader(http.StatusOK)
+}
+
+//postReset - handling /mock/reset on method POST
+func (s *Server) postReset(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders = make(map[string]*order)
+	s.rewards = nil
+	s.faults = nil
+	w.WriteHeader(http.StatusOK)
+}
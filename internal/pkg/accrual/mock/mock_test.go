@@ -0,0 +1,92 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func newMock(t *testing.T) *httptest.Server {
+	r := chi.NewRouter()
+	r.Route("/", New(1).Router)
+	return httptest.NewServer(r)
+}
+
+func request(t *testing.T, ts *httptest.Server, method, path, body string) (*http.Response, models.Accrual) {
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	acc := models.Accrual{}
+	if response.StatusCode == http.StatusOK && method == http.MethodGet {
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&acc))
+	}
+	return response, acc
+}
+
+func TestServer(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	ts := newMock(t)
+	defer ts.Close()
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		code       int
+		status     string
+		accrual    models.Money
+		retryAfter string
+	}{
+		{name: "Percent reward", method: http.MethodPost, path: "/api/goods", body: `{"match":"LG","reward":5,"reward_type":"%"}`, code: http.StatusOK},
+		{name: "Points reward", method: http.MethodPost, path: "/api/goods", body: `{"match":"Bork","reward":10.5,"reward_type":"pt"}`, code: http.StatusOK},
+		{name: "Duplicated reward", method: http.MethodPost, path: "/api/goods", body: `{"match":"LG","reward":7,"reward_type":"%"}`, code: http.StatusConflict},
+		{name: "Invalid reward type", method: http.MethodPost, path: "/api/goods", body: `{"match":"Asus","reward":7,"reward_type":"x"}`, code: http.StatusBadRequest},
+		{name: "Unknown order", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusNoContent},
+		{name: "Register order", method: http.MethodPost, path: "/api/orders", body: `{"order":"123455","goods":[{"description":"LG Monitor","price":50000.0},{"description":"Bork kettle","price":10}]}`, code: http.StatusAccepted},
+		{name: "Register duplicate", method: http.MethodPost, path: "/api/orders", body: `{"order":"123455","goods":[]}`, code: http.StatusConflict},
+		{name: "Registered", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusOK, status: StatusRegistered},
+		{name: "Processing", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusOK, status: StatusProcessing},
+		{name: "Inject 429", method: http.MethodPost, path: "/mock/faults", body: `{"status":429,"retry_after":3,"count":1}`, code: http.StatusOK},
+		{name: "Rate limited", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusTooManyRequests, retryAfter: "3"},
+		{name: "Processed", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusOK, status: StatusProcessed, accrual: 251050},
+		{name: "Inject 500", method: http.MethodPost, path: "/mock/faults", body: `{"status":500,"count":2}`, code: http.StatusOK},
+		{name: "Server error 1", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusInternalServerError},
+		{name: "Server error 2", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusInternalServerError},
+		{name: "Processed after faults", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusOK, status: StatusProcessed, accrual: 251050},
+		{name: "Inject 204", method: http.MethodPost, path: "/mock/faults", body: `{"status":204}`, code: http.StatusOK},
+		{name: "Not registered fault", method: http.MethodGet, path: "/api/orders/123455", code: http.StatusNoContent},
+		{name: "Unsupported fault", method: http.MethodPost, path: "/mock/faults", body: `{"status":418}`, code: http.StatusBadRequest},
+		{name: "Register order without rewards", method: http.MethodPost, path: "/api/orders", body: `{"order":"12345678903","goods":[{"description":"Asus laptop","price":1000}]}`, code: http.StatusAccepted},
+		{name: "Invalid registered", method: http.MethodGet, path: "/api/orders/12345678903", code: http.StatusOK, status: StatusRegistered},
+		{name: "Invalid processing", method: http.MethodGet, path: "/api/orders/12345678903", code: http.StatusOK, status: StatusProcessing},
+		{name: "Invalid", method: http.MethodGet, path: "/api/orders/12345678903", code: http.StatusOK, status: StatusInvalid},
+		{name: "Set order state", method: http.MethodPut, path: "/mock/orders/84410807816", body: `{"status":"PROCESSED","accrual":42}`, code: http.StatusOK},
+		{name: "Order state set", method: http.MethodGet, path: "/api/orders/84410807816", code: http.StatusOK, status: StatusProcessed, accrual: 42 * models.Point},
+		{name: "Reset", method: http.MethodPost, path: "/mock/reset", code: http.StatusOK},
+		{name: "Forgotten order", method: http.MethodGet, path: "/api/orders/84410807816", code: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, acc := request(t, ts, tt.method, tt.path, tt.body)
+			require.Equal(t, tt.code, response.StatusCode)
+			if tt.status != "" {
+				require.Equal(t, tt.status, acc.Status)
+				require.Equal(t, tt.accrual, acc.Value)
+			}
+			if tt.retryAfter != "" {
+				require.Equal(t, tt.retryAfter, response.Header.Get("Retry-After"))
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog/log"
+)
+
+//CookieName - name of the cookie holding session token
+const CookieName = "token"
+
+var (
+	ErrNoToken      = errors.New("authorization token is missing") //Request has neither Authorization header nor token cookie
+	ErrInvalidToken = errors.New("authorization token is invalid") //Token is malformed, expired or has wrong sign
+	ErrNoUser       = errors.New("user is not authorized")         //Request context has no authorized user
+)
+
+var sublog = log.With().Str("component", "auth").Logger()
+
+//contextKey - type of request context keys of the package
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	sessionKey
+)
+
+//Claims - session token claims. Subject is user's login and ID is session identifier
+type Claims struct {
+	jwt.RegisteredClaims
+}
+
+//Tokens - issuing and verifying session tokens signed with HMAC-SHA256
+type Tokens struct {
+	key []byte
+	ttl time.Duration
+}
+
+//New - creating token manager with signing key and token lifetime
+func New(key []byte, ttl time.Duration) *Tokens {
+	return &Tokens{key: key, ttl: ttl}
+}
+
+//TTL - lifetime of issued tokens
+func (t *Tokens) TTL() time.Duration {
+	return t.ttl
+}
+
+//challengeAudience - audience of second login step tokens. Session tokens have no audience
+const challengeAudience = "2fa"
+
+//Issue - creating signed token for the user's session. Returns token and its expiration time
+func (t *Tokens) Issue(login, session string) (string, time.Time, error) {
+	return t.issue(login, session, nil, t.ttl)
+}
+
+//IssueChallenge - creating short-lived token of the second login step. It proves the password check and is exchanged for session token after 2FA check
+func (t *Tokens) IssueChallenge(login string, ttl time.Duration) (string, time.Time, error) {
+	id, err := NewID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return t.issue(login, id, jwt.ClaimStrings{challengeAudience}, ttl)
+}
+
+//issue - signing claims
+func (t *Tokens) issue(login, id string, audience jwt.ClaimStrings, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(ttl)
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   login,
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.key)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while signing token")
+		return "", time.Time{}, err
+	}
+	sublog.Debug().Msgf("Token for user %v issued. Expires at %v", login, expires.Format(time.RFC3339))
+	return token, expires, nil
+}
+
+//Parse - verifying session token sign and expiration
+func (t *Tokens) Parse(token string) (Claims, error) {
+	claims, err := t.parse(token)
+	if err != nil {
+		return Claims{}, err
+	}
+	if len(claims.Audience) != 0 {
+		return Claims{}, fmt.Errorf("%w: not a session token", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+//ParseChallenge - verifying second login step token sign and expiration
+func (t *Tokens) ParseChallenge(token string) (Claims, error) {
+	claims, err := t.parse(token)
+	if err != nil {
+		return Claims{}, err
+	}
+	if !claims.VerifyAudience(challengeAudience, true) {
+		return Claims{}, fmt.Errorf("%w: not a second login step token", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+//parse - verifying token sign, expiration and subject
+func (t *Tokens) parse(token string) (Claims, error) {
+	claims := Claims{}
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return t.key, nil
+	})
+	if err != nil {
+		sublog.Debug().Err(err).Msg("Token is not valid")
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: subject is empty", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+//FromRequest - reading token from "Authorization: Bearer" header or from token cookie
+func FromRequest(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header != "" {
+		parts := strings.SplitN(header, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || parts[1] == "" {
+			return "", ErrInvalidToken
+		}
+		return strings.TrimSpace(parts[1]), nil
+	}
+	cookie, err := r.Cookie(CookieName)
+	if err != nil {
+		return "", ErrNoToken
+	}
+	return cookie.Value, nil
+}
+
+//NewID - generating random session identifier
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in random generator")
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//NewKey - generating random key of session tokens sign. Key is used when AUTH_KEY is not set and must never be logged
+func NewKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in random generator")
+		return nil, err
+	}
+	return key, nil
+}
+
+//NewResetToken - generating random password reset token. Returns the token for the user and its hash for the storage
+func NewResetToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in random generator")
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+//HashToken - hashing password reset token for the storage
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//WithUser - storing authorized user in context
+func WithUser(ctx context.Context, login string) context.Context {
+	return context.WithValue(ctx, userKey, login)
+}
+
+//User - reading authorized user from context
+func User(ctx context.Context) (string, error) {
+	login, ok := ctx.Value(userKey).(string)
+	if !ok || login == "" {
+		return "", ErrNoUser
+	}
+	return login, nil
+}
+
+//WithSession - storing session identifier of the request in context
+func WithSession(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionKey, id)
+}
+
+//Session - reading session identifier of the request from context
+func Session(ctx context.Context) (string, error) {
+	id, ok := ctx.Value(sessionKey).(string)
+	if !ok || id == "" {
+		return "", ErrNoUser
+	}
+	return id, nil
+}
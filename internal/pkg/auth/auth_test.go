@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokens(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tokens := New([]byte("secret"), time.Hour)
+	valid, expires, err := tokens.Issue("user1", "session1")
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Second)
+	expired, _, err := New([]byte("secret"), -time.Minute).Issue("user1", "session1")
+	require.NoError(t, err)
+	foreign, _, err := New([]byte("other secret"), time.Hour).Issue("user1", "session1")
+	require.NoError(t, err)
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "Valid token", token: valid},
+		{name: "Expired token", token: expired, wantErr: true},
+		{name: "Token signed with other key", token: foreign, wantErr: true},
+		{name: "Tampered token", token: valid + "x", wantErr: true},
+		{name: "Garbage", token: "garbage", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tokens.Parse(tt.token)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "user1", claims.Subject)
+			require.Equal(t, "session1", claims.ID)
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tests := []struct {
+		name    string
+		header  string
+		cookie  string
+		want    string
+		wantErr error
+	}{
+		{name: "Bearer header", header: "Bearer abc", want: "abc"},
+		{name: "Lower case scheme", header: "bearer abc", want: "abc"},
+		{name: "Header wins over cookie", header: "Bearer abc", cookie: "def", want: "abc"},
+		{name: "Cookie", cookie: "def", want: "def"},
+		{name: "Other scheme", header: "Basic abc", wantErr: ErrInvalidToken},
+		{name: "No token", wantErr: ErrNoToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: CookieName, Value: tt.cookie})
+			}
+			got, err := FromRequest(r)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	_, err := User(context.Background())
+	require.ErrorIs(t, err, ErrNoUser)
+	login, err := User(WithUser(context.Background(), "user1"))
+	require.NoError(t, err)
+	require.Equal(t, "user1", login)
+	_, err = Session(context.Background())
+	require.ErrorIs(t, err, ErrNoUser)
+	id, err := Session(WithSession(context.Background(), "session1"))
+	require.NoError(t, err)
+	require.Equal(t, "session1", id)
+}
+
+func TestTokens_Challenge(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tokens := New([]byte("secret"), time.Hour)
+	session, _, err := tokens.Issue("user1", "session1")
+	require.NoError(t, err)
+	challenge, expires, err := tokens.IssueChallenge("user1", time.Minute)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Minute), expires, time.Second)
+	expired, _, err := tokens.IssueChallenge("user1", -time.Minute)
+	require.NoError(t, err)
+
+	claims, err := tokens.ParseChallenge(challenge)
+	require.NoError(t, err)
+	require.Equal(t, "user1", claims.Subject)
+	_, err = tokens.Parse(challenge)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = tokens.ParseChallenge(session)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = tokens.ParseChallenge(expired)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestNewKey(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	key, err := NewKey()
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+	other, err := NewKey()
+	require.NoError(t, err)
+	require.NotEqual(t, key, other)
+}
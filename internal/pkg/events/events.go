@@ -0,0 +1,90 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+var sublog = log.With().Str("component", "events").Logger()
+
+//Hub - in-process publish/subscribe of user events. Every subscriber receives events of one user
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[string]map[*Subscription]struct{}
+	buffer int
+}
+
+//Subscription - stream of user's events. Channel is closed when subscriber is too slow or subscription is closed
+type Subscription struct {
+	C    chan models.UserEvent //Events of the user in order of publishing
+	hub  *Hub
+	user string
+}
+
+//NewHub - creating new hub. Buffer is the number of events kept for each subscriber before it is dropped
+func NewHub(buffer int) *Hub {
+	return &Hub{
+		subs:   make(map[string]map[*Subscription]struct{}),
+		buffer: buffer,
+	}
+}
+
+//Subscribe - starting delivery of user's events
+func (h *Hub) Subscribe(user string) *Subscription {
+	sub := &Subscription{
+		C:    make(chan models.UserEvent, h.buffer),
+		hub:  h,
+		user: user,
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[user] == nil {
+		h.subs[user] = make(map[*Subscription]struct{})
+	}
+	h.subs[user][sub] = struct{}{}
+	sublog.Debug().Msgf("User %v subscribed to events", user)
+	return sub
+}
+
+//Publish - delivering event to all subscribers of its user. It never blocks: subscriber with full buffer is dropped and has to resume by the last received event
+func (h *Hub) Publish(event models.UserEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[event.User] {
+		select {
+		case sub.C <- event:
+		default:
+			sublog.Info().Msgf("Subscriber of user %v is too slow. Dropping", event.User)
+			h.remove(sub)
+		}
+	}
+}
+
+//Subscribers - number of active subscriptions of the user
+func (h *Hub) Subscribers(user string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[user])
+}
+
+//remove - closing subscription. Caller must hold the lock
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub.user][sub]; !ok {
+		return
+	}
+	delete(h.subs[sub.user], sub)
+	if len(h.subs[sub.user]) == 0 {
+		delete(h.subs, sub.user)
+	}
+	close(sub.C)
+}
+
+//Close - stopping delivery. It is safe to call Close more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
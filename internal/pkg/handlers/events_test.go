@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//streamEvent - event read from server-sent events stream
+type streamEvent struct {
+	id   int64
+	kind string
+	data string
+}
+
+//openStream - connecting to the events stream of the user
+func openStream(t *testing.T, ctx context.Context, ts *httptest.Server, jar *cookiejar.Jar, last string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/user/events", nil)
+	require.NoError(t, err)
+	if last != "" {
+		req.Header.Set("Last-Event-ID", last)
+	}
+	response, err := (&http.Client{Jar: jar}).Do(req)
+	require.NoError(t, err)
+	return response, bufio.NewReader(response.Body)
+}
+
+//readEvent - reading the next event from the stream. Comments and reconnection delay are skipped
+func readEvent(t *testing.T, stream *bufio.Reader) streamEvent {
+	event := streamEvent{}
+	for {
+		line, err := stream.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.kind != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id, err = strconv.ParseInt(strings.TrimPrefix(line, "id: "), 10, 64)
+			require.NoError(t, err)
+		case strings.HasPrefix(line, "event: "):
+			event.kind = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestGophermart_getEvents(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = s.db.ListenUserEvents(ctx, s.hub.Publish)
+	}()
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		anonymous, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		response, _ := testRequest(t, ts, anonymous, http.MethodGet, "/api/user/events", "", nil)
+		require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+	t.Run("Invalid Last-Event-ID", func(t *testing.T) {
+		response, _ := testRequest(t, ts, jar, http.MethodGet, "/api/user/events", "", map[string]string{"Last-Event-ID": "abc"})
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	var first streamEvent
+	t.Run("Live events", func(t *testing.T) {
+		streamCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		response, stream := openStream(t, streamCtx, ts, jar, "")
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+		require.Eventually(t, func() bool { return s.hub.Subscribers("user111") == 1 }, time.Second, 10*time.Millisecond)
+
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/orders", "79927398713", map[string]string{"Content-Type": "text/plain"})
+		require.Equal(t, http.StatusAccepted, response.StatusCode)
+		first = readEvent(t, stream)
+		require.Equal(t, models.EventOrder, first.kind)
+		require.Contains(t, first.data, `"status":"NEW"`)
+
+		_, err := s.db.CompleteOrder(context.Background(), "79927398713", "user111", "", "PROCESSED", 500)
+		require.NoError(t, err)
+		event := readEvent(t, stream)
+		require.Equal(t, models.EventOrder, event.kind)
+		require.Contains(t, event.data, `"status":"PROCESSED"`)
+		event = readEvent(t, stream)
+		require.Equal(t, models.EventBalance, event.kind)
+		require.Contains(t, event.data, `"current":5`)
+	})
+	require.Eventually(t, func() bool { return s.hub.Subscribers("user111") == 0 }, time.Second, 10*time.Millisecond)
+
+	t.Run("Resume after Last-Event-ID", func(t *testing.T) {
+		streamCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		response, stream := openStream(t, streamCtx, ts, jar, strconv.FormatInt(first.id, 10))
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		event := readEvent(t, stream)
+		require.Equal(t, first.id+1, event.id)
+		require.Equal(t, models.EventOrder, event.kind)
+		event = readEvent(t, stream)
+		require.Equal(t, first.id+2, event.id)
+		require.Equal(t, models.EventBalance, event.kind)
+
+		require.NoError(t, s.db.CreateWithdraw(context.Background(), 200, "user111", "12345678903"))
+		event = readEvent(t, stream)
+		require.Equal(t, first.id+3, event.id)
+		require.Contains(t, event.data, `"delta":-2`)
+	})
+}
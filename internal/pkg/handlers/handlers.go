@@ -1,69 +1,171 @@
 package handlers
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
 	chiMiddleware "github.com/go-chi/chi/middleware"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/auth"
 	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/events"
 	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/metrics"
 	mymiddleware "github.com/t1mon-ggg/gophermart/internal/pkg/middleware"
 	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/notify"
 	"github.com/t1mon-ggg/gophermart/internal/pkg/storage"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/webhooks"
 )
 
 type Gophermart struct {
-	Config *config.Config
-	db     *storage.Database
+	Config   *config.Config
+	db       storage.Storage
+	tokens   *auth.Tokens
+	accrual  accrual.Client
+	notifier notify.Notifier
+	hub      *events.Hub
+	webhooks webhooks.Sender
+	workers  sync.WaitGroup
+	metrics  *prometheus.Registry
+
+	draining  chan struct{} //Closed when shutdown starts
+	drainOnce sync.Once
+}
+
+//publicPaths - paths available without authorization
+var publicPaths = map[string]bool{
+	"/":                                true,
+	"/api/user/register":               true,
+	"/api/user/login":                  true,
+	"/api/user/login/2fa":              true,
+	"/api/user/password/reset":         true,
+	"/api/user/password/reset/confirm": true,
+	"/livez":                           true,
+	"/readyz":                          true,
+	"/metrics":                         true,
 }
 
 //sublog - package handlers sub logger
 var sublog = log.With().Str("component", "handlers").Logger()
 
 //NewGopherMart - creating new application main work object
-func NewGopherMart() *Gophermart {
+func NewGopherMart(cfg *config.Config) *Gophermart {
 	app := Gophermart{}
-	app.Config = config.New()
-	s, err := storage.New(app.Config.DBPath)
-	if err != nil {
-		sublog.Error().Err(err).Msg("")
-		sublog.Fatal().Msg("Application critical error. Quiting")
+	app.Config = cfg
+	app.accrual = accrual.New(cfg)
+	app.notifier = notify.New(cfg)
+	app.hub = events.NewHub(eventsBuffer)
+	app.webhooks = webhooks.New(cfg)
+	app.draining = make(chan struct{})
+	key := []byte(cfg.AuthKey)
+	if len(key) == 0 {
+		var err error
+		key, err = auth.NewKey()
+		if err != nil {
+			sublog.Fatal().Err(err).Msg("Could not generate ephemeral session key. Quiting")
+			os.Exit(1)
+		}
+		sublog.Warn().Msg("AUTH_KEY is not set. Using ephemeral random key. Sessions will be lost on restart and will not work across instances")
+	}
+	app.tokens = auth.New(key, cfg.TokenTTL)
+	switch app.Config.Storage {
+	case "memory":
+		sublog.Info().Msg("Using in-memory storage. All data will be lost on exit")
+		app.db = storage.NewMemory()
+	case "postgres":
+		s, err := storage.New(app.Config.DBPath, app.Config.Migrate != "check")
+		if err != nil {
+			sublog.Error().Err(err).Msg("")
+			sublog.Fatal().Msg("Application critical error. Quiting")
+			os.Exit(1)
+		}
+		s.SetTimeouts(storage.Timeouts{Read: cfg.DBReadTimeout, Write: cfg.DBWriteTimeout})
+		app.db = s
+	default:
+		sublog.Fatal().Msgf("Unknown storage type %v. Quiting", app.Config.Storage)
 		os.Exit(1)
 	}
-	app.db = s
+	app.metrics = app.newMetrics()
 	return &app
 }
 
+//pooled - storage with connection pool statistics
+type pooled interface {
+	Stat() *pgxpool.Stat
+}
+
+//newMetrics - creating metrics registry with collectors of the storage
+func (s *Gophermart) newMetrics() *prometheus.Registry {
+	extra := []prometheus.Collector{metrics.NewOrdersCollector(s.db.CountPendingOrders)}
+	if p, ok := s.db.(pooled); ok {
+		extra = append(extra, metrics.NewPoolCollector(p.Stat))
+	}
+	return metrics.New(extra...)
+}
+
+//MetricsHandler - serving application metrics in Prometheus text format
+func (s *Gophermart) MetricsHandler() http.Handler {
+	return metrics.Handler(s.metrics)
+}
+
 //Router - creating working router
 func (s *Gophermart) Router(r chi.Router) {
 	r.Use(chiMiddleware.Compress(5))
 	r.Use(chiMiddleware.RequestID)
-	r.Use(chiMiddleware.RealIP)
+	r.Use(mymiddleware.RealIP(s.Config.TrustedProxies))
+	r.Use(mymiddleware.Tracing)
 	r.Use(chiMiddleware.Logger)
+	r.Use(mymiddleware.Metrics)
 	r.Use(chiMiddleware.Recoverer)
 	r.Use(mymiddleware.TimeTracer)
 	r.Use(mymiddleware.DecompressRequest)
 	r.Use(s.authChecker)
 
-	r.Post("/api/user/register", s.postRegister)                //All users
-	r.Post("/api/user/login", s.postLogin)                      //All users
-	r.Post("/api/user/orders", s.postOrders)                    //Authorized only
-	r.Get("/api/user/orders", s.getOrders)                      //Authorized only
-	r.Get("/api/user/balance", s.getBalance)                    //Authorized only
-	r.Post("/api/user/balance/withdraw", s.postBalanceWithdraw) //Authorized only
-	r.Get("/api/user/balance/withdraw", s.getBalanceWithdraw)   //Authorized only
-	r.MethodNotAllowed(otherHandler)                            //All users
-	r.NotFound(otherHandler)                                    //All users
+	if s.Config.MetricsAddress == "" {
+		r.Method(http.MethodGet, "/metrics", s.MetricsHandler()) //All users. Served on separate listener if METRICS_ADDRESS is set
+	}
+
+	r.Get("/livez", s.getLivez)                                            //All users
+	r.Get("/readyz", s.getReadyz)                                          //All users
+	r.Post("/api/user/register", s.postRegister)                           //All users
+	r.Post("/api/user/login", s.postLogin)                                 //All users
+	r.Post("/api/user/orders", s.postOrders)                               //Authorized only
+	r.Get("/api/user/orders", s.getOrders)                                 //Authorized only
+	r.Get("/api/user/orders/{number}", s.getOrder)                         //Authorized only
+	r.Get("/api/user/events", s.getEvents)                                 //Authorized only
+	r.Get("/api/user/balance", s.getBalance)                               //Authorized only
+	r.Post("/api/user/balance/withdraw", s.postBalanceWithdraw)            //Authorized only
+	r.Get("/api/user/balance/withdraw", s.getBalanceWithdraw)              //Authorized only
+	r.Get("/api/user/balance/history", s.getBalanceHistory)                //Authorized only
+	r.Post("/api/user/logout", s.postLogout)                               //Authorized only
+	r.Get("/api/user/sessions", s.getSessions)                             //Authorized only
+	r.Delete("/api/user/sessions/{id}", s.deleteSession)                   //Authorized only
+	r.Post("/api/user/password", s.postPassword)                           //Authorized only
+	r.Post("/api/user/password/reset", s.postPasswordReset)                //All users
+	r.Post("/api/user/password/reset/confirm", s.postPasswordResetConfirm) //All users
+	r.Post("/api/user/login/2fa", s.postLoginMFA)                          //All users with second login step token
+	r.Post("/api/user/2fa/enroll", s.postMFAEnroll)                        //Authorized only
+	r.Post("/api/user/2fa/confirm", s.postMFAConfirm)                      //Authorized only
+	r.Post("/api/user/2fa/verify", s.postMFAVerify)                        //Authorized only
+	r.Post("/api/user/2fa/disable", s.postMFADisable)                      //Authorized only
+	r.Route("/api/user/webhooks", s.webhookRoutes(helpers.GetUser))        //Authorized only
+	r.Route("/api/admin", s.adminRoutes)                                   //Support and admin only
+	r.MethodNotAllowed(otherHandler)                                       //All users
+	r.NotFound(otherHandler)                                               //All users
 }
 
 //postRegister - handling/api/user/register on method POST
@@ -82,14 +184,13 @@ func (s *Gophermart) postRegister(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	sublog.Debug().Msgf("Recieved body %s", string(body))
 	err = json.Unmarshal(body, &newuser)
 	if err != nil {
 		sublog.Error().Err(err).Msg("Error while parsing JSON body")
 		http.Error(w, "Incorrect request format", http.StatusBadRequest)
 		return
 	}
-	sublog.Debug().Msgf("Parsed from json. Name: %v, Password: %v", newuser.Name, newuser.Password)
+	sublog.Debug().Msgf("Parsed from json. Name: %v", newuser.Name)
 	if newuser.Name == "" || newuser.Password == "" {
 		sublog.Error().Err(err).Msg("Wrong user data")
 		http.Error(w, "Incorrect request format", http.StatusBadRequest)
@@ -100,18 +201,23 @@ func (s *Gophermart) postRegister(w http.ResponseWriter, r *http.Request) {
 		sublog.Error().Err(err).Msg("")
 	}
 	iv := helpers.RandStringRunes(12)
-	err = s.db.CreateUser(newuser.Name, pass, iv)
+	err = s.db.CreateUser(r.Context(), newuser.Name, pass, iv)
 	if err != nil {
 		if helpers.UserConflict(err) {
 			sublog.Info().Msgf("User %v already exist", newuser.Name)
 			http.Error(w, "User already exists", http.StatusConflict)
 			return
 		}
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
-	helpers.SetCookie(w, "username", newuser.Name)
-	helpers.SetCookie(w, "user_id", helpers.GenerateCookieValue(newuser.Name, pass, r.RemoteAddr, iv))
+	err = s.authorize(w, r, newuser.Name, time.Time{})
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	s.audit(r, models.AuditEvent{Actor: newuser.Name, Action: actionRegister, Target: "user:" + newuser.Name})
+	metrics.Registrations.Inc()
 	sublog.Info().Msgf("User %v registered", newuser.Name)
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write([]byte{})
@@ -136,32 +242,67 @@ func (s *Gophermart) postLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	sublog.Debug().Msgf("Recieved body %s", string(body))
 	err = json.Unmarshal(body, &user)
 	if err != nil {
 		sublog.Error().Err(err).Msg("Error while parsing JSON body")
 		http.Error(w, "Incorrect request format", http.StatusBadRequest)
 		return
 	}
-	sublog.Debug().Msgf("Parsed from json. Login: %v, Password: %v", user.Name, user.Password)
-	u, err := s.db.GetUser(user.Name)
+	sublog.Debug().Msgf("Parsed from json. Login: %v", user.Name)
+	ip := clientIP(r)
+	now := time.Now()
+	attempt, wait, err := s.reserveLogin(r.Context(), user.Name, ip, now)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if wait > 0 {
+		sublog.Info().Msgf("Login of user %v from %v throttled for %v", user.Name, ip, wait)
+		retryAfter(w, wait)
+		return
+	}
+	defer attempt.release(r.Context())
+	u, err := s.db.GetUser(r.Context(), user.Name)
 	if err != nil {
 		if helpers.EmptyRow(err) {
 			sublog.Info().Msgf("User %s not found", user.Name)
+			helpers.DummyCompare(user.Password)
+			attempt.failed(r.Context())
+			s.audit(r, models.AuditEvent{Actor: user.Name, Action: actionLoginFailed, Target: "user:" + user.Name, Reason: "unknown user"})
 			http.Error(w, "Wrond username or password", http.StatusUnauthorized)
 			return
 		}
 		sublog.Error().Err(err).Msg("")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 	if !helpers.ComparePassword(user.Password, u.Password) {
-		sublog.Info().Msgf("Password %v invalid", user.Password)
+		sublog.Info().Msgf("Password of user %v invalid", user.Name)
+		attempt.failed(r.Context())
+		s.audit(r, models.AuditEvent{Actor: user.Name, Action: actionLoginFailed, Target: "user:" + user.Name, Reason: "wrong password"})
 		http.Error(w, "Wrond username or password", http.StatusUnauthorized)
 		return
 	}
-	helpers.SetCookie(w, "username", user.Name)
-	helpers.SetCookie(w, "user_id", helpers.GenerateCookieValue(user.Name, u.Password, r.RemoteAddr, u.Random))
+	if u.Blocked {
+		sublog.Info().Msgf("User %v is blocked", user.Name)
+		s.audit(r, models.AuditEvent{Actor: user.Name, Action: actionLoginFailed, Target: "user:" + user.Name, Reason: "blocked"})
+		http.Error(w, "Account is blocked", http.StatusForbidden)
+		return
+	}
+	if u.TOTPEnabled {
+		s.mfaChallenge(w, user.Name)
+		return
+	}
+	err = s.db.ResetThrottle(r.Context(), models.ThrottleUser, user.Name)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Reset login throttle error")
+	}
+	err = s.authorize(w, r, user.Name, time.Time{})
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	s.audit(r, models.AuditEvent{Actor: user.Name, Action: actionLogin, Target: "user:" + user.Name})
 	sublog.Info().Msgf("User %v authorized", user.Name)
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write([]byte{})
@@ -199,7 +340,7 @@ func (s *Gophermart) postOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	sublog.Debug().Msgf("New order %v from user %v", order, user)
-	err = s.db.CreateOrder(order, user)
+	err = s.db.CreateOrder(r.Context(), order, user)
 	if err != nil {
 		if helpers.OrderUnique(err) {
 			sublog.Info().Msg("Order already exist. Created by another user")
@@ -222,9 +363,11 @@ func (s *Gophermart) postOrders(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		sublog.Error().Err(err).Msg("Create order error")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
+	s.audit(r, models.AuditEvent{Action: actionOrderUpload, Target: "order:" + order, After: auditState(map[string]string{"user": user, "status": "NEW"})})
+	metrics.OrdersAccepted.Inc()
 	sublog.Info().Msg("Order successfully created")
 	w.WriteHeader(http.StatusAccepted)
 	i, err := w.Write([]byte("Order accepted"))
@@ -232,7 +375,6 @@ func (s *Gophermart) postOrders(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Debug().Err(err).Msg("Error in http.ResponseWriter")
 	}
-	go s.accrualAPI(user, order)
 }
 
 //getBalance - handling/api/user/orders on method GET
@@ -244,16 +386,28 @@ func (s *Gophermart) getOrders(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	o, err := s.db.GetOrders(user)
+	query, err := orderQuery(r)
+	if err != nil {
+		sublog.Info().Err(err).Msg("Invalid orders query")
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	limit := query.Limit
+	query.Limit++
+	o, err := s.db.ListOrders(r.Context(), user, query)
 	if err != nil {
 		sublog.Error().Err(err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
+	if len(o) > limit {
+		o = o[:limit]
+		setNextPage(w, r, encodeCursor(o[limit-1]))
+	}
 	sublog.Debug().Msgf("Get_Orders result is %v", o)
 	if len(o) == 0 {
 		sublog.Debug().Msg("Orders not found")
-		http.Error(w, "No orders found", http.StatusNoContent)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 	body, err := json.Marshal(o)
@@ -283,10 +437,10 @@ func (s *Gophermart) getBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	sublog.Debug().Msgf("Get_Balance user is %v", user)
-	balance, err := s.db.GetBalance(user)
+	balance, err := s.db.GetBalance(r.Context(), user)
 	if err != nil {
 		sublog.Error().Err(err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 	sublog.Debug().Msgf("User's %v balance is %v and withdraw is %v", user, balance.Balance, balance.Withdraws)
@@ -310,8 +464,8 @@ func (s *Gophermart) getBalance(w http.ResponseWriter, r *http.Request) {
 //postBalanceWithdraw - handling/api/user/balance/withdraw on method POST
 func (s *Gophermart) postBalanceWithdraw(w http.ResponseWriter, r *http.Request) {
 	type withdrawn struct {
-		Number string  `json:"order"`
-		Sum    float32 `json:"sum"`
+		Number string       `json:"order"`
+		Sum    models.Money `json:"sum"`
 	}
 	sublog.Info().Msg("Processing request of a new withdrawn")
 	ctype := r.Header.Get("Content-Type")
@@ -336,6 +490,11 @@ func (s *Gophermart) postBalanceWithdraw(w http.ResponseWriter, r *http.Request)
 	sublog.Debug().Msgf("Recieved body: %v", string(body))
 	err = json.Unmarshal(body, &a)
 	if err != nil {
+		if errors.Is(err, models.ErrMoneyPrecision) {
+			sublog.Info().Msg("Withdraw sum has too many decimals")
+			http.Error(w, "Invalid sum", http.StatusUnprocessableEntity)
+			return
+		}
 		sublog.Error().Err(err).Msg("Error while parsing JSON body")
 		http.Error(w, "Incorrect request format", http.StatusBadRequest)
 		return
@@ -346,14 +505,48 @@ func (s *Gophermart) postBalanceWithdraw(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid order number", http.StatusUnprocessableEntity)
 		return
 	}
-	err = s.db.CreateWithdraw(a.Sum, user, a.Number)
+	if a.Sum <= 0 {
+		sublog.Info().Msg("Withdraw sum is not positive")
+		http.Error(w, "Invalid sum", http.StatusUnprocessableEntity)
+		return
+	}
+	if s.Config.WithdrawMFA > 0 && a.Sum > s.Config.WithdrawMFA {
+		fresh, err := s.freshMFA(r, time.Now())
+		if err != nil {
+			sublog.Error().Err(err).Msg("Get session error")
+			internalError(w, err)
+			return
+		}
+		if !fresh {
+			u, err := s.db.GetUser(r.Context(), user)
+			if err != nil {
+				sublog.Error().Err(err).Msg("Get user error")
+				internalError(w, err)
+				return
+			}
+			if !u.TOTPEnabled {
+				sublog.Info().Msgf("Withdraw %v of user %v requires 2FA enrolment", a.Sum, user)
+				http.Error(w, "Two-factor authentication must be enabled to withdraw more than "+s.Config.WithdrawMFA.String()+". Enroll with POST /api/user/2fa/enroll", http.StatusForbidden)
+				return
+			}
+			sublog.Info().Msgf("Withdraw %v of user %v requires fresh 2FA check", a.Sum, user)
+			http.Error(w, "Fresh two-factor authentication required", http.StatusForbidden)
+			return
+		}
+	}
+	err = s.db.CreateWithdraw(r.Context(), a.Sum, user, a.Number)
 	if err != nil {
 		if helpers.BalanceTooLow(err) {
 			sublog.Info().Msg("Not enough bonuses on the balance")
 			http.Error(w, "There are not enough funds in the account", http.StatusPaymentRequired)
 			return
 		}
+		sublog.Error().Err(err).Msg("Create withdraw error")
+		internalError(w, err)
+		return
 	}
+	s.audit(r, models.AuditEvent{Action: actionWithdraw, Target: "user:" + user, After: auditState(a)})
+	metrics.PointsWithdrawn.Add(metrics.Points(int64(a.Sum)))
 	sublog.Info().Msg("Withdrwan successfulyy processed")
 	w.WriteHeader(http.StatusOK)
 	i, err := w.Write(body)
@@ -373,7 +566,7 @@ func (s *Gophermart) getBalanceWithdraw(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	sublog.Debug().Msgf("Get_Withdraws for %v", user)
-	withdraws, err := s.db.GetWithdraws(user)
+	withdraws, err := s.db.GetWithdraws(r.Context(), user)
 	if err != nil {
 		if helpers.EmptyRow(err) {
 			sublog.Info().Msg("Withdraws not found")
@@ -381,7 +574,7 @@ func (s *Gophermart) getBalanceWithdraw(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		sublog.Info().Msg("Error in requsting withdraws")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 	if len(withdraws) == 0 {
@@ -407,154 +600,188 @@ func (s *Gophermart) getBalanceWithdraw(w http.ResponseWriter, r *http.Request)
 
 }
 
+//getBalanceHistory - handling/api/user/balance/history on method GET
+func (s *Gophermart) getBalanceHistory(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing request of a balance history")
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sublog.Debug().Msgf("Get_Ledger for %v", user)
+	entries, err := s.db.GetLedger(r.Context(), user)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in requsting balance history")
+		internalError(w, err)
+		return
+	}
+	if len(entries) == 0 {
+		sublog.Info().Msg("Balance history is empty")
+		http.Error(w, "Balance history is empty", http.StatusNoContent)
+		return
+	}
+	body, err := json.Marshal(entries)
+	if err != nil {
+		sublog.Error().Err(err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	sublog.Info().Msg("Request of user's balance history complete")
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	i, err := w.Write(body)
+	log.Debug().Msgf("%v bytes wrote to ResponseWriter", i)
+	if err != nil {
+		log.Debug().Err(err).Msg("Error in http.ResponseWriter")
+	}
+}
+
 //getBalanceWithdraw - handling other paths and methods
 func otherHandler(w http.ResponseWriter, r *http.Request) {
 	sublog.Info().Msg("Wrong request recieved")
 	http.Error(w, "Wrong request format", http.StatusBadRequest)
 }
 
+//internalError - answering to the request failed with storage error. Requests cancelled by the client or stopped by storage timeout are answered with 503, other errors with 500
+func internalError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrCanceled) {
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+//writeJSON - writing value as JSON answer
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(code)
+	i, err := w.Write(body)
+	log.Debug().Msgf("%v bytes wrote to ResponseWriter", i)
+	if err != nil {
+		log.Debug().Err(err).Msg("Error in http.ResponseWriter")
+	}
+}
+
 //AuthChecker - checking authorization cookies in requests
 func (s *Gophermart) authChecker(next http.Handler) http.Handler {
 	sublog.Debug().Msg("Request authorization tokens check")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var value string
-		var user string
-		free := false
-		if r.RequestURI == "/api/user/register" || r.RequestURI == "/api/user/login" || r.RequestURI == "/" {
+		if publicPaths[r.URL.Path] {
 			sublog.Debug().Msg("Skip auth check. All users area")
-			free = true
 			next.ServeHTTP(w, r)
+			return
 		}
-		if !free {
-			cookies := r.Cookies()
-			if len(cookies) == 0 {
-				sublog.Debug().Msg("No cookies in request")
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			foundC := false
-			foundU := false
-			for _, cookie := range cookies {
-				if cookie.Name == "user_id" {
-					foundC = true
-					sublog.Debug().Msg("Cookie 'user_id' was found")
-					value = cookie.Value
-				}
-				if cookie.Name == "username" {
-					foundU = true
-					sublog.Debug().Msg("Cookie 'username' was found")
-					user = cookie.Value
-				}
-			}
-			if !foundU || !foundC {
-				sublog.Debug().Msg("Cookies 'username' or 'user_id' was not found")
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			u, err := s.db.GetUser(user)
-			if err != nil {
-				sublog.Error().Err(err).Msg("Get user info error")
-				if helpers.EmptyRow(err) {
-					sublog.Debug().Msg("User not found")
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-			ip := r.RemoteAddr
-			if !helpers.CompareCookie(value, user, u.Password, ip, u.Random) {
-				sublog.Debug().Msg("Cookie is not valid")
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			sublog.Debug().Msg("Authorization cookie processing end")
-			next.ServeHTTP(w, r)
+		token, err := auth.FromRequest(r)
+		if err != nil {
+			sublog.Debug().Err(err).Msg("Authorization token not found")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
-	})
-}
-
-func (s *Gophermart) accrualAPI(login, order string) {
-	state := "NEW"
-	subsublog := sublog.With().Str("subcomponent", "accrual api").Logger()
-	subsublog.Info().Msg("Processing new order withh accrual service.")
-	acc := models.Accrual{}
-	url := fmt.Sprintf("%s/api/orders/%s", s.Config.AccSystem, order)
-	subsublog.Debug().Msgf("Actual accrual system request is '%s'", url)
-	client := http.Client{}
-	request, err := http.NewRequest(http.MethodGet, url, bytes.NewReader([]byte{}))
-	if err != nil {
-		subsublog.Error().Err(err).Msg("Error in creating request to accrual system")
-		return
-	}
-	var wait bool
-	for !wait {
-		response, err := client.Do(request)
+		claims, err := s.tokens.Parse(token)
 		if err != nil {
-			subsublog.Error().Err(err).Msg("Error in request to accrual system")
+			sublog.Debug().Err(err).Msg("Authorization token is not valid")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		defer response.Body.Close()
-		if response.StatusCode != http.StatusOK {
-			if response.StatusCode == http.StatusNoContent {
-				subsublog.Info().Msgf("Order %v not registered in accrual system. Stopping goroutine.", order)
+		session, err := s.db.GetSession(r.Context(), claims.ID)
+		if err != nil {
+			if helpers.EmptyRow(err) {
+				sublog.Debug().Msg("Session is closed or revoked")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			if response.StatusCode == http.StatusTooManyRequests {
-				retryTime := response.Header.Get("Retry-After")
-				t, err := strconv.Atoi(retryTime)
-				if err != nil {
-					log.Debug().Err(err).Msg("String to int convertation failed")
-					t = 60
-				}
-				time.Sleep(time.Duration(t) * time.Second)
-				continue
-			} else {
-				subsublog.Debug().Msgf("Status code not 200. Recieved code %d. Waiting for 1 second to the next try", response.StatusCode)
-				time.Sleep(1 * time.Second)
-				continue
-			}
-
-		}
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			subsublog.Error().Err(err)
+			sublog.Error().Err(err).Msg("Get session error")
+			internalError(w, err)
+			return
 		}
-		subsublog.Debug().Msgf("Recieved json is %v", string(body))
-		err = json.Unmarshal(body, &acc)
-		if err != nil {
-			subsublog.Error().Err(err).Msg("Error in unmarshaling answer from accrual system")
+		if session.User != claims.Subject {
+			sublog.Info().Msgf("Session %v does not belong to user %v", session.ID, claims.Subject)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
-		sublog.Debug().Msgf("Parsed from json. Order: %v, Status: %v, Accrual: %v", acc.Order, acc.Status, acc.Value)
-		if acc.Status == "INVALID" || acc.Status == "PROCESSED" {
-			subsublog.Debug().Msg("Accrual calculation in progress.")
-			wait = true
+		now := time.Now()
+		if !s.sessionAlive(session, now) {
+			sublog.Info().Msgf("Session of user %v expired", session.User)
+			err = s.db.DeleteSession(r.Context(), session.User, session.ID)
+			if err != nil && !helpers.EmptyRow(err) {
+				sublog.Error().Err(err).Msg("Delete session error")
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
-		if (acc.Status == "REGISTERED" || acc.Status == "PROCESSING") && acc.Status != state {
-			state = acc.Status
-			err = s.db.UpdateOrder(order, acc.Status, 0)
+		ip := clientIP(r)
+		if now.Sub(session.LastSeen) > sessionTouch || ip != session.IP {
+			err = s.db.TouchSession(r.Context(), session.ID, ip, now)
 			if err != nil {
-				subsublog.Error().Err(err)
+				sublog.Error().Err(err).Msg("Touch session error")
 			}
 		}
-		if !wait {
-			sublog.Debug().Msg("Waiting for 1 seeconds to the next try")
-			time.Sleep(1 * time.Second)
+		sublog.Debug().Msgf("Request authorized as user %v", session.User)
+		ctx := auth.WithSession(auth.WithUser(r.Context(), session.User), session.ID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//accrualAPI - polling accrual system for the order once. Returns delay before the next poll or zero when order got final status or the job is not held by the worker any more.
+//Empty worker completes the order without the job lease check
+func (s *Gophermart) accrualAPI(ctx context.Context, login, order, worker string) time.Duration {
+	subsublog := sublog.With().Str("subcomponent", "accrual api").Str("order", order).Logger()
+	subsublog.Info().Msg("Polling accrual service for the order")
+	poll, cancel := context.WithTimeout(ctx, s.accrualDeadline())
+	acc, err := s.accrual.GetOrder(poll, order)
+	cancel()
+	var limited *accrual.RateLimitError
+	switch {
+	case err != nil && ctx.Err() != nil:
+		subsublog.Info().Msg("Polling interrupted by shutdown. Returning order to the queue")
+		return accrualRetry
+	case errors.As(err, &limited):
+		subsublog.Info().Msgf("Accrual system is overloaded. Waiting for %v", limited.Retry)
+		return limited.Retry
+	case errors.Is(err, accrual.ErrNotRegistered):
+		subsublog.Info().Msgf("Order %v not registered in accrual system", order)
+		return accrualRetry
+	case err != nil:
+		subsublog.Error().Err(err).Msg("Error in request to accrual system")
+		return accrualRetry
+	}
+	subsublog.Debug().Msgf("Parsed from json. Order: %v, Status: %v, Accrual: %v", acc.Order, acc.Status, acc.Value)
+	//Answer of accrual system is stored even when shutdown has started. Storage timeout bounds the calls
+	store := tracing.Detach(ctx)
+	switch acc.Status {
+	case "REGISTERED", "PROCESSING":
+		subsublog.Debug().Msg("Accrual calculation in progress.")
+		err = s.db.UpdateOrder(store, order, acc.Status, 0)
+		if err != nil {
+			subsublog.Error().Err(err).Msg("")
 		}
-	}
-	if acc.Status == "INVALID" {
+		return accrualPoll
+	case "INVALID":
 		acc.Value = 0
+	case "PROCESSED":
+	default:
+		subsublog.Error().Msgf("Unknown accrual status %v", acc.Status)
+		return accrualPoll
 	}
-	subsublog.Info().Msgf("Accrual processing complete. Processing status is %v", acc.Status)
-	err = s.db.UpdateOrder(order, acc.Status, acc.Value)
-	if err != nil {
-		subsublog.Error().Err(err)
-		return
+	applied, err := s.db.CompleteOrder(store, order, login, worker, acc.Status, acc.Value)
+	if errors.Is(err, storage.ErrLeaseLost) {
+		subsublog.Warn().Msg("Lease of the order expired before completion. Job belongs to another worker")
+		return 0
 	}
-	err = s.db.UpdateBalance(login, acc.Value)
 	if err != nil {
-		subsublog.Error().Err(err)
-		return
+		subsublog.Error().Err(err).Msg("")
+		return accrualRetry
+	}
+	if applied {
+		metrics.PointsAccrued.Add(metrics.Points(int64(acc.Value)))
 	}
-	subsublog.Info().Msgf("Accrual order %v processing complete. Exit from goroutine", order)
+	subsublog.Info().Msgf("Accrual order %v processing complete. Processing status is %v", order, acc.Status)
+	return 0
 }
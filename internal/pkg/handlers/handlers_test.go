@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -22,12 +23,21 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/auth"
 	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/events"
 	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/notify"
 	"github.com/t1mon-ggg/gophermart/internal/pkg/storage"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/webhooks"
 )
 
-func dbClear(db *storage.Database, t *testing.T) error {
+func dbClear(s storage.Storage, t *testing.T) error {
+	db, ok := s.(*storage.Database)
+	if !ok {
+		return nil
+	}
 	err := db.DeleteContent("orders")
 	assert.NoError(t, err)
 	if err != nil {
@@ -43,6 +53,16 @@ func dbClear(db *storage.Database, t *testing.T) error {
 	if err != nil {
 		return err
 	}
+	err = db.DeleteContent("user_events")
+	assert.NoError(t, err)
+	if err != nil {
+		return err
+	}
+	err = db.DeleteContent("ledger")
+	assert.NoError(t, err)
+	if err != nil {
+		return err
+	}
 	err = db.DeleteContent("users")
 	assert.NoError(t, err)
 	if err != nil {
@@ -61,9 +81,41 @@ func newServer(t *testing.T) (*cookiejar.Jar, *chi.Mux, *Gophermart) {
 			AccSystem: "http://127.0.0.1:8080",
 		},
 	}
-	s, err := storage.New(mart.Config.DBPath)
+	mart.accrual = accrual.New(mart.Config)
+	mart.tokens = auth.New([]byte("secret"), time.Hour)
+	mart.notifier = notify.NewFake()
+	mart.hub = events.NewHub(eventsBuffer)
+	mart.webhooks = webhooks.New(mart.Config)
+	mart.draining = make(chan struct{})
+	mart.Config.ResetTTL = time.Hour
+	s, err := storage.New(mart.Config.DBPath, true)
 	require.NoError(t, err)
 	mart.db = s
+	mart.metrics = mart.newMetrics()
+	r := chi.NewRouter()
+	r.Route("/", mart.Router)
+	return jar, r, &mart
+}
+
+func newMemoryServer(t *testing.T) (*cookiejar.Jar, *chi.Mux, *Gophermart) {
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	mart := Gophermart{
+		Config: &config.Config{
+			Bind:      "",
+			AccSystem: "http://127.0.0.1:8080",
+			Storage:   "memory",
+		},
+		db: storage.NewMemory(),
+	}
+	mart.accrual = accrual.New(mart.Config)
+	mart.tokens = auth.New([]byte("secret"), time.Hour)
+	mart.notifier = notify.NewFake()
+	mart.hub = events.NewHub(eventsBuffer)
+	mart.webhooks = webhooks.New(mart.Config)
+	mart.draining = make(chan struct{})
+	mart.Config.ResetTTL = time.Hour
+	mart.metrics = mart.newMetrics()
 	r := chi.NewRouter()
 	r.Route("/", mart.Router)
 	return jar, r, &mart
@@ -578,8 +630,10 @@ func TestGophermart_AccrualAPI(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s.accrualAPI(tt.login, tt.order)
-			orders, _ := s.db.GetOrders(tt.login)
+			for s.accrualAPI(context.Background(), tt.login, tt.order, "") != 0 {
+				time.Sleep(accrualPoll)
+			}
+			orders, _ := s.db.GetOrders(context.Background(), tt.login)
 			for _, order := range orders {
 				status := false
 				if order.Status == "INVALID" || order.Status == "PROCESSED" {
@@ -824,11 +878,11 @@ func TestGophermart_getBalanceWithdraw(t *testing.T) {
 				withdraws: []models.Withdraw{
 					{
 						Number:   "577277243060172",
-						Withdraw: 1000,
+						Withdraw: 1000 * models.Point,
 					},
 					{
 						Number:   "84410807816",
-						Withdraw: 1,
+						Withdraw: 1 * models.Point,
 					},
 				},
 			},
@@ -981,3 +1035,139 @@ func TestGophermart_GzipSupportResp(t *testing.T) {
 	err := dbClear(s.db, t)
 	require.NoError(t, err)
 }
+
+func TestGophermart_MemoryStorage(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	type postWithdrawn struct {
+		Order string  `json:"order"`
+		Sum   float32 `json:"sum"`
+	}
+	user := models.User{
+		Name:     "user111",
+		Password: "password111",
+	}
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, user), map[string]string{"Content-Type": "application/json"})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		ctype  map[string]string
+		want   int
+	}{
+		{
+			name:   "New order",
+			method: http.MethodPost,
+			path:   "/api/user/orders",
+			body:   "123455",
+			ctype:  map[string]string{"Content-Type": "text/plain"},
+			want:   http.StatusAccepted,
+		},
+		{
+			name:   "Duplicate order by same user",
+			method: http.MethodPost,
+			path:   "/api/user/orders",
+			body:   "123455",
+			ctype:  map[string]string{"Content-Type": "text/plain"},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "List orders",
+			method: http.MethodGet,
+			path:   "/api/user/orders",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "Balance",
+			method: http.MethodGet,
+			path:   "/api/user/balance",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "Withdraw with low balance",
+			method: http.MethodPost,
+			path:   "/api/user/balance/withdraw",
+			body:   userReq(t, postWithdrawn{Order: "84410807816", Sum: 1}),
+			ctype:  map[string]string{"Content-Type": "application/json"},
+			want:   http.StatusPaymentRequired,
+		},
+		{
+			name:   "Withdraw with too precise sum",
+			method: http.MethodPost,
+			path:   "/api/user/balance/withdraw",
+			body:   `{"order": "84410807816", "sum": 0.001}`,
+			ctype:  map[string]string{"Content-Type": "application/json"},
+			want:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "Withdraw with negative sum",
+			method: http.MethodPost,
+			path:   "/api/user/balance/withdraw",
+			body:   userReq(t, postWithdrawn{Order: "84410807816", Sum: -1}),
+			ctype:  map[string]string{"Content-Type": "application/json"},
+			want:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "Withdraw after accrual",
+			method: http.MethodPost,
+			path:   "/api/user/balance/withdraw",
+			body:   userReq(t, postWithdrawn{Order: "84410807816", Sum: 1}),
+			ctype:  map[string]string{"Content-Type": "application/json"},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "Not empty withdraws",
+			method: http.MethodGet,
+			path:   "/api/user/balance/withdraw",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "Balance history",
+			method: http.MethodGet,
+			path:   "/api/user/balance/history",
+			want:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "Withdraw after accrual" {
+				require.NoError(t, s.db.UpdateBalance(context.Background(), user.Name, 500*models.Point))
+			}
+			response, _ := testRequest(t, ts, jar, tt.method, tt.path, tt.body, tt.ctype)
+			defer response.Body.Close()
+			assert.Equal(t, tt.want, response.StatusCode)
+		})
+	}
+	balance, err := s.db.GetBalance(context.Background(), user.Name)
+	require.NoError(t, err)
+	require.Equal(t, models.Balance{Balance: 499 * models.Point, Withdraws: 1 * models.Point}, balance)
+	response, body := testRequest(t, ts, jar, http.MethodGet, "/api/user/balance/history", "", nil)
+	defer response.Body.Close()
+	require.Equal(t, "application/json", response.Header.Get("Content-type"))
+	history := make([]models.LedgerEntry, 0)
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	require.Len(t, history, 2)
+	require.Equal(t, 500*models.Point, history[0].Balance)
+	require.Equal(t, "WITHDRAWAL", history[1].Kind)
+	require.Equal(t, "84410807816", history[1].Order)
+	require.Equal(t, 499*models.Point, history[1].Balance)
+}
+
+func TestGophermart_canceledRequest(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	_, r, s := newMemoryServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"login":"user111","password":"password111"}`)).WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	_, err := s.db.GetUser(context.Background(), "user111")
+	require.Error(t, err)
+}
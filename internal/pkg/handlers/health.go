@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+const readyTimeout = 2 * time.Second //Limit of one dependency check
+
+//readyCheck - check of one dependency. Returns detail shown with successful result
+type readyCheck func(ctx context.Context) (string, error)
+
+//readyChecks - dependencies checked by readiness probe by component name
+func (s *Gophermart) readyChecks() map[string]readyCheck {
+	return map[string]readyCheck{
+		"storage": func(ctx context.Context) (string, error) {
+			return "", s.db.Ping(ctx)
+		},
+		"migrations": s.checkSchema,
+		"accrual": func(ctx context.Context) (string, error) {
+			return "", s.accrual.Ping(ctx)
+		},
+	}
+}
+
+//checkSchema - checking that all migrations known to the application are applied
+func (s *Gophermart) checkSchema(ctx context.Context) (string, error) {
+	version, latest, err := s.db.SchemaVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	if latest == 0 {
+		return "storage has no schema", nil
+	}
+	if version < latest {
+		return "", fmt.Errorf("schema version %d is older than %d", version, latest)
+	}
+	return fmt.Sprintf("schema version %d", version), nil
+}
+
+//getLivez - handling /livez on method GET. Answers while the process is able to serve requests, dependencies are not checked
+func (s *Gophermart) getLivez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, models.Health{Status: models.HealthUp})
+}
+
+//getReadyz - handling /readyz on method GET. Dependencies are checked in parallel. Instance is not ready if any check fails or shutdown has started
+func (s *Gophermart) getReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.Draining() {
+		sublog.Info().Msg("Readiness probe during shutdown")
+		writeJSON(w, http.StatusServiceUnavailable, models.Health{Status: models.HealthDraining})
+		return
+	}
+	health := models.Health{Status: models.HealthReady, Components: make(map[string]models.ComponentHealth)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range s.readyChecks() {
+		wg.Add(1)
+		go func(name string, check readyCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+			defer cancel()
+			start := time.Now()
+			detail, err := check(ctx)
+			result := models.ComponentHealth{Status: models.HealthUp, Latency: float64(time.Since(start).Microseconds()) / 1000, Detail: detail}
+			if err != nil {
+				sublog.Warn().Err(err).Msgf("Readiness check of %v failed", name)
+				result.Status = models.HealthDown
+				result.Detail = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			health.Components[name] = result
+		}(name, check)
+	}
+	wg.Wait()
+	code := http.StatusOK
+	for _, c := range health.Components {
+		if c.Status != models.HealthUp {
+			health.Status = models.HealthNotReady
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, health)
+}
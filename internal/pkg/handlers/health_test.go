@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestGophermart_Health(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	fake := accrual.NewFake()
+	s.accrual = fake
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	//probe - requesting the probe without authorization and parsing the answer
+	probe := func(path string) (int, models.Health) {
+		response, body := testRequest(t, ts, jar, http.MethodGet, path, "", nil)
+		require.Equal(t, "application/json", response.Header.Get("Content-Type"))
+		health := models.Health{}
+		require.NoError(t, json.Unmarshal([]byte(body), &health))
+		return response.StatusCode, health
+	}
+
+	t.Run("Live", func(t *testing.T) {
+		code, health := probe("/livez")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, models.HealthUp, health.Status)
+	})
+	t.Run("Ready", func(t *testing.T) {
+		code, health := probe("/readyz")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, models.HealthReady, health.Status)
+		require.Len(t, health.Components, 3)
+		for name, c := range health.Components {
+			require.Equal(t, models.HealthUp, c.Status, name)
+			require.GreaterOrEqual(t, c.Latency, 0.0)
+		}
+	})
+	t.Run("Query string", func(t *testing.T) {
+		for _, path := range []string{"/livez?verbose=1", "/readyz?full"} {
+			code, _ := probe(path)
+			require.Equal(t, http.StatusOK, code, path)
+		}
+	})
+	t.Run("Accrual system is unreachable", func(t *testing.T) {
+		fake.SetDown(errors.New("connection refused"))
+		defer fake.SetDown(nil)
+		code, health := probe("/readyz")
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, models.HealthNotReady, health.Status)
+		require.Equal(t, models.HealthDown, health.Components["accrual"].Status)
+		require.Equal(t, "connection refused", health.Components["accrual"].Detail)
+		require.Equal(t, models.HealthUp, health.Components["storage"].Status)
+	})
+	t.Run("Draining", func(t *testing.T) {
+		s.Drain()
+		code, health := probe("/readyz")
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, models.HealthDraining, health.Status)
+		code, _ = probe("/livez")
+		require.Equal(t, http.StatusOK, code)
+	})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+)
+
+//Drain - switching the application to shutdown mode. Long-living responses such as event streams are closed, so the server can wait for other requests. It is safe to call Drain more than once
+func (s *Gophermart) Drain() {
+	s.drainOnce.Do(func() {
+		sublog.Info().Msg("Draining. Closing event streams")
+		close(s.draining)
+	})
+}
+
+//Draining - checking that the application is shutting down
+func (s *Gophermart) Draining() bool {
+	select {
+	case <-s.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+//Shutdown - waiting for workers stopped by cancelling their context and closing the storage.
+//Storage is closed even if workers don't stop before ctx is done, then ctx error is returned
+func (s *Gophermart) Shutdown(ctx context.Context) error {
+	s.Drain()
+	stopped := make(chan struct{})
+	go func() {
+		s.WaitWorkers()
+		close(stopped)
+	}()
+	var err error
+	select {
+	case <-stopped:
+		sublog.Info().Msg("All workers stopped")
+	case <-ctx.Done():
+		err = ctx.Err()
+		sublog.Error().Err(err).Msg("Workers did not stop in time")
+	}
+	s.db.Close()
+	sublog.Info().Msg("Storage closed")
+	return err
+}
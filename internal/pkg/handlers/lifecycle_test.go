@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestGophermart_Shutdown(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.Workers = 2
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	s.StartWorkers(workers)
+
+	stream, _ := openStream(t, context.Background(), ts, jar, "")
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	require.Eventually(t, func() bool { return s.hub.Subscribers("user111") == 1 }, time.Second, 10*time.Millisecond)
+
+	t.Run("Workers must be stopped before deadline", func(t *testing.T) {
+		deadline, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, s.Shutdown(deadline), context.DeadlineExceeded)
+	})
+	t.Run("Event streams are closed on drain", func(t *testing.T) {
+		require.True(t, s.Draining())
+		_, err := io.ReadAll(stream.Body)
+		require.NoError(t, err)
+		require.Equal(t, 0, s.hub.Subscribers("user111"))
+		s.Drain()
+	})
+	t.Run("Clean shutdown", func(t *testing.T) {
+		stopWorkers()
+		deadline, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, s.Shutdown(deadline))
+	})
+}
+
+func TestGophermart_getEventsLifetime(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.WriteTimeout = 200 * time.Millisecond
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	start := time.Now()
+	stream, _ := openStream(t, context.Background(), ts, jar, "")
+	defer stream.Body.Close()
+	_, err := io.ReadAll(stream.Body)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), s.Config.WriteTimeout)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestGophermart_Metrics(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, _ := newMemoryServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/orders", "79927398713", map[string]string{"Content-Type": "text/plain"})
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+	response, _ = testRequest(t, ts, jar, http.MethodGet, "/api/user/orders/79927398713", "", nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	response, _ = testRequest(t, ts, jar, http.MethodGet, "/api/user/unknown", "", nil)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	response, body := testRequest(t, ts, jar, http.MethodGet, "/metrics", "", nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, response.Header.Get("Content-Type"), "text/plain")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Route pattern", want: `gophermart_http_requests_total{method="GET",route="/api/user/orders/{number}",status="200"}`},
+		{name: "Unmatched route", want: `gophermart_http_requests_total{method="GET",route="unknown",status="400"}`},
+		{name: "Latency histogram", want: `gophermart_http_request_duration_seconds_bucket{method="POST",route="/api/user/register",status="200",le="+Inf"}`},
+		{name: "Pending orders", want: `gophermart_orders_pending{status="NEW"} 1`},
+		{name: "Pending orders without orders", want: `gophermart_orders_pending{status="PROCESSING"} 0`},
+		{name: "Registrations", want: "gophermart_registrations_total"},
+		{name: "Accepted orders", want: "gophermart_orders_accepted_total"},
+		{name: "Runtime", want: "go_goroutines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Contains(t, body, tt.want)
+		})
+	}
+	require.NotContains(t, body, "gophermart_db_pool")
+}
+
+func TestGophermart_MetricsSeparateAddress(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, _, s := newMemoryServer(t)
+	s.Config.MetricsAddress = "127.0.0.1:9090"
+	r := chi.NewRouter()
+	r.Route("/", s.Router)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	response, body := testRequest(t, ts, jar, http.MethodGet, "/metrics", "", nil)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	require.NotContains(t, body, "gophermart_")
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+const (
+	ordersDefaultLimit = 100  //Page size of orders listing if limit is not set
+	ordersMaxLimit     = 1000 //Maximum page size of orders listing
+)
+
+//orderStatuses - order statuses allowed in listing filter
+var orderStatuses = map[string]bool{"NEW": true, "REGISTERED": true, "PROCESSING": true, "INVALID": true, "PROCESSED": true}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+//encodeCursor - making opaque cursor of the position after the order
+func encodeCursor(o models.Order) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(o.Upload.Format(time.RFC3339Nano) + "|" + o.Number))
+}
+
+//decodeCursor - reading position from the cursor made by encodeCursor
+func decodeCursor(cursor string) (*models.OrderCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	parts := strings.SplitN(string(data), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errInvalidCursor
+	}
+	upload, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &models.OrderCursor{Upload: upload, Number: parts[1]}, nil
+}
+
+//orderQuery - reading orders listing conditions from URL parameters.
+//Statuses are passed as repeated or comma separated status parameter
+func orderQuery(r *http.Request) (models.OrderQuery, error) {
+	q := r.URL.Query()
+	query := models.OrderQuery{Limit: ordersDefaultLimit}
+	for _, v := range q["status"] {
+		for _, status := range strings.Split(v, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !orderStatuses[status] {
+				return query, fmt.Errorf("unknown status %q", status)
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+	var err error
+	if v := q.Get("from"); v != "" {
+		query.From, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, err
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		query.To, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, err
+		}
+	}
+	switch q.Get("sort") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("unknown sort %q", q.Get("sort"))
+	}
+	if v := q.Get("limit"); v != "" {
+		query.Limit, err = strconv.Atoi(v)
+		if err != nil || query.Limit <= 0 {
+			return query, fmt.Errorf("invalid limit %q", v)
+		}
+		if query.Limit > ordersMaxLimit {
+			query.Limit = ordersMaxLimit
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		query.After, err = decodeCursor(v)
+		if err != nil {
+			return query, err
+		}
+	}
+	return query, nil
+}
+
+//setNextPage - writing cursor of the next page to X-Next-Cursor and Link headers. Other parameters of the request are kept
+func setNextPage(w http.ResponseWriter, r *http.Request, cursor string) {
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	w.Header().Set("X-Next-Cursor", cursor)
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode()))
+}
+
+//getOrder - handling /api/user/orders/{number} on method GET. Order is returned with timeline of its statuses
+func (s *Gophermart) getOrder(w http.ResponseWriter, r *http.Request) {
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	number := chi.URLParam(r, "number")
+	sublog.Info().Msgf("Request order %v of user %v", number, user)
+	if !helpers.CheckOrder([]byte(number)) {
+		sublog.Info().Msg("Invalid order number")
+		http.Error(w, "Incorrect order format", http.StatusUnprocessableEntity)
+		return
+	}
+	order, err := s.db.GetOrder(r.Context(), user, number)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		internalError(w, err)
+		return
+	}
+	history, err := s.db.GetOrderHistory(r.Context(), number)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Number  string               `json:"number"`
+		Status  string               `json:"status"`
+		AccRual models.Money         `json:"accrual"`
+		Upload  string               `json:"uploaded_at"`
+		History []models.OrderStatus `json:"history"`
+	}{
+		Number:  order.Number,
+		Status:  order.Status,
+		AccRual: order.AccRual,
+		Upload:  order.Upload.Format(time.RFC3339),
+		History: history,
+	})
+}
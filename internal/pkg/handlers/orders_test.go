@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func Test_decodeCursor(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	order := models.Order{Number: "79927398713", Upload: time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC)}
+	got, err := decodeCursor(encodeCursor(order))
+	require.NoError(t, err)
+	require.True(t, order.Upload.Equal(got.Upload))
+	require.Equal(t, order.Number, got.Number)
+	for _, cursor := range []string{"", "!!!", "bm90LWEtY3Vyc29y", "MjAyMi0wMS0wMlQwMzowNDowNVp8"} {
+		_, err := decodeCursor(cursor)
+		require.ErrorIs(t, err, errInvalidCursor, cursor)
+	}
+}
+
+func TestGophermart_getOrdersPages(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	numbers := []string{"79927398713", "12345678903", "84410807816", "577277243060172"}
+	for _, number := range numbers {
+		time.Sleep(time.Millisecond)
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/orders", number, map[string]string{"Content-Type": "text/plain"})
+		require.Equal(t, http.StatusAccepted, response.StatusCode)
+	}
+	require.NoError(t, s.db.UpdateOrder(context.Background(), numbers[1], "PROCESSED", 500))
+	require.NoError(t, s.db.UpdateOrder(context.Background(), numbers[2], "REGISTERED", 0))
+	list := func(path string) (*http.Response, []string) {
+		response, body := testRequest(t, ts, jar, http.MethodGet, path, "", nil)
+		orders := make([]models.Order, 0)
+		if response.StatusCode == http.StatusOK {
+			require.NoError(t, json.Unmarshal([]byte(body), &orders))
+		}
+		got := make([]string, 0)
+		for _, o := range orders {
+			got = append(got, o.Number)
+		}
+		return response, got
+	}
+	t.Run("Oldest first", func(t *testing.T) {
+		response, got := list("/api/user/orders")
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, numbers, got)
+		require.Empty(t, response.Header.Get("Link"))
+	})
+	t.Run("Pages", func(t *testing.T) {
+		path := "/api/user/orders?sort=desc&limit=3"
+		response, got := list(path)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, []string{numbers[3], numbers[2], numbers[1]}, got)
+		link := response.Header.Get("Link")
+		require.True(t, strings.HasSuffix(link, `>; rel="next"`))
+		next, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+		require.NoError(t, err)
+		require.Equal(t, "desc", next.Query().Get("sort"))
+		require.Equal(t, response.Header.Get("X-Next-Cursor"), next.Query().Get("cursor"))
+		response, got = list(next.String())
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, []string{numbers[0]}, got)
+		require.Empty(t, response.Header.Get("X-Next-Cursor"))
+	})
+	t.Run("Filters", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			query string
+			code  int
+			want  []string
+		}{
+			{name: "Status", query: "?status=processed", code: http.StatusOK, want: []string{numbers[1]}},
+			{name: "Registered", query: "?status=REGISTERED", code: http.StatusOK, want: []string{numbers[2]}},
+			{name: "Statuses", query: "?status=NEW,PROCESSED&limit=2", code: http.StatusOK, want: numbers[:2]},
+			{name: "Period", query: "?from=2000-01-01T00:00:00Z&to=2001-01-01T00:00:00Z", code: http.StatusNoContent, want: []string{}},
+			{name: "Unknown status", query: "?status=LOST", code: http.StatusBadRequest, want: []string{}},
+			{name: "Invalid limit", query: "?limit=-1", code: http.StatusBadRequest, want: []string{}},
+			{name: "Invalid sort", query: "?sort=up", code: http.StatusBadRequest, want: []string{}},
+			{name: "Invalid cursor", query: "?cursor=abc", code: http.StatusBadRequest, want: []string{}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				response, got := list("/api/user/orders" + tt.query)
+				require.Equal(t, tt.code, response.StatusCode)
+				require.Equal(t, tt.want, got)
+			})
+		}
+	})
+}
+
+func TestGophermart_getOrder(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	fake := accrual.NewFake()
+	s.accrual = fake
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/orders", "79927398713", map[string]string{"Content-Type": "text/plain"})
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+	require.NoError(t, s.db.CreateUser(context.Background(), "user112", "password112", "random"))
+	require.NoError(t, s.db.CreateOrder(context.Background(), "12345678903", "user112"))
+	for _, status := range []string{"REGISTERED", "PROCESSING", "PROCESSING", "PROCESSED"} {
+		fake.Add("79927398713", models.Accrual{Order: "79927398713", Status: status, Value: 500 * models.Point}, nil)
+		s.accrualAPI(context.Background(), "user111", "79927398713", "")
+	}
+	tests := []struct {
+		name   string
+		number string
+		code   int
+	}{
+		{name: "Own order", number: "79927398713", code: http.StatusOK},
+		{name: "Order of another user", number: "12345678903", code: http.StatusNotFound},
+		{name: "Unknown order", number: "84410807816", code: http.StatusNotFound},
+		{name: "Invalid number", number: "12345", code: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, body := testRequest(t, ts, jar, http.MethodGet, "/api/user/orders/"+tt.number, "", nil)
+			require.Equal(t, tt.code, response.StatusCode)
+			if tt.code != http.StatusOK {
+				return
+			}
+			order := struct {
+				Number  string       `json:"number"`
+				Status  string       `json:"status"`
+				AccRual models.Money `json:"accrual"`
+				History []struct {
+					Status  string       `json:"status"`
+					AccRual models.Money `json:"accrual"`
+					Changed time.Time    `json:"changed_at"`
+				} `json:"history"`
+			}{}
+			require.NoError(t, json.Unmarshal([]byte(body), &order))
+			require.Equal(t, "PROCESSED", order.Status)
+			require.Equal(t, 500*models.Point, order.AccRual)
+			statuses := make([]string, 0)
+			for _, change := range order.History {
+				statuses = append(statuses, change.Status)
+				require.False(t, change.Changed.IsZero())
+			}
+			require.Equal(t, []string{"NEW", "REGISTERED", "PROCESSING", "PROCESSED"}, statuses)
+			require.Equal(t, 500*models.Point, order.History[3].AccRual)
+		})
+	}
+}
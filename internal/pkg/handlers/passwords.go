@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/auth"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/notify"
+)
+
+//resetTimeout - time limit of creating and sending password reset token
+const resetTimeout = 30 * time.Second
+
+//postPassword - handling /api/user/password on method POST. Other sessions of the user are revoked. Wrong current password is counted by the login throttle
+func (s *Gophermart) postPassword(w http.ResponseWriter, r *http.Request) {
+	type change struct {
+		Current string `json:"current_password"`
+		New     string `json:"new_password"`
+	}
+	sublog.Info().Msg("Processing password change request")
+	if r.Header.Get("Content-Type") != "application/json" {
+		sublog.Info().Msg("Invalid content type")
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req := change{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Current == "" || req.New == "" {
+		sublog.Info().Msg("Error while parsing JSON body")
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	ip := clientIP(r)
+	attempt, wait, err := s.reserveLogin(r.Context(), user, ip, time.Now())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if wait > 0 {
+		sublog.Info().Msgf("Password change of user %v from %v throttled for %v", user, ip, wait)
+		retryAfter(w, wait)
+		return
+	}
+	defer attempt.release(r.Context())
+	u, err := s.db.GetUser(r.Context(), user)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	if !helpers.ComparePassword(req.Current, u.Password) {
+		attempt.failed(r.Context())
+		sublog.Info().Msgf("Current password of user %v invalid", user)
+		http.Error(w, "Current password is wrong", http.StatusForbidden)
+		return
+	}
+	pass, err := helpers.SecurePassword(req.New)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	current, _ := auth.Session(r.Context())
+	err = s.db.UpdatePassword(r.Context(), user, pass, helpers.RandStringRunes(12), current)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Update password error")
+		internalError(w, err)
+		return
+	}
+	s.audit(r, models.AuditEvent{Action: actionPasswordChange, Target: "user:" + user})
+	sublog.Info().Msgf("Password of user %v changed", user)
+	w.WriteHeader(http.StatusOK)
+}
+
+//postPasswordReset - handling /api/user/password/reset on method POST. Token is created and sent in background,
+//so neither the answer nor its timing depends on user existence. Requests are limited per account and client address apart from the login throttle
+func (s *Gophermart) postPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type reset struct {
+		Login string `json:"login"`
+	}
+	sublog.Info().Msg("Processing password reset request")
+	if r.Header.Get("Content-Type") != "application/json" {
+		sublog.Info().Msg("Invalid content type")
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req := reset{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Login == "" {
+		sublog.Info().Msg("Error while parsing JSON body")
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	ip := clientIP(r)
+	attempt, wait, err := s.reserveReset(r.Context(), req.Login, ip, time.Now())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if wait > 0 {
+		sublog.Info().Msgf("Password reset of user %v from %v throttled for %v", req.Login, ip, wait)
+		retryAfter(w, wait)
+		return
+	}
+	attempt.failed(r.Context())
+	s.workers.Add(1)
+	go s.sendResetToken(req.Login)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+//sendResetToken - creating and sending password reset token of the user. Unknown users are skipped
+func (s *Gophermart) sendResetToken(login string) {
+	defer s.workers.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), resetTimeout)
+	defer cancel()
+	_, err := s.db.GetUser(ctx, login)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			sublog.Info().Msgf("Password reset for unknown user %v requested", login)
+			return
+		}
+		sublog.Error().Err(err).Msg("")
+		return
+	}
+	token, hash, err := auth.NewResetToken()
+	if err != nil {
+		sublog.Error().Err(err).Msg("Create reset token error")
+		return
+	}
+	now := time.Now()
+	expires := now.Add(s.Config.ResetTTL)
+	err = s.db.CreateResetToken(ctx, models.ResetToken{Hash: hash, User: login, Created: now, Expires: expires})
+	if err != nil {
+		sublog.Error().Err(err).Msg("Create reset token error")
+		return
+	}
+	err = s.notifier.Notify(ctx, notify.Message{
+		To:      login,
+		Subject: "Password reset",
+		Body:    fmt.Sprintf("Password reset token: %s. Token is valid until %s and can be used once", token, expires.Format(time.RFC3339)),
+		Sent:    now,
+	})
+	if err != nil {
+		sublog.Error().Err(err).Msg("Sending reset token error")
+		return
+	}
+	sublog.Info().Msgf("Password reset token for user %v sent", login)
+}
+
+//postPasswordResetConfirm - handling /api/user/password/reset/confirm on method POST. All sessions of the user are revoked and lock of the account is removed
+func (s *Gophermart) postPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type confirm struct {
+		Token string `json:"token"`
+		New   string `json:"new_password"`
+	}
+	sublog.Info().Msg("Processing password reset confirmation")
+	if r.Header.Get("Content-Type") != "application/json" {
+		sublog.Info().Msg("Invalid content type")
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req := confirm{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Token == "" || req.New == "" {
+		sublog.Info().Msg("Error while parsing JSON body")
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return
+	}
+	pass, err := helpers.SecurePassword(req.New)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	user, err := s.db.ResetPassword(r.Context(), auth.HashToken(req.Token), pass, helpers.RandStringRunes(12))
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			sublog.Info().Msg("Reset token is invalid")
+			http.Error(w, "Reset token is invalid, used or expired", http.StatusUnprocessableEntity)
+			return
+		}
+		sublog.Error().Err(err).Msg("Reset password error")
+		internalError(w, err)
+		return
+	}
+	err = s.db.ResetThrottle(r.Context(), models.ThrottleUser, user)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Reset login throttle error")
+	}
+	s.audit(r, models.AuditEvent{Actor: user, Action: actionPasswordReset, Target: "user:" + user})
+	sublog.Info().Msgf("Password of user %v reset", user)
+	w.WriteHeader(http.StatusOK)
+}
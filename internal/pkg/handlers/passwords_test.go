@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/notify"
+)
+
+func TestGophermart_postPassword(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	json := map[string]string{"Content-Type": "application/json"}
+	body := userReq(t, models.User{Name: "user111", Password: "password111"})
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", body, json)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	other, _ := cookiejar.New(nil)
+	response, _ = testRequest(t, ts, other, http.MethodPost, "/api/user/login", body, json)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	before, err := s.db.GetUser(context.Background(), "user111")
+	require.NoError(t, err)
+	tests := []struct {
+		name  string
+		ctype map[string]string
+		body  string
+		code  int
+	}{
+		{name: "Wrong content type", body: `{"current_password":"password111","new_password":"password222"}`, code: http.StatusBadRequest},
+		{name: "Missing new password", ctype: json, body: `{"current_password":"password111"}`, code: http.StatusBadRequest},
+		{name: "Wrong current password", ctype: json, body: `{"current_password":"password000","new_password":"password222"}`, code: http.StatusForbidden},
+		{name: "Password changed", ctype: json, body: `{"current_password":"password111","new_password":"password222"}`, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/password", tt.body, tt.ctype)
+			require.Equal(t, tt.code, response.StatusCode)
+		})
+	}
+	after, err := s.db.GetUser(context.Background(), "user111")
+	require.NoError(t, err)
+	require.NotEqual(t, before.Random, after.Random)
+	response, _ = testRequest(t, ts, jar, http.MethodGet, "/api/user/balance", "", nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	response, _ = testRequest(t, ts, other, http.MethodGet, "/api/user/balance", "", nil)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	fresh, _ := cookiejar.New(nil)
+	response, _ = testRequest(t, ts, fresh, http.MethodPost, "/api/user/login", body, json)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, _ = testRequest(t, ts, fresh, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "user111", Password: "password222"}), json)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestGophermart_PasswordReset(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	json := map[string]string{"Content-Type": "application/json"}
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), json)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	notifier := s.notifier.(*notify.Fake)
+	anonymous, _ := cookiejar.New(nil)
+
+	response, _ = testRequest(t, ts, anonymous, http.MethodPost, "/api/user/password/reset", `{"login":"unknown"}`, json)
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+	s.WaitWorkers()
+	require.Empty(t, notifier.Messages("unknown"))
+	response, _ = testRequest(t, ts, anonymous, http.MethodPost, "/api/user/password/reset", `{"login":"user111"}`, json)
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+	s.WaitWorkers()
+	messages := notifier.Messages("user111")
+	require.Len(t, messages, 1)
+	token := regexp.MustCompile(`[0-9a-f]{64}`).FindString(messages[0].Body)
+	require.NotEmpty(t, token)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{name: "Missing password", body: `{"token":"` + token + `"}`, code: http.StatusBadRequest},
+		{name: "Unknown token", body: `{"token":"unknown","new_password":"password222"}`, code: http.StatusUnprocessableEntity},
+		{name: "Password reset", body: `{"token":"` + token + `","new_password":"password222"}`, code: http.StatusOK},
+		{name: "Token is single-use", body: `{"token":"` + token + `","new_password":"password333"}`, code: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _ := testRequest(t, ts, anonymous, http.MethodPost, "/api/user/password/reset/confirm", tt.body, json)
+			require.Equal(t, tt.code, response.StatusCode)
+		})
+	}
+	response, _ = testRequest(t, ts, jar, http.MethodGet, "/api/user/balance", "", nil)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, _ = testRequest(t, ts, anonymous, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "user111", Password: "password222"}), json)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestGophermart_PasswordThrottle(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.LoginLockout = time.Minute
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	json := map[string]string{"Content-Type": "application/json"}
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), json)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	t.Run("Password change", func(t *testing.T) {
+		for i := 0; i < models.LoginFreeFailures; i++ {
+			response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/password", `{"current_password":"password000","new_password":"password222"}`, json)
+			require.Equal(t, http.StatusForbidden, response.StatusCode)
+		}
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/password", `{"current_password":"password111","new_password":"password222"}`, json)
+		require.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+		require.NotEmpty(t, response.Header.Get("Retry-After"))
+	})
+	require.NoError(t, s.db.ResetThrottle(context.Background(), models.ThrottleUser, "user111"))
+	require.NoError(t, s.db.ResetThrottle(context.Background(), models.ThrottleIP, "127.0.0.1"))
+	t.Run("Reset request", func(t *testing.T) {
+		anonymous, _ := cookiejar.New(nil)
+		for _, login := range []string{"user111", "unknown"} {
+			for i := 0; i < models.LoginFreeFailures; i++ {
+				response, _ := testRequest(t, ts, anonymous, http.MethodPost, "/api/user/password/reset", `{"login":"`+login+`"}`, json)
+				require.Equal(t, http.StatusAccepted, response.StatusCode)
+			}
+			response, _ := testRequest(t, ts, anonymous, http.MethodPost, "/api/user/password/reset", `{"login":"`+login+`"}`, json)
+			require.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+		}
+		s.WaitWorkers()
+		require.Len(t, s.notifier.(*notify.Fake).Messages("user111"), models.LoginFreeFailures)
+		fresh, _ := cookiejar.New(nil)
+		response, _ := testRequest(t, ts, fresh, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "user111", Password: "password111"}), json)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	})
+	t.Run("Reset removes lock", func(t *testing.T) {
+		require.NoError(t, s.db.LockThrottle(context.Background(), models.ThrottleUser, "user111", time.Now().Add(time.Minute)))
+		messages := s.notifier.(*notify.Fake).Messages("user111")
+		token := regexp.MustCompile(`[0-9a-f]{64}`).FindString(messages[len(messages)-1].Body)
+		anonymous, _ := cookiejar.New(nil)
+		response, _ := testRequest(t, ts, anonymous, http.MethodPost, "/api/user/password/reset/confirm", `{"token":"`+token+`","new_password":"password333"}`, json)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		response, _ = testRequest(t, ts, anonymous, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "user111", Password: "password333"}), json)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/auth"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//sessionTouch - minimal interval between updates of session's last request time
+const sessionTouch = time.Minute
+
+//clientIP - client address without port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//sessionAlive - checking session absolute and idle timeouts
+func (s *Gophermart) sessionAlive(session models.Session, now time.Time) bool {
+	if !now.Before(session.Expires) {
+		return false
+	}
+	return s.Config.IdleTTL <= 0 || now.Sub(session.LastSeen) < s.Config.IdleTTL
+}
+
+//authorize - opening new session of the user. Session token is sent in cookie and in Authorization header.
+//Verified is time of 2FA check during login or zero
+func (s *Gophermart) authorize(w http.ResponseWriter, r *http.Request, login string, verified time.Time) error {
+	id, err := auth.NewID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	token, expires, err := s.tokens.Issue(login, id)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while issuing session token")
+		return err
+	}
+	session := models.Session{
+		ID:       id,
+		User:     login,
+		Device:   r.UserAgent(),
+		IP:       clientIP(r),
+		Created:  now,
+		LastSeen: now,
+		Expires:  expires,
+		Verified: verified,
+	}
+	err = s.db.CreateSession(r.Context(), session)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while creating session")
+		return err
+	}
+	helpers.SetCookie(w, auth.CookieName, token, expires)
+	w.Header().Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+//postLogout - handling /api/user/logout on method POST
+func (s *Gophermart) postLogout(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing logout request")
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := auth.Session(r.Context())
+	if err != nil {
+		sublog.Info().Msg("Session not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	err = s.db.DeleteSession(r.Context(), user, id)
+	if err != nil && !helpers.EmptyRow(err) {
+		sublog.Error().Err(err).Msg("Delete session error")
+		internalError(w, err)
+		return
+	}
+	helpers.SetCookie(w, auth.CookieName, "", time.Unix(0, 0))
+	sublog.Info().Msgf("User %v logged out", user)
+	w.WriteHeader(http.StatusOK)
+}
+
+//getSessions - handling /api/user/sessions on method GET
+func (s *Gophermart) getSessions(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing request of user's sessions")
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	current, _ := auth.Session(r.Context())
+	sessions, err := s.db.GetSessions(r.Context(), user)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in requesting sessions")
+		internalError(w, err)
+		return
+	}
+	now := time.Now()
+	alive := make([]models.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if !s.sessionAlive(session, now) {
+			continue
+		}
+		session.Current = session.ID == current
+		alive = append(alive, session)
+	}
+	body, err := json.Marshal(alive)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	i, err := w.Write(body)
+	log.Debug().Msgf("%v bytes wrote to ResponseWriter", i)
+	if err != nil {
+		log.Debug().Err(err).Msg("Error in http.ResponseWriter")
+	}
+}
+
+//deleteSession - handling /api/user/sessions/{id} on method DELETE
+func (s *Gophermart) deleteSession(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing request of session revoke")
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	err = s.db.DeleteSession(r.Context(), user, id)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			sublog.Info().Msgf("User %v has no session %v", user, id)
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		sublog.Error().Err(err).Msg("Delete session error")
+		internalError(w, err)
+		return
+	}
+	if current, _ := auth.Session(r.Context()); current == id {
+		helpers.SetCookie(w, auth.CookieName, "", time.Unix(0, 0))
+	}
+	s.audit(r, models.AuditEvent{Action: actionSessionRevoke, Target: "session:" + id})
+	sublog.Info().Msgf("Session of user %v revoked", user)
+	w.WriteHeader(http.StatusOK)
+}
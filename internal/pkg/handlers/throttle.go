@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//loginAttempt - login attempt of the account from the address. Attempt is counted as failed before the check, so parallel attempts can not pass the throttle together
+type loginAttempt struct {
+	s        *Gophermart
+	now      time.Time
+	limits   map[string]int
+	reserved []models.LoginThrottle
+	done     bool
+}
+
+//throttleScope - throttle counter of the attempt and its limit
+type throttleScope struct {
+	name  string
+	key   string
+	limit int
+}
+
+//reserveLogin - counting login attempt of the account from the address as failed in advance. Returns time to wait if any of them is throttled, nothing is counted then
+func (s *Gophermart) reserveLogin(ctx context.Context, login, ip string, now time.Time) (*loginAttempt, time.Duration, error) {
+	return s.reserve(ctx, now, throttleScope{models.ThrottleUser, login, s.Config.LoginMaxFailures}, throttleScope{models.ThrottleIP, ip, s.Config.LoginIPMaxFailures})
+}
+
+//reserveReset - counting password reset request of the account from the address. Reset requests have their own counter and don't affect login throttle
+func (s *Gophermart) reserveReset(ctx context.Context, login, ip string, now time.Time) (*loginAttempt, time.Duration, error) {
+	return s.reserve(ctx, now, throttleScope{models.ThrottleReset, login + "@" + ip, s.Config.ResetMaxRequests})
+}
+
+//reserve - counting the attempt in all scopes. Returns time to wait if any of them is throttled, nothing is counted then
+func (s *Gophermart) reserve(ctx context.Context, now time.Time, scopes ...throttleScope) (*loginAttempt, time.Duration, error) {
+	attempt := &loginAttempt{s: s, now: now, limits: make(map[string]int)}
+	for _, scope := range scopes {
+		throttle, wait, err := s.db.ReserveAttempt(ctx, scope.name, scope.key, now, s.Config.LoginLockout)
+		if err != nil || wait > 0 {
+			attempt.release(ctx)
+			if err != nil {
+				sublog.Error().Err(err).Msg("Reserve login attempt error")
+			}
+			return nil, wait, err
+		}
+		attempt.limits[scope.name] = scope.limit
+		attempt.reserved = append(attempt.reserved, throttle)
+	}
+	return attempt, 0, nil
+}
+
+//failed - keeping the attempt counted. Scope is locked when its counter reaches the limit
+func (a *loginAttempt) failed(ctx context.Context) {
+	if a.done {
+		return
+	}
+	a.done = true
+	for _, throttle := range a.reserved {
+		limit := a.limits[throttle.Scope]
+		if limit <= 0 || throttle.Failures < limit {
+			continue
+		}
+		sublog.Warn().Msgf("Login for %v %v locked after %v failures", throttle.Scope, throttle.Key, throttle.Failures)
+		err := a.s.db.LockThrottle(ctx, throttle.Scope, throttle.Key, a.now.Add(a.s.Config.LoginLockout))
+		if err != nil {
+			sublog.Error().Err(err).Msg("Lock login error")
+		}
+	}
+}
+
+//release - uncounting the attempt which was not failed. Does nothing after failed
+func (a *loginAttempt) release(ctx context.Context) {
+	if a.done {
+		return
+	}
+	a.done = true
+	for _, throttle := range a.reserved {
+		err := a.s.db.ReleaseAttempt(ctx, throttle.Scope, throttle.Key)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Release login attempt error")
+		}
+	}
+}
+
+//retryAfter - writing 429 answer with Retry-After header in whole seconds
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestGophermart_LoginThrottle(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.LoginMaxFailures = 4
+	s.Config.LoginIPMaxFailures = 100
+	s.Config.LoginLockout = time.Minute
+	s.Config.TrustedProxies, _ = models.ParseNetworks("127.0.0.1")
+	r = chi.NewRouter()
+	r.Route("/", s.Router)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	valid := userReq(t, models.User{Name: "user111", Password: "password111"})
+	wrong := userReq(t, models.User{Name: "user111", Password: "password000"})
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", valid, map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	ip := 0
+	login := func(body string) *http.Response {
+		ip++
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/login", body, map[string]string{"Content-Type": "application/json", "X-Real-IP": fmt.Sprintf("10.0.0.%d", ip)})
+		return response
+	}
+	t.Run("Progressive delay", func(t *testing.T) {
+		for i := 0; i < models.LoginFreeFailures; i++ {
+			require.Equal(t, http.StatusUnauthorized, login(wrong).StatusCode)
+		}
+		response := login(valid)
+		require.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+		require.Equal(t, "1", response.Header.Get("Retry-After"))
+	})
+	t.Run("Unlock", func(t *testing.T) {
+		require.NoError(t, s.db.ResetThrottle(context.Background(), models.ThrottleUser, "user111"))
+		require.Equal(t, http.StatusOK, login(valid).StatusCode)
+	})
+	t.Run("Lockout", func(t *testing.T) {
+		for i := 0; i < s.Config.LoginMaxFailures-1; i++ {
+			_, err := s.db.RecordFailure(context.Background(), models.ThrottleUser, "user111", time.Now().Add(-30*time.Second), s.Config.LoginLockout)
+			require.NoError(t, err)
+		}
+		require.Equal(t, http.StatusUnauthorized, login(wrong).StatusCode)
+		response := login(valid)
+		require.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+		wait, err := strconv.Atoi(response.Header.Get("Retry-After"))
+		require.NoError(t, err)
+		require.InDelta(t, 60, wait, 1)
+	})
+	t.Run("Unknown user", func(t *testing.T) {
+		body := userReq(t, models.User{Name: "unknown", Password: "password000"})
+		for i := 0; i < models.LoginFreeFailures; i++ {
+			require.Equal(t, http.StatusUnauthorized, login(body).StatusCode)
+		}
+		require.Equal(t, http.StatusTooManyRequests, login(body).StatusCode)
+	})
+	t.Run("Address", func(t *testing.T) {
+		s.Config.LoginIPMaxFailures = 2
+		for i := 0; i < 2; i++ {
+			body := userReq(t, models.User{Name: fmt.Sprintf("guess%d", i), Password: "password000"})
+			response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/login", body, map[string]string{"Content-Type": "application/json", "X-Real-IP": "10.0.1.1"})
+			require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+		}
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/login", userReq(t, models.User{Name: "guess3", Password: "password000"}), map[string]string{"Content-Type": "application/json", "X-Real-IP": "10.0.1.1"})
+		require.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	})
+}
+
+func TestGophermart_LoginThrottleSpoofedAddress(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.LoginMaxFailures = 100
+	s.Config.LoginIPMaxFailures = 2
+	s.Config.LoginLockout = time.Minute
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	login := func(name, forwarded string) int {
+		body := userReq(t, models.User{Name: name, Password: "password000"})
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/login", body, map[string]string{"Content-Type": "application/json", "X-Forwarded-For": forwarded, "X-Real-IP": forwarded})
+		return response.StatusCode
+	}
+	t.Run("Untrusted peer", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, login("spoof0", "10.0.2.1"))
+		require.Equal(t, http.StatusUnauthorized, login("spoof1", "10.0.2.2"))
+		require.Equal(t, http.StatusTooManyRequests, login("spoof2", "10.0.2.3"))
+	})
+	t.Run("Trusted proxy", func(t *testing.T) {
+		require.NoError(t, s.db.ResetThrottle(context.Background(), models.ThrottleIP, "127.0.0.1"))
+		s.Config.TrustedProxies, _ = models.ParseNetworks("127.0.0.1")
+		proxied := chi.NewRouter()
+		proxied.Route("/", s.Router)
+		ts.Config.Handler = proxied
+		require.Equal(t, http.StatusUnauthorized, login("proxied0", "10.0.3.1"))
+		require.Equal(t, http.StatusUnauthorized, login("proxied1", "10.0.3.2"))
+		require.Equal(t, http.StatusUnauthorized, login("proxied2", "10.0.3.3"))
+		_, err := s.db.GetThrottle(context.Background(), models.ThrottleIP, "127.0.0.1")
+		require.True(t, helpers.EmptyRow(err))
+		throttle, err := s.db.GetThrottle(context.Background(), models.ThrottleIP, "10.0.3.1")
+		require.NoError(t, err)
+		require.Equal(t, 1, throttle.Failures)
+	})
+}
+
+func TestGophermart_LoginThrottleParallel(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.LoginMaxFailures = 100
+	s.Config.LoginIPMaxFailures = 100
+	s.Config.LoginLockout = time.Minute
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	valid := userReq(t, models.User{Name: "user112", Password: "password112"})
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", valid, map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	wrong := userReq(t, models.User{Name: "user112", Password: "password000"})
+	const parallel = 10
+	codes := make(chan int, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/login", wrong, map[string]string{"Content-Type": "application/json"})
+			codes <- response.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	require.Equal(t, models.LoginFreeFailures, count[http.StatusUnauthorized])
+	require.Equal(t, parallel-models.LoginFreeFailures, count[http.StatusTooManyRequests])
+}
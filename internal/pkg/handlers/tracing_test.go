@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
+)
+
+func TestGophermart_Tracing(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	_, err := tracing.Setup(&config.Config{TraceExporter: tracing.ExporterNone})
+	require.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	var mu sync.Mutex
+	var received string
+	acc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = r.Header.Get("traceparent")
+		mu.Unlock()
+		fmt.Fprint(w, `{"order":"79927398713","status":"PROCESSED","accrual":500}`)
+	}))
+	defer acc.Close()
+	jar, r, s := newMemoryServer(t)
+	s.Config.AccSystem = acc.URL
+	s.Config.AccrualRPS = 100
+	s.accrual = accrual.New(s.Config)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: "user111", Password: "password111"}), map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	caller := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/orders", "79927398713", map[string]string{"Content-Type": "text/plain", "traceparent": caller})
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+	jobs, err := s.db.ClaimJobs(context.Background(), "worker", 1, s.accrualLease())
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.True(t, strings.HasPrefix(jobs[0].Trace, "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+	s.processJob(context.Background(), jobs[0], "worker")
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	upload, ok := spans["POST /api/user/orders"]
+	require.True(t, ok)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", upload.SpanContext().TraceID().String())
+	require.Equal(t, trace.SpanKindServer, upload.SpanKind())
+	poll, ok := spans["accrual.poll"]
+	require.True(t, ok)
+	require.NotEqual(t, upload.SpanContext().TraceID(), poll.SpanContext().TraceID())
+	require.Len(t, poll.Links(), 1)
+	require.Equal(t, upload.SpanContext().TraceID(), poll.Links()[0].SpanContext.TraceID())
+	call, ok := spans["accrual GET /api/orders/{number}"]
+	require.True(t, ok)
+	require.Equal(t, poll.SpanContext().SpanID(), call.Parent().SpanID())
+	mu.Lock()
+	require.Equal(t, fmt.Sprintf("00-%s-%s-01", call.SpanContext().TraceID(), call.SpanContext().SpanID()), received)
+	mu.Unlock()
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/auth"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/totp"
+)
+
+const (
+	mfaIssuer     = "Gophermart"    //Issuer shown in authenticator application
+	mfaLoginTTL   = 5 * time.Minute //Lifetime of the second login step token
+	recoveryCount = 10              //Count of recovery codes issued on 2FA confirmation
+)
+
+//mfaCode - request body with TOTP or recovery code
+type mfaCode struct {
+	Token string `json:"mfa_token,omitempty"` //Second login step token
+	Code  string `json:"code"`                //TOTP or recovery code
+}
+
+//readMFACode - parsing request body with the code
+func readMFACode(w http.ResponseWriter, r *http.Request) (mfaCode, bool) {
+	req := mfaCode{}
+	if r.Header.Get("Content-Type") != "application/json" {
+		sublog.Info().Msg("Invalid content type")
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return req, false
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		sublog.Info().Msg("Error while parsing JSON body")
+		http.Error(w, "Incorrect request format", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+//normalizeRecovery - recovery code without separators in lower case
+func normalizeRecovery(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+//newRecoveryCodes - generating recovery codes. Returns codes for the user and their hashes for the storage
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCount)
+	hashes := make([]string, 0, recoveryCount)
+	for i := 0; i < recoveryCount; i++ {
+		id, err := auth.NewID()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := id[:5] + "-" + id[5:10]
+		codes = append(codes, code)
+		hashes = append(hashes, auth.HashToken(normalizeRecovery(code)))
+	}
+	return codes, hashes, nil
+}
+
+//checkSecondFactor - checking TOTP or recovery code of the user. Every TOTP time step and every recovery code are accepted once
+func (s *Gophermart) checkSecondFactor(ctx context.Context, user models.User, code string, now time.Time) (bool, error) {
+	if step, ok := totp.Validate(user.TOTPSecret, code, now); ok {
+		err := s.db.UseTOTPStep(ctx, user.Name, step)
+		if err != nil {
+			if helpers.EmptyRow(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	err := s.db.UseRecoveryCode(ctx, user.Name, auth.HashToken(normalizeRecovery(code)))
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+//freshMFA - checking that the session passed 2FA check recently
+func (s *Gophermart) freshMFA(r *http.Request, now time.Time) (bool, error) {
+	id, err := auth.Session(r.Context())
+	if err != nil {
+		return false, nil
+	}
+	session, err := s.db.GetSession(r.Context(), id)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !session.Verified.IsZero() && now.Sub(session.Verified) <= s.Config.WithdrawMFATTL, nil
+}
+
+//mfaChallenge - answering to the password check of the user with enabled 2FA. Token of the second login step is returned
+func (s *Gophermart) mfaChallenge(w http.ResponseWriter, login string) {
+	token, expires, err := s.tokens.IssueChallenge(login, mfaLoginTTL)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	sublog.Info().Msgf("User %v passed password check. Waiting for 2FA code", login)
+	writeJSON(w, http.StatusAccepted, struct {
+		Token   string `json:"mfa_token"`
+		Expires string `json:"expires_at"`
+	}{Token: token, Expires: expires.Format(time.RFC3339)})
+}
+
+//postLoginMFA - handling /api/user/login/2fa on method POST. Second login step of users with enabled 2FA
+func (s *Gophermart) postLoginMFA(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing second login step")
+	req, ok := readMFACode(w, r)
+	if !ok {
+		return
+	}
+	claims, err := s.tokens.ParseChallenge(req.Token)
+	if err != nil {
+		sublog.Info().Err(err).Msg("Second login step token is not valid")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	login := claims.Subject
+	ip := clientIP(r)
+	now := time.Now()
+	attempt, wait, err := s.reserveLogin(r.Context(), login, ip, now)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if wait > 0 {
+		sublog.Info().Msgf("Login of user %v from %v throttled for %v", login, ip, wait)
+		retryAfter(w, wait)
+		return
+	}
+	defer attempt.release(r.Context())
+	u, err := s.db.GetUser(r.Context(), login)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	if !u.TOTPEnabled {
+		sublog.Info().Msgf("2FA of user %v is disabled", login)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if u.Blocked {
+		sublog.Info().Msgf("User %v is blocked", login)
+		s.audit(r, models.AuditEvent{Actor: login, Action: actionLoginFailed, Target: "user:" + login, Reason: "blocked"})
+		http.Error(w, "Account is blocked", http.StatusForbidden)
+		return
+	}
+	ok, err = s.checkSecondFactor(r.Context(), u, req.Code, now)
+	if err != nil {
+		sublog.Error().Err(err).Msg("2FA check error")
+		internalError(w, err)
+		return
+	}
+	if !ok {
+		sublog.Info().Msgf("2FA code of user %v invalid", login)
+		attempt.failed(r.Context())
+		s.audit(r, models.AuditEvent{Actor: login, Action: actionLoginFailed, Target: "user:" + login, Reason: "wrong 2fa code"})
+		http.Error(w, "Wrong code", http.StatusUnauthorized)
+		return
+	}
+	err = s.db.ResetThrottle(r.Context(), models.ThrottleUser, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Reset login throttle error")
+	}
+	err = s.authorize(w, r, login, now)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	s.audit(r, models.AuditEvent{Actor: login, Action: actionLogin, Target: "user:" + login, Reason: "2fa"})
+	sublog.Info().Msgf("User %v authorized with 2FA", login)
+	w.WriteHeader(http.StatusOK)
+}
+
+//postMFAEnroll - handling /api/user/2fa/enroll on method POST. New secret replaces not confirmed one
+func (s *Gophermart) postMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing 2FA enrolment")
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	err = s.db.SetTOTP(r.Context(), user, secret)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			sublog.Info().Msgf("2FA of user %v already enabled", user)
+			http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+			return
+		}
+		sublog.Error().Err(err).Msg("Set TOTP secret error")
+		internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}{Secret: secret, URI: totp.URI(mfaIssuer, user, secret)})
+}
+
+//postMFAConfirm - handling /api/user/2fa/confirm on method POST. The first code enables 2FA and recovery codes are returned once
+func (s *Gophermart) postMFAConfirm(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing 2FA confirmation")
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req, ok := readMFACode(w, r)
+	if !ok {
+		return
+	}
+	u, err := s.db.GetUser(r.Context(), user)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	if u.TOTPEnabled || u.TOTPSecret == "" {
+		sublog.Info().Msgf("User %v has no pending 2FA enrolment", user)
+		http.Error(w, "No pending two-factor enrolment", http.StatusConflict)
+		return
+	}
+	now := time.Now()
+	step, ok := totp.Validate(u.TOTPSecret, req.Code, now)
+	if !ok {
+		sublog.Info().Msgf("2FA code of user %v invalid", user)
+		http.Error(w, "Wrong code", http.StatusUnprocessableEntity)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	err = s.db.EnableTOTP(r.Context(), user, step, hashes)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			http.Error(w, "No pending two-factor enrolment", http.StatusConflict)
+			return
+		}
+		sublog.Error().Err(err).Msg("Enable TOTP error")
+		internalError(w, err)
+		return
+	}
+	if current, err := auth.Session(r.Context()); err == nil {
+		err = s.db.VerifySession(r.Context(), current, now)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Verify session error")
+		}
+	}
+	s.audit(r, models.AuditEvent{Action: actionMFAEnable, Target: "user:" + user})
+	sublog.Info().Msgf("2FA of user %v enabled", user)
+	writeJSON(w, http.StatusOK, struct {
+		Codes []string `json:"recovery_codes"`
+	}{Codes: codes})
+}
+
+//postMFAVerify - handling /api/user/2fa/verify on method POST. Marks the session as passed 2FA check for withdraws above threshold
+func (s *Gophermart) postMFAVerify(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing 2FA check")
+	s.withSecondFactor(w, r, func(user string, now time.Time) error {
+		current, err := auth.Session(r.Context())
+		if err != nil {
+			return err
+		}
+		return s.db.VerifySession(r.Context(), current, now)
+	})
+}
+
+//postMFADisable - handling /api/user/2fa/disable on method POST
+func (s *Gophermart) postMFADisable(w http.ResponseWriter, r *http.Request) {
+	sublog.Info().Msg("Processing 2FA disabling")
+	s.withSecondFactor(w, r, func(user string, now time.Time) error {
+		err := s.db.DisableTOTP(r.Context(), user)
+		if err == nil {
+			s.audit(r, models.AuditEvent{Action: actionMFADisable, Target: "user:" + user})
+		}
+		return err
+	})
+}
+
+//withSecondFactor - checking the code of authorized user with enabled 2FA and running action. Wrong codes are throttled as failed logins
+func (s *Gophermart) withSecondFactor(w http.ResponseWriter, r *http.Request, action func(user string, now time.Time) error) {
+	user, err := helpers.GetUser(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req, ok := readMFACode(w, r)
+	if !ok {
+		return
+	}
+	ip := clientIP(r)
+	now := time.Now()
+	attempt, wait, err := s.reserveLogin(r.Context(), user, ip, now)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if wait > 0 {
+		retryAfter(w, wait)
+		return
+	}
+	defer attempt.release(r.Context())
+	u, err := s.db.GetUser(r.Context(), user)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	if !u.TOTPEnabled {
+		sublog.Info().Msgf("2FA of user %v is disabled", user)
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+	ok, err = s.checkSecondFactor(r.Context(), u, req.Code, now)
+	if err != nil {
+		sublog.Error().Err(err).Msg("2FA check error")
+		internalError(w, err)
+		return
+	}
+	if !ok {
+		sublog.Info().Msgf("2FA code of user %v invalid", user)
+		attempt.failed(r.Context())
+		http.Error(w, "Wrong code", http.StatusUnprocessableEntity)
+		return
+	}
+	err = action(user, now)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/totp"
+)
+
+func TestGophermart_TwoFactor(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.WithdrawMFA = 10 * models.Point
+	s.Config.WithdrawMFATTL = 5 * time.Minute
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctype := map[string]string{"Content-Type": "application/json"}
+	credentials := userReq(t, models.User{Name: "user111", Password: "password111"})
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/register", credentials, ctype)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.NoError(t, s.db.UpdateBalance(context.Background(), "user111", 100*models.Point))
+	code := func(shift int64) string {
+		c, err := totp.Code(secret(t, s), totp.Step(time.Now())+shift)
+		require.NoError(t, err)
+		return `{"code":"` + c + `"}`
+	}
+	withdrawBody := func(client *cookiejar.Jar, order string, sum int) (int, string) {
+		body := userReq(t, struct {
+			Order string       `json:"order"`
+			Sum   models.Money `json:"sum"`
+		}{Order: order, Sum: models.Money(sum) * models.Point})
+		response, body := testRequest(t, ts, client, http.MethodPost, "/api/user/balance/withdraw", body, ctype)
+		return response.StatusCode, body
+	}
+	withdraw := func(client *cookiejar.Jar, order string, sum int) int {
+		status, _ := withdrawBody(client, order, sum)
+		return status
+	}
+	login := func() string {
+		client, _ := cookiejar.New(nil)
+		response, body := testRequest(t, ts, client, http.MethodPost, "/api/user/login", credentials, ctype)
+		require.Equal(t, http.StatusAccepted, response.StatusCode)
+		challenge := struct {
+			Token string `json:"mfa_token"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(body), &challenge))
+		require.NotEmpty(t, challenge.Token)
+		return challenge.Token
+	}
+	recovery := make([]string, 0)
+
+	t.Run("Withdraw above threshold without 2FA", func(t *testing.T) {
+		status, body := withdrawBody(jar, "84410807816", 20)
+		require.Equal(t, http.StatusForbidden, status)
+		require.Contains(t, body, "Two-factor authentication must be enabled to withdraw more than 10")
+		require.Contains(t, body, "/api/user/2fa/enroll")
+		require.Equal(t, http.StatusOK, withdraw(jar, "84410807816", 5))
+	})
+	t.Run("Enrolment", func(t *testing.T) {
+		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/user/2fa/enroll", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		enrolment := struct {
+			Secret string `json:"secret"`
+			URI    string `json:"otpauth_uri"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(body), &enrolment))
+		require.Equal(t, secret(t, s), enrolment.Secret)
+		require.Contains(t, enrolment.URI, "otpauth://totp/Gophermart:user111?")
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/2fa/confirm", `{"code":"000000"}`, ctype)
+		require.Equal(t, http.StatusUnprocessableEntity, response.StatusCode)
+		response, body = testRequest(t, ts, jar, http.MethodPost, "/api/user/2fa/confirm", code(0), ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		codes := struct {
+			Codes []string `json:"recovery_codes"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(body), &codes))
+		require.Len(t, codes.Codes, recoveryCount)
+		recovery = codes.Codes
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/2fa/enroll", "", nil)
+		require.Equal(t, http.StatusConflict, response.StatusCode)
+		require.Equal(t, http.StatusOK, withdraw(jar, "79927398713", 20))
+	})
+	t.Run("Second login step", func(t *testing.T) {
+		token := login()
+		tests := []struct {
+			name  string
+			token string
+			code  string
+			want  int
+		}{
+			{name: "Wrong token", token: "garbage", code: code(1), want: http.StatusUnauthorized},
+			{name: "Used code", token: token, code: code(0), want: http.StatusUnauthorized},
+			{name: "Next code", token: token, code: code(1), want: http.StatusOK},
+			{name: "Recovery code", token: login(), code: `{"code":"` + recovery[0] + `"}`, want: http.StatusOK},
+			{name: "Used recovery code", token: login(), code: `{"code":"` + recovery[0] + `"}`, want: http.StatusUnauthorized},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				client, _ := cookiejar.New(nil)
+				body := `{"mfa_token":"` + tt.token + `",` + tt.code[1:]
+				response, _ := testRequest(t, ts, client, http.MethodPost, "/api/user/login/2fa", body, ctype)
+				require.Equal(t, tt.want, response.StatusCode)
+				response, _ = testRequest(t, ts, client, http.MethodGet, "/api/user/balance", "", nil)
+				if tt.want == http.StatusOK {
+					require.Equal(t, http.StatusOK, response.StatusCode)
+				} else {
+					require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+				}
+			})
+		}
+	})
+	t.Run("Fresh check for withdraw", func(t *testing.T) {
+		sessions, err := s.db.GetSessions(context.Background(), "user111")
+		require.NoError(t, err)
+		for _, session := range sessions {
+			require.NoError(t, s.db.VerifySession(context.Background(), session.ID, time.Now().Add(-time.Hour)))
+		}
+		status, body := withdrawBody(jar, "577277243060172", 20)
+		require.Equal(t, http.StatusForbidden, status)
+		require.Contains(t, body, "Fresh two-factor authentication required")
+		require.Equal(t, http.StatusOK, withdraw(jar, "577277243060172", 5))
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/2fa/verify", `{"code":"`+recovery[1]+`"}`, ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, http.StatusOK, withdraw(jar, "12345678903", 20))
+	})
+	t.Run("Disable", func(t *testing.T) {
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/2fa/disable", `{"code":"`+recovery[1]+`"}`, ctype)
+		require.Equal(t, http.StatusUnprocessableEntity, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/2fa/disable", `{"code":"`+recovery[2]+`"}`, ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		client, _ := cookiejar.New(nil)
+		response, _ = testRequest(t, ts, client, http.MethodPost, "/api/user/login", credentials, ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+//secret - current TOTP secret of the test user
+func secret(t *testing.T, s *Gophermart) string {
+	u, err := s.db.GetUser(context.Background(), "user111")
+	require.NoError(t, err)
+	return u.TOTPSecret
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/storage"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/webhooks"
+)
+
+const (
+	webhookBatch       = 1               //Deliveries claimed at once by the worker. Delivery is claimed right before sending, so the lease covers one request
+	webhookMargin      = 5 * time.Second //Spare time of the lease after the request and storing of its result
+	webhookPoll        = time.Second     //Delay between polls of empty outbox
+	webhookMaxBackoff  = time.Hour       //Upper limit of delay between attempts
+	deliveriesLimit    = 100             //Page size of deliveries listing if limit is not set
+	deliveriesMaxLimit = 1000            //Maximum page size of deliveries listing
+)
+
+//ownerFunc - resolving owner of subscriptions managed by the request
+type ownerFunc func(r *http.Request) (string, error)
+
+//globalOwner - subscriptions to events of all users managed by admins
+func globalOwner(r *http.Request) (string, error) {
+	return "", nil
+}
+
+//webhookRoutes - routes of subscriptions management. User's routes manage own subscriptions, admin's routes manage subscriptions to events of all users
+func (s *Gophermart) webhookRoutes(owner ownerFunc) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Post("/", s.postWebhook(owner))
+		r.Get("/", s.getWebhooks(owner))
+		r.Delete("/{id}", s.deleteWebhook(owner))
+		r.Get("/{id}/deliveries", s.getDeliveries(owner))
+		r.Post("/{id}/deliveries/{delivery}/redeliver", s.postRedeliver(owner))
+	}
+}
+
+//webhookLease - time the claimed delivery is hidden from other workers. It covers WEBHOOK_TIMEOUT of the request and storing of its result
+func (s *Gophermart) webhookLease() time.Duration {
+	return s.Config.WebhookTimeout + s.Config.DBWriteTimeout + webhookMargin
+}
+
+//deliveryWorker - sending due webhook deliveries until ctx is done. Workers of all instances share the outbox
+func (s *Gophermart) deliveryWorker(ctx context.Context) {
+	defer s.workers.Done()
+	subsublog := sublog.With().Str("subcomponent", "webhook worker").Logger()
+	subsublog.Debug().Msg("Worker started")
+	for ctx.Err() == nil {
+		deliveries, err := s.db.ClaimDeliveries(ctx, webhookBatch, s.webhookLease())
+		if err != nil {
+			subsublog.Error().Err(err).Msg("Error while claiming webhook deliveries")
+		}
+		for _, d := range deliveries {
+			s.processDelivery(ctx, d)
+		}
+		if len(deliveries) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(webhookPoll):
+		}
+	}
+	subsublog.Debug().Msg("Worker stopped")
+}
+
+//webhookDelay - exponential delay before the next attempt
+func webhookDelay(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt-1)
+	if d <= 0 || d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d
+}
+
+//processDelivery - sending claimed delivery and storing the result. Failed delivery is retried with backoff until attempts are over and then moved to dead letters
+func (s *Gophermart) processDelivery(ctx context.Context, d models.WebhookDelivery) {
+	claimed := d.Next
+	err := s.webhooks.Send(ctx, d)
+	if err != nil && ctx.Err() != nil {
+		sublog.Debug().Msgf("Webhook delivery %v interrupted. It will be claimed again after lease expires", d.ID)
+		return
+	}
+	switch {
+	case err == nil:
+		sublog.Info().Msgf("Webhook delivery %v of %v sent", d.ID, d.Event)
+		d.Status = models.DeliveryDelivered
+		d.LastError = ""
+	case d.Attempts >= s.Config.WebhookAttempts:
+		sublog.Info().Err(err).Msgf("Webhook delivery %v failed %v times. Moving to dead letters", d.ID, d.Attempts)
+		d.Status = models.DeliveryDead
+		d.LastError = err.Error()
+	default:
+		delay := webhookDelay(s.Config.WebhookBackoff, d.Attempts)
+		sublog.Info().Err(err).Msgf("Webhook delivery %v failed. Retrying in %v", d.ID, delay)
+		d.Status = models.DeliveryPending
+		d.Next = time.Now().Add(delay)
+		d.LastError = err.Error()
+	}
+	//Result is stored even when shutdown has started. Storage timeout bounds the call
+	err = s.db.UpdateDelivery(context.Background(), d, claimed)
+	if errors.Is(err, storage.ErrLeaseLost) {
+		sublog.Warn().Msgf("Lease of webhook delivery %v expired before storing the result. Delivery belongs to another worker", d.ID)
+		return
+	}
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while storing result of webhook delivery %v. Delivery will be sent again after lease expires", d.ID)
+	}
+}
+
+//webhookEvents - checking subscribed event types and removing duplicates
+func webhookEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no events")
+	}
+	known := make(map[string]bool)
+	for _, e := range models.WebhookEvents {
+		known[e] = true
+	}
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(events))
+	for _, e := range events {
+		if !known[e] {
+			return nil, fmt.Errorf("unknown event %q", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+//ownedWebhook - reading subscription from URL and checking its owner. Subscriptions of others are reported as missing
+func (s *Gophermart) ownedWebhook(w http.ResponseWriter, r *http.Request, owner ownerFunc) (models.Webhook, bool) {
+	login, err := owner(r)
+	if err != nil {
+		sublog.Info().Msg("Username not recognized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return models.Webhook{}, false
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return models.Webhook{}, false
+	}
+	hook, err := s.db.GetWebhook(r.Context(), id)
+	if err != nil {
+		if helpers.EmptyRow(err) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return hook, false
+		}
+		internalError(w, err)
+		return hook, false
+	}
+	if hook.Owner != login {
+		sublog.Info().Msgf("Webhook %v doesn't belong to %q", id, login)
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return hook, false
+	}
+	return hook, true
+}
+
+//postWebhook - handling webhooks subscription on method POST. Secret of the signature is shown only in this answer
+func (s *Gophermart) postWebhook(owner ownerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login, err := owner(r)
+		if err != nil {
+			sublog.Info().Msg("Username not recognized")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			sublog.Info().Msg("Content type invalid")
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		req := struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+		}{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			sublog.Info().Err(err).Msg("Error while parsing JSON body")
+			http.Error(w, "Incorrect request format", http.StatusBadRequest)
+			return
+		}
+		err = webhooks.CheckURL(r.Context(), req.URL, s.Config.WebhookAllowedNetworks)
+		if err != nil {
+			sublog.Info().Err(err).Msgf("Invalid webhook URL %q", req.URL)
+			if errors.Is(err, webhooks.ErrForbiddenAddress) {
+				http.Error(w, "Webhook URL points to internal address", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Invalid webhook URL", http.StatusBadRequest)
+			return
+		}
+		events, err := webhookEvents(req.Events)
+		if err != nil {
+			sublog.Info().Err(err).Msg("Invalid webhook events")
+			http.Error(w, "Invalid webhook events", http.StatusBadRequest)
+			return
+		}
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		hook, err := s.db.CreateWebhook(r.Context(), models.Webhook{Owner: login, URL: req.URL, Secret: secret, Events: events})
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		s.audit(r, models.AuditEvent{
+			Action: actionWebhookCreate,
+			Target: fmt.Sprintf("webhook:%d", hook.ID),
+			After:  auditState(models.Webhook{ID: hook.ID, Owner: hook.Owner, URL: hook.URL, Events: hook.Events, Created: hook.Created}),
+		})
+		writeJSON(w, http.StatusCreated, hook)
+	}
+}
+
+//getWebhooks - handling webhooks listing on method GET
+func (s *Gophermart) getWebhooks(owner ownerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login, err := owner(r)
+		if err != nil {
+			sublog.Info().Msg("Username not recognized")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		hooks, err := s.db.GetWebhooks(r.Context(), login)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, hooks)
+	}
+}
+
+//deleteWebhook - handling webhook removal on method DELETE. Pending deliveries of the subscription are dropped
+func (s *Gophermart) deleteWebhook(owner ownerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, ok := s.ownedWebhook(w, r, owner)
+		if !ok {
+			return
+		}
+		err := s.db.DeleteWebhook(r.Context(), hook.ID)
+		if err != nil && !helpers.EmptyRow(err) {
+			internalError(w, err)
+			return
+		}
+		s.audit(r, models.AuditEvent{
+			Action: actionWebhookDelete,
+			Target: fmt.Sprintf("webhook:%d", hook.ID),
+			Before: auditState(hook),
+		})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//getDeliveries - handling deliveries listing of the webhook on method GET. Newest deliveries are first
+func (s *Gophermart) getDeliveries(owner ownerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, ok := s.ownedWebhook(w, r, owner)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		status := q.Get("status")
+		switch status {
+		case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+		default:
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		limit := deliveriesLimit
+		if v := q.Get("limit"); v != "" {
+			l, err := strconv.Atoi(v)
+			if err != nil || l <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = l
+			if limit > deliveriesMaxLimit {
+				limit = deliveriesMaxLimit
+			}
+		}
+		deliveries, err := s.db.GetDeliveries(r.Context(), hook.ID, status, limit)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, deliveries)
+	}
+}
+
+//postRedeliver - handling manual redelivery on method POST. Delivery in any status is sent again with fresh attempts counter
+func (s *Gophermart) postRedeliver(owner ownerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, ok := s.ownedWebhook(w, r, owner)
+		if !ok {
+			return
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "delivery"), 10, 64)
+		if err != nil {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		d, err := s.db.GetDelivery(r.Context(), id)
+		if err != nil {
+			if helpers.EmptyRow(err) {
+				http.Error(w, "Delivery not found", http.StatusNotFound)
+				return
+			}
+			internalError(w, err)
+			return
+		}
+		if d.Webhook != hook.ID {
+			sublog.Info().Msgf("Delivery %v doesn't belong to webhook %v", id, hook.ID)
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		err = s.db.RedeliverDelivery(r.Context(), id)
+		if err != nil {
+			if helpers.EmptyRow(err) {
+				http.Error(w, "Delivery not found", http.StatusNotFound)
+				return
+			}
+			internalError(w, err)
+			return
+		}
+		s.audit(r, models.AuditEvent{
+			Action: actionRedeliver,
+			Target: fmt.Sprintf("webhook:%d", hook.ID),
+			Before: auditState(map[string]interface{}{"delivery": d.ID, "status": d.Status, "attempts": d.Attempts}),
+			After:  auditState(map[string]interface{}{"delivery": d.ID, "status": models.DeliveryPending, "attempts": 0}),
+		})
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/webhooks"
+)
+
+//receiver - webhook receiver answering with configured status and keeping verified requests
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	answer   int
+	received []webhooks.Envelope
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if webhooks.Verify(rc.secret, r.Header.Get(webhooks.SignatureHeader), body, time.Minute, time.Now()) != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	envelope := webhooks.Envelope{}
+	_ = json.Unmarshal(body, &envelope)
+	rc.received = append(rc.received, envelope)
+	w.WriteHeader(rc.answer)
+}
+
+func (rc *receiver) set(secret string, answer int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.secret = secret
+	rc.answer = answer
+}
+
+func Test_webhookDelay(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	require.Equal(t, 30*time.Second, webhookDelay(30*time.Second, 1))
+	require.Equal(t, 2*time.Minute, webhookDelay(30*time.Second, 3))
+	require.Equal(t, webhookMaxBackoff, webhookDelay(30*time.Second, 10))
+	require.Equal(t, webhookMaxBackoff, webhookDelay(30*time.Second, 100))
+}
+
+func TestGophermart_Webhooks(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	jar, r, s := newMemoryServer(t)
+	s.Config.WebhookAttempts = 2
+	s.Config.WebhookBackoff = time.Nanosecond
+	s.Config.WebhookAllowedNetworks, _ = models.ParseNetworks("127.0.0.1")
+	s.webhooks = webhooks.New(s.Config)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	rc := &receiver{}
+	partner := httptest.NewServer(rc)
+	defer partner.Close()
+	otherJar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	ctype := map[string]string{"Content-Type": "application/json"}
+	for login, j := range map[string]*cookiejar.Jar{"user111": jar, "user222": otherJar} {
+		response, _ := testRequest(t, ts, j, http.MethodPost, "/api/user/register", userReq(t, models.User{Name: login, Password: "password111"}), ctype)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	//deliver - sending all due deliveries once
+	deliver := func() {
+		time.Sleep(time.Millisecond)
+		deliveries, err := s.db.ClaimDeliveries(context.Background(), 10, s.webhookLease())
+		require.NoError(t, err)
+		for _, d := range deliveries {
+			s.processDelivery(context.Background(), d)
+		}
+	}
+
+	t.Run("Subscription", func(t *testing.T) {
+		tests := []struct {
+			name string
+			body string
+			want int
+		}{
+			{name: "Relative URL", body: `{"url":"/hook","events":["order.processed"]}`, want: http.StatusBadRequest},
+			{name: "Not HTTP URL", body: `{"url":"ftp://partner/hook","events":["order.processed"]}`, want: http.StatusBadRequest},
+			{name: "Private address", body: `{"url":"http://10.0.0.1/hook","events":["order.processed"]}`, want: http.StatusBadRequest},
+			{name: "Cloud metadata", body: `{"url":"http://169.254.169.254/latest/meta-data","events":["order.processed"]}`, want: http.StatusBadRequest},
+			{name: "Loopback not allowed", body: `{"url":"http://[::1]:8081/api/user/balance","events":["order.processed"]}`, want: http.StatusBadRequest},
+			{name: "No events", body: fmt.Sprintf(`{"url":%q,"events":[]}`, partner.URL), want: http.StatusBadRequest},
+			{name: "Unknown event", body: fmt.Sprintf(`{"url":%q,"events":["order.lost"]}`, partner.URL), want: http.StatusBadRequest},
+			{name: "Created", body: fmt.Sprintf(`{"url":%q,"events":["order.processed","balance.withdrawn","order.processed"]}`, partner.URL), want: http.StatusCreated},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				response, body := testRequest(t, ts, jar, http.MethodPost, "/api/user/webhooks", tt.body, ctype)
+				require.Equal(t, tt.want, response.StatusCode, body)
+				if tt.want != http.StatusCreated {
+					return
+				}
+				hook := models.Webhook{}
+				require.NoError(t, json.Unmarshal([]byte(body), &hook))
+				require.Len(t, hook.Secret, 64)
+				require.Equal(t, []string{models.WebhookOrderProcessed, models.WebhookWithdrawal}, hook.Events)
+				rc.set(hook.Secret, http.StatusInternalServerError)
+			})
+		}
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/user/webhooks", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.NotContains(t, body, `"secret"`)
+		require.Contains(t, body, `"id":1`)
+		response, body = testRequest(t, ts, otherJar, http.MethodGet, "/api/user/webhooks", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, "[]", body)
+		response, _ = testRequest(t, ts, otherJar, http.MethodGet, "/api/user/webhooks/1/deliveries", "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("Retries and dead letters", func(t *testing.T) {
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/user/orders", "79927398713", map[string]string{"Content-Type": "text/plain"})
+		require.Equal(t, http.StatusAccepted, response.StatusCode)
+		_, err = s.db.CompleteOrder(context.Background(), "79927398713", "user111", "", "PROCESSED", 500)
+		require.NoError(t, err)
+		deliver()
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/user/webhooks/1/deliveries", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		deliveries := make([]models.WebhookDelivery, 0)
+		require.NoError(t, json.Unmarshal([]byte(body), &deliveries))
+		require.Len(t, deliveries, 1)
+		require.Equal(t, models.DeliveryPending, deliveries[0].Status)
+		require.Equal(t, 1, deliveries[0].Attempts)
+		require.Contains(t, deliveries[0].LastError, "500")
+		deliver()
+		response, body = testRequest(t, ts, jar, http.MethodGet, "/api/user/webhooks/1/deliveries?status=DEAD", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.NoError(t, json.Unmarshal([]byte(body), &deliveries))
+		require.Len(t, deliveries, 1)
+		require.Equal(t, 2, deliveries[0].Attempts)
+		deliver()
+		rc.mu.Lock()
+		require.Len(t, rc.received, 2)
+		require.Equal(t, rc.received[0].ID, rc.received[1].ID)
+		require.Equal(t, models.WebhookOrderProcessed, rc.received[0].Event)
+		rc.mu.Unlock()
+		response, _ = testRequest(t, ts, jar, http.MethodGet, "/api/user/webhooks/1/deliveries?status=LOST", "", nil)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("Redelivery", func(t *testing.T) {
+		rc.mu.Lock()
+		rc.answer = http.StatusOK
+		rc.mu.Unlock()
+		response, _ := testRequest(t, ts, otherJar, http.MethodPost, "/api/user/webhooks/1/deliveries/1/redeliver", "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/webhooks/1/deliveries/100/redeliver", "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/user/webhooks/1/deliveries/1/redeliver", "", nil)
+		require.Equal(t, http.StatusAccepted, response.StatusCode)
+		deliver()
+		response, body := testRequest(t, ts, jar, http.MethodGet, "/api/user/webhooks/1/deliveries?status=DELIVERED", "", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Contains(t, body, `"id":1`)
+		events, err := s.db.GetAuditEvents(context.Background(), models.AuditFilter{Action: actionRedeliver})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, "webhook:1", events[0].Target)
+	})
+
+	t.Run("Global subscription", func(t *testing.T) {
+		body := fmt.Sprintf(`{"url":%q,"events":["balance.withdrawn"]}`, partner.URL)
+		response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/admin/webhooks", body, ctype)
+		require.Equal(t, http.StatusForbidden, response.StatusCode)
+		require.NoError(t, s.db.SetRole(context.Background(), "user111", models.RoleAdmin))
+		response, created := testRequest(t, ts, jar, http.MethodPost, "/api/admin/webhooks", body, ctype)
+		require.Equal(t, http.StatusCreated, response.StatusCode)
+		hook := models.Webhook{}
+		require.NoError(t, json.Unmarshal([]byte(created), &hook))
+		require.Empty(t, hook.Owner)
+		rc.set(hook.Secret, http.StatusOK)
+		response, _ = testRequest(t, ts, jar, http.MethodGet, fmt.Sprintf("/api/user/webhooks/%d/deliveries", hook.ID), "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+
+		require.NoError(t, s.db.UpdateBalance(context.Background(), "user222", 1000))
+		require.NoError(t, s.db.CreateWithdraw(context.Background(), 200, "user222", "12345678903"))
+		deliver()
+		rc.mu.Lock()
+		last := rc.received[len(rc.received)-1]
+		rc.mu.Unlock()
+		require.Equal(t, models.WebhookWithdrawal, last.Event)
+		require.Equal(t, "user222", last.User)
+
+		response, _ = testRequest(t, ts, jar, http.MethodDelete, fmt.Sprintf("/api/admin/webhooks/%d", hook.ID), "", nil)
+		require.Equal(t, http.StatusNoContent, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodDelete, fmt.Sprintf("/api/admin/webhooks/%d", hook.ID), "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		response, _ = testRequest(t, ts, jar, http.MethodDelete, "/api/admin/webhooks/1", "", nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+}
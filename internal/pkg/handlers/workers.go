@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/storage"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
+)
+
+const (
+	accrualMargin = 5 * time.Second //Spare time of the lease after the poll deadline and storing of the answer
+	accrualPoll   = 1 * time.Second //Delay between polls of order in progress and of empty queue
+	accrualRetry  = 5 * time.Second //Delay before the next poll after failed request
+)
+
+//tracer - tracer of accrual jobs
+var tracer = otel.Tracer("github.com/t1mon-ggg/gophermart/internal/pkg/handlers")
+
+//StartWorkers - starting fixed pool of accrual workers, user events background jobs and webhook delivery worker. Workers share the job queue with other instances and stop when ctx is done
+func (s *Gophermart) StartWorkers(ctx context.Context) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gophermart"
+	}
+	sublog.Info().Msgf("Starting %v accrual workers", s.Config.Workers)
+	for i := 0; i < s.Config.Workers; i++ {
+		s.workers.Add(1)
+		go s.worker(ctx, fmt.Sprintf("%s-%d-%d", host, os.Getpid(), i))
+	}
+	s.workers.Add(3)
+	go s.listenEvents(ctx)
+	go s.pruneEvents(ctx)
+	go s.deliveryWorker(ctx)
+}
+
+//WaitWorkers - waiting for all accrual workers to stop
+func (s *Gophermart) WaitWorkers() {
+	s.workers.Wait()
+}
+
+//accrualDeadline - limit of one poll of accrual system including pause on 429, retries and backoff
+func (s *Gophermart) accrualDeadline() time.Duration {
+	return accrual.MaxPoll(s.Config.AccrualTimeout)
+}
+
+//accrualLease - time the claimed job is hidden from other workers. It covers the poll deadline and storage writes of the answer and of the rescheduling,
+//so the job is not claimed by another worker while it is polled
+func (s *Gophermart) accrualLease() time.Duration {
+	return s.accrualDeadline() + 2*s.Config.DBWriteTimeout + accrualMargin
+}
+
+//worker - claiming and processing accrual jobs until ctx is done
+func (s *Gophermart) worker(ctx context.Context, id string) {
+	defer s.workers.Done()
+	subsublog := sublog.With().Str("subcomponent", "accrual worker").Str("worker", id).Logger()
+	subsublog.Debug().Msg("Worker started")
+	for ctx.Err() == nil {
+		jobs, err := s.db.ClaimJobs(ctx, id, 1, s.accrualLease())
+		if err != nil {
+			subsublog.Error().Err(err).Msg("Error while claiming accrual jobs")
+		}
+		for _, job := range jobs {
+			s.processJob(ctx, job, id)
+		}
+		if len(jobs) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(accrualPoll):
+		}
+	}
+	subsublog.Debug().Msg("Worker stopped")
+}
+
+//processJob - polling accrual system for the order claimed by the worker and returning unfinished job to the queue.
+//Every poll is a separate trace linked to the request which uploaded the order
+func (s *Gophermart) processJob(ctx context.Context, job models.Job, worker string) {
+	sublog.Debug().Msgf("Processing order %v. Attempt %v", job.Order, job.Attempts)
+	ctx, span := tracer.Start(ctx, "accrual.poll", trace.WithNewRoot(), trace.WithLinks(tracing.Link(job.Trace)...), trace.WithAttributes(
+		attribute.String("gophermart.order", job.Order),
+		attribute.Int("gophermart.attempt", job.Attempts),
+	))
+	defer span.End()
+	delay := s.accrualAPI(ctx, job.User, job.Order, worker)
+	if delay == 0 {
+		return
+	}
+	span.SetAttributes(attribute.String("gophermart.retry_in", delay.String()))
+	//Job is returned to the queue even when shutdown has started. Storage timeout bounds the call
+	err := s.db.RescheduleJob(tracing.Detach(ctx), job.Order, worker, delay)
+	if errors.Is(err, storage.ErrLeaseLost) {
+		sublog.Warn().Msgf("Lease of order %v expired before rescheduling. Job belongs to another worker", job.Order)
+		return
+	}
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while rescheduling order %v. Job will be claimed again after lease expires", job.Order)
+	}
+}
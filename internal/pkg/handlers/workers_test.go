@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/accrual/mock"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/metrics"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestGophermart_accrualAPI(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	type wanted struct {
+		delay   time.Duration
+		status  string
+		balance models.Money
+	}
+	tests := []struct {
+		name    string
+		order   string
+		answers []models.Accrual
+		err     error
+		want    wanted
+	}{
+		{
+			name:    "Processed order",
+			order:   "123455",
+			answers: []models.Accrual{{Order: "123455", Status: "PROCESSED", Value: 500 * models.Point}},
+			want:    wanted{delay: 0, status: "PROCESSED", balance: 500 * models.Point},
+		},
+		{
+			name:    "Invalid order",
+			order:   "12345678903",
+			answers: []models.Accrual{{Order: "12345678903", Status: "INVALID", Value: 100 * models.Point}},
+			want:    wanted{delay: 0, status: "INVALID", balance: 500 * models.Point},
+		},
+		{
+			name:    "Order in progress",
+			order:   "84410807816",
+			answers: []models.Accrual{{Order: "84410807816", Status: "PROCESSING"}},
+			want:    wanted{delay: accrualPoll, status: "PROCESSING", balance: 500 * models.Point},
+		},
+		{
+			name:  "Not registered order",
+			order: "2377225624",
+			want:  wanted{delay: accrualRetry, status: "NEW", balance: 500 * models.Point},
+		},
+		{
+			name:  "Rate limited",
+			order: "4561261212345467",
+			err:   &accrual.RateLimitError{Retry: 42 * time.Second},
+			want:  wanted{delay: 42 * time.Second, status: "NEW", balance: 500 * models.Point},
+		},
+		{
+			name:  "Bad response",
+			order: "79927398713",
+			err:   accrual.ErrBadResponse,
+			want:  wanted{delay: accrualRetry, status: "NEW", balance: 500 * models.Point},
+		},
+	}
+	_, _, s := newMemoryServer(t)
+	fake := accrual.NewFake()
+	s.accrual = fake
+	require.NoError(t, s.db.CreateUser(context.Background(), "user111", "password111", "random"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, s.db.CreateOrder(context.Background(), tt.order, "user111"))
+			for _, a := range tt.answers {
+				fake.Add(tt.order, a, nil)
+			}
+			if tt.err != nil {
+				fake.Add(tt.order, models.Accrual{}, tt.err)
+			}
+			delay := s.accrualAPI(context.Background(), "user111", tt.order, "")
+			require.Equal(t, tt.want.delay, delay)
+			orders, err := s.db.GetOrders(context.Background(), "user111")
+			require.NoError(t, err)
+			for _, o := range orders {
+				if o.Number == tt.order {
+					require.Equal(t, tt.want.status, o.Status)
+				}
+			}
+			balance, err := s.db.GetBalance(context.Background(), "user111")
+			require.NoError(t, err)
+			require.Equal(t, tt.want.balance, balance.Balance)
+		})
+	}
+}
+
+func TestGophermart_accrualAPICompleted(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	_, _, s := newMemoryServer(t)
+	fake := accrual.NewFake()
+	s.accrual = fake
+	require.NoError(t, s.db.CreateUser(context.Background(), "user111", "password111", "random"))
+	require.NoError(t, s.db.CreateOrder(context.Background(), "79927398713", "user111"))
+	before := testutil.ToFloat64(metrics.PointsAccrued)
+	//the second poll of already completed order happens when the job lease expires before the first result is stored
+	for i := 0; i < 2; i++ {
+		fake.Add("79927398713", models.Accrual{Order: "79927398713", Status: "PROCESSED", Value: 500 * models.Point}, nil)
+		require.Equal(t, time.Duration(0), s.accrualAPI(context.Background(), "user111", "79927398713", ""))
+	}
+	require.Equal(t, 500.0, testutil.ToFloat64(metrics.PointsAccrued)-before)
+	balance, err := s.db.GetBalance(context.Background(), "user111")
+	require.NoError(t, err)
+	require.Equal(t, 500*models.Point, balance.Balance)
+}
+
+func TestGophermart_processJobLeaseLost(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	_, _, s := newMemoryServer(t)
+	fake := accrual.NewFake()
+	s.accrual = fake
+	s.Config.AccrualTimeout = 5 * time.Second
+	require.Greater(t, s.accrualLease(), accrual.MaxPoll(s.Config.AccrualTimeout))
+	require.NoError(t, s.db.CreateUser(context.Background(), "user111", "password111", "random"))
+	require.NoError(t, s.db.CreateOrder(context.Background(), "79927398713", "user111"))
+	jobs, err := s.db.ClaimJobs(context.Background(), "worker1", 1, s.accrualLease())
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	answer := models.Accrual{Order: "79927398713", Status: "PROCESSED", Value: 500 * models.Point}
+	fake.Add("79927398713", answer, nil)
+	s.processJob(context.Background(), jobs[0], "worker2")
+	balance, err := s.db.GetBalance(context.Background(), "user111")
+	require.NoError(t, err)
+	require.Equal(t, models.Money(0), balance.Balance)
+	fake.Add("79927398713", answer, nil)
+	s.processJob(context.Background(), jobs[0], "worker1")
+	balance, err = s.db.GetBalance(context.Background(), "user111")
+	require.NoError(t, err)
+	require.Equal(t, 500*models.Point, balance.Balance)
+}
+
+func TestGophermart_Workers(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	_, _, s := newMemoryServer(t)
+	fake := accrual.NewFake()
+	fake.Add("123455", models.Accrual{Order: "123455", Status: "PROCESSING"}, nil)
+	fake.Add("123455", models.Accrual{Order: "123455", Status: "PROCESSED", Value: models.Money(72998)}, nil)
+	s.accrual = fake
+	s.Config.Workers = 2
+	require.NoError(t, s.db.CreateUser(context.Background(), "user111", "password111", "random"))
+	require.NoError(t, s.db.CreateOrder(context.Background(), "123455", "user111"))
+	ctx, cancel := context.WithCancel(context.Background())
+	s.StartWorkers(ctx)
+	require.Eventually(t, func() bool {
+		orders, err := s.db.GetOrders(context.Background(), "user111")
+		return err == nil && len(orders) == 1 && orders[0].Status == "PROCESSED"
+	}, 10*time.Second, 100*time.Millisecond)
+	cancel()
+	s.WaitWorkers()
+	balance, err := s.db.GetBalance(context.Background(), "user111")
+	require.NoError(t, err)
+	require.Equal(t, models.Money(72998), balance.Balance)
+	require.Equal(t, 2, fake.Calls("123455"))
+}
+
+func TestGophermart_WorkersMock(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	r := chi.NewRouter()
+	r.Route("/", mock.New(1).Router)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	_, _, s := newMemoryServer(t)
+	s.Config.AccSystem = ts.URL
+	s.Config.AccrualRPS = 100
+	s.Config.AccrualConcurrency = 2
+	s.Config.AccrualTimeout = time.Second
+	s.Config.Workers = 2
+	s.accrual = accrual.New(s.Config)
+	testaccrualrequests(t, s.Config)
+	response, err := http.Post(ts.URL+"/mock/faults", "application/json", strings.NewReader(`{"status":500,"count":2}`))
+	require.NoError(t, err)
+	response.Body.Close()
+	require.NoError(t, s.db.CreateUser(context.Background(), "user111", "password111", "random"))
+	require.NoError(t, s.db.CreateOrder(context.Background(), "123455", "user111"))
+	ctx, cancel := context.WithCancel(context.Background())
+	s.StartWorkers(ctx)
+	require.Eventually(t, func() bool {
+		orders, err := s.db.GetOrders(context.Background(), "user111")
+		return err == nil && len(orders) == 1 && orders[0].Status == "PROCESSED"
+	}, 10*time.Second, 100*time.Millisecond)
+	cancel()
+	s.WaitWorkers()
+	balance, err := s.db.GetBalance(context.Background(), "user111")
+	require.NoError(t, err)
+	require.Equal(t, 2500*models.Point, balance.Balance)
+}
@@ -1,21 +1,21 @@
 package helpers
 
 import (
-	"crypto/hmac"
-	"crypto/md5"
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"math/big"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/neonxp/checksum"
 	"github.com/neonxp/checksum/luhn"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/auth"
 )
 
 const (
@@ -46,72 +46,22 @@ func ComparePassword(s, h string) bool {
 	return false
 }
 
-/*
-GenerateCookieValue - генерация значения cookie
-
-u - username,
-
-h - hashed password,
-
-ip - user ip address,
-
-r - random string
-*/
-func GenerateCookieValue(u, h, ip, r string) string {
-	data := []byte(u + h + ip)
-	d := md5.Sum(data)
-	digest := make([]byte, 0)
-	for i := range d {
-		digest = append(digest, d[i])
-	}
-	sublog.Debug().Msgf("Cookie data is %s", hex.EncodeToString(digest))
-	hash := hmac.New(sha256.New, []byte(r))
-	hash.Write(digest)
-	sign := hash.Sum(nil)
-	sublog.Debug().Msgf("Cookie sign is %s", hex.EncodeToString(sign))
-	value := hex.EncodeToString(digest) + ":" + hex.EncodeToString(sign)
-	sublog.Debug().Msgf("Cookie value is %s", value)
-	return value
-}
-
-/*
-CompareCookie - проверка значения cookie
-
-c - cookie value,
-
-u - username,
+//dummyHash - bcrypt hash compared on login of unknown users
+var dummyHash = struct {
+	once sync.Once
+	hash string
+}{}
 
-h - hashed password,
-
-ip - user ip address,
-
-r - random string
-*/
-func CompareCookie(c, u, h, ip, r string) bool {
-	cc := strings.Split(c, ":")
-	sublog.Debug().Msgf("cookie data: %s, cookie sign: %s", cc[0], cc[1])
-	data := []byte(u + h + ip)
-	d := md5.Sum(data)
-	digest := make([]byte, 0)
-	for i := range d {
-		digest = append(digest, d[i])
-	}
-	sublog.Debug().Msgf("Cookie data is %s", hex.EncodeToString(digest))
-	if hex.EncodeToString(digest) != cc[0] {
-		sublog.Debug().Msg("Cookie is invalid. Data missmatch")
-		return false
-	}
-
-	hash := hmac.New(sha256.New, []byte(r))
-	hash.Write(digest)
-	sign := hash.Sum(nil)
-	sublog.Debug().Msgf("Cookie sign is %s", hex.EncodeToString(sign))
-	if hex.EncodeToString(sign) != cc[1] {
-		sublog.Debug().Msg("Cookie is invalid. Sign missmatch")
-		return false
-	}
-	sublog.Debug().Msg("Cookie is valid")
-	return true
+//DummyCompare - comparing password with hash of random password. Takes the same time as ComparePassword, so login timing does not reveal existing users
+func DummyCompare(s string) {
+	dummyHash.once.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte(RandStringRunes(16)), 10)
+		if err != nil {
+			sublog.Error().Err(err).Msg("")
+		}
+		dummyHash.hash = string(hash)
+	})
+	_ = bcrypt.CompareHashAndPassword([]byte(dummyHash.hash), []byte(s))
 }
 
 //RandStringRunes - generate random string with custom lenght
@@ -125,7 +75,6 @@ func RandStringRunes(n int) string {
 		}
 		b[i] = letters[num.Int64()]
 	}
-	sublog.Debug().Msgf("Random generation complete. Generated string is '%s'", string(b))
 	return string(b)
 }
 
@@ -181,28 +130,29 @@ func EmptyRow(err error) bool {
 	return err.Error() == "no rows in result set"
 }
 
-//SetCookie - writing new cookie to web response
-func SetCookie(w http.ResponseWriter, name, value string) {
+//SetCookie - writing new cookie to web response. Cookie is not available for scripts and expires with its value
+func SetCookie(w http.ResponseWriter, name, value string, expires time.Time) {
 	sublog.Debug().Msgf("Creating new cookie %v", name)
 	cookie := http.Cookie{
-		Name:   name,
-		Value:  value,
-		MaxAge: 0,
-		Path:   "/",
+		Name:     name,
+		Value:    value,
+		Expires:  expires,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, &cookie)
 }
 
-//GetUser - getting username from request cookies
+//GetUser - getting authorized username from request context
 func GetUser(r *http.Request) (string, error) {
-	sublog.Debug().Msg("Reading user name from cookie")
-	cookie, err := r.Cookie("username")
+	sublog.Debug().Msg("Reading user name from request context")
+	username, err := auth.User(r.Context())
 	if err != nil {
-		sublog.Error().Err(err)
+		sublog.Debug().Err(err).Msg("")
 		return "", err
 	}
-	username := cookie.Value
-	sublog.Debug().Msgf("Username from cookie is %v", username)
+	sublog.Debug().Msgf("Username from context is %v", username)
 	return username, nil
 }
 
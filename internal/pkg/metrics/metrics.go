@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const namespace = "gophermart"
+
+//Accrual system request outcomes
+const (
+	AccrualOK            = "ok"             //Accrual system answered 200 with valid body
+	AccrualNotRegistered = "not_registered" //Accrual system answered 204
+	AccrualRateLimited   = "rate_limited"   //Accrual system answered 429
+	AccrualServerError   = "server_error"   //Accrual system answered 5xx
+	AccrualBadResponse   = "bad_response"   //Accrual system answered with unknown status or invalid body
+	AccrualNetworkError  = "network_error"  //Request failed before any answer
+	AccrualCanceled      = "canceled"       //Request was cancelled by caller
+)
+
+//collectTimeout - limit of time for storage queries made on scrape
+const collectTimeout = 2 * time.Second
+
+var sublog = log.With().Str("component", "metrics").Logger()
+
+var (
+	//HTTPRequests - count of served HTTP requests by method, route pattern and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Count of served HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+	//HTTPDuration - latency of served HTTP requests by method, route pattern and status code
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of served HTTP requests by method, route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	//AccrualRequests - count of requests to accrual system by outcome
+	AccrualRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "requests_total",
+		Help:      "Count of requests to accrual system by outcome.",
+	}, []string{"outcome"})
+	//Registrations - count of registered users
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Count of registered users.",
+	})
+	//OrdersAccepted - count of orders accepted for processing
+	OrdersAccepted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_accepted_total",
+		Help:      "Count of orders accepted for processing.",
+	})
+	//PointsAccrued - sum of points accrued for processed orders
+	PointsAccrued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_accrued_total",
+		Help:      "Sum of points accrued for processed orders.",
+	})
+	//PointsWithdrawn - sum of points withdrawn by users
+	PointsWithdrawn = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_withdrawn_total",
+		Help:      "Sum of points withdrawn by users.",
+	})
+)
+
+//Points - converting amount in cents to points
+func Points(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+//New - creating registry with runtime, process and application metrics. Extra collectors are registered too
+func New(extra ...prometheus.Collector) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		AccrualRequests,
+		Registrations,
+		OrdersAccepted,
+		PointsAccrued,
+		PointsWithdrawn,
+	)
+	reg.MustRegister(extra...)
+	return reg
+}
+
+//Handler - serving metrics of the registry in Prometheus text format
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+}
+
+//PoolCollector - collector of database connection pool statistics
+type PoolCollector struct {
+	stat func() *pgxpool.Stat
+
+	acquired        *prometheus.Desc
+	idle            *prometheus.Desc
+	constructing    *prometheus.Desc
+	total           *prometheus.Desc
+	max             *prometheus.Desc
+	acquires        *prometheus.Desc
+	acquireDuration *prometheus.Desc
+	emptyAcquires   *prometheus.Desc
+	canceled        *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*PoolCollector)(nil)
+
+//NewPoolCollector - creating collector reading pool statistics on every scrape
+func NewPoolCollector(stat func() *pgxpool.Stat) *PoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+	}
+	return &PoolCollector{
+		stat:            stat,
+		acquired:        desc("acquired_connections", "Count of connections currently in use."),
+		idle:            desc("idle_connections", "Count of idle connections in the pool."),
+		constructing:    desc("constructing_connections", "Count of connections being established."),
+		total:           desc("total_connections", "Total count of connections in the pool."),
+		max:             desc("max_connections", "Maximum size of the pool."),
+		acquires:        desc("acquires_total", "Count of successful connection acquires."),
+		acquireDuration: desc("acquire_duration_seconds_total", "Total time spent on successful connection acquires."),
+		emptyAcquires:   desc("empty_acquires_total", "Count of acquires which had to wait for a connection."),
+		canceled:        desc("canceled_acquires_total", "Count of acquires cancelled by context."),
+	}
+}
+
+//Describe - sending descriptions of pool metrics
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.constructing
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquires
+	ch <- c.acquireDuration
+	ch <- c.emptyAcquires
+	ch <- c.canceled
+}
+
+//Collect - sending current pool statistics
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.constructing, prometheus.GaugeValue, float64(s.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceled, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+}
+
+//OrdersCollector - collector of count of orders waiting for accrual
+type OrdersCollector struct {
+	count   func(ctx context.Context) (map[string]int64, error)
+	pending *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*OrdersCollector)(nil)
+
+//NewOrdersCollector - creating collector querying pending orders on every scrape
+func NewOrdersCollector(count func(ctx context.Context) (map[string]int64, error)) *OrdersCollector {
+	return &OrdersCollector{
+		count:   count,
+		pending: prometheus.NewDesc(prometheus.BuildFQName(namespace, "orders", "pending"), "Count of orders waiting for accrual by status.", []string{"status"}, nil),
+	}
+}
+
+//Describe - sending description of pending orders metric
+func (c *OrdersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.pending
+}
+
+//Collect - sending current count of pending orders. Storage error is reported as invalid metric
+func (c *OrdersCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	counts, err := c.count(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Could not count pending orders")
+		ch <- prometheus.NewInvalidMetric(c.pending, err)
+		return
+	}
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(count), status)
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrdersCollector(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	var failure error
+	c := NewOrdersCollector(func(ctx context.Context) (map[string]int64, error) {
+		if failure != nil {
+			return nil, failure
+		}
+		return map[string]int64{"NEW": 2, "PROCESSING": 0}, nil
+	})
+	want := `
+# HELP gophermart_orders_pending Count of orders waiting for accrual by status.
+# TYPE gophermart_orders_pending gauge
+gophermart_orders_pending{status="NEW"} 2
+gophermart_orders_pending{status="PROCESSING"} 0
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+	failure = errors.New("storage is down")
+	require.Error(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestNew(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	reg := New()
+	Registrations.Inc()
+	PointsWithdrawn.Add(Points(12345))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	require.True(t, names["go_goroutines"])
+	require.True(t, names["gophermart_registrations_total"])
+	require.True(t, names["gophermart_points_withdrawn_total"])
+	require.GreaterOrEqual(t, testutil.ToFloat64(PointsWithdrawn), 123.45)
+	require.NotPanics(t, func() { New() })
+}
@@ -3,16 +3,32 @@ package middleware
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi"
+	chiMiddleware "github.com/go-chi/chi/middleware"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/metrics"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
 )
 
 var sublog = log.With().Str("component", "middleware").Logger()
 
+//tracer - tracer of incoming requests
+var tracer = otel.Tracer("github.com/t1mon-ggg/gophermart/internal/pkg/middleware")
+
 //TimeTracer - middleware for time tracking processing incoming requests
 func TimeTracer(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -57,3 +73,122 @@ func DecompressRequest(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+//RealIP - middleware for taking client address from X-Real-IP or X-Forwarded-For headers. Headers are trusted only if the request came
+//from one of trusted proxies, otherwise TCP peer address is kept. In X-Forwarded-For the rightmost address not belonging to trusted proxies is taken
+func RealIP(trusted models.Networks) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := realIP(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//realIP - client address from forwarding headers or empty string if they are not trusted or missing
+func realIP(r *http.Request, trusted models.Networks) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !trusted.Contains(peer) {
+		return ""
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			return ""
+		}
+		if !trusted.Contains(ip) {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+//knownMethods - methods used as metric labels. Others are counted as OTHER to keep labels bounded
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+//metricsKey - context key marking the request as already observed by Metrics
+type metricsKey struct{}
+
+//Metrics - middleware for counting requests and their latency by route pattern and status code. Unmatched requests are counted with route "unknown".
+//chi wraps not found and method not allowed handlers with router middlewares once more, such requests are observed only by the outer call
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(metricsKey{}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tStart := time.Now()
+		ww := chiMiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), metricsKey{}, true)))
+		route := routePattern(r)
+		method := r.Method
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		code := strconv.Itoa(status)
+		metrics.HTTPRequests.WithLabelValues(method, route, code).Inc()
+		metrics.HTTPDuration.WithLabelValues(method, route, code).Observe(time.Since(tStart).Seconds())
+	})
+}
+
+//routePattern - chi route pattern of served request. Unmatched requests have only pattern of the mount point, they are reported as "unknown"
+func routePattern(r *http.Request) string {
+	route := ""
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		route = rctx.RoutePattern()
+	}
+	if route == "" || route == "/*" {
+		return "unknown"
+	}
+	return route
+}
+
+//tracingKey - context key marking the request as already traced by Tracing
+type tracingKey struct{}
+
+//Tracing - middleware for starting server span of every request. Trace context of the caller is taken from W3C headers.
+//Span is named by route pattern after the request is served. Like Metrics it skips the second call made by chi for unmatched requests
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(tracingKey{}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := tracing.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", r)...))
+		defer span.End()
+		ww := chiMiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(ctx, tracingKey{}, true)))
+		route := routePattern(r)
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", route, r)...)
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	})
+}
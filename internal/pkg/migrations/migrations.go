@@ -0,0 +1,316 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+//files - embedded SQL migrations. File name format is NNNN_name.up.sql or NNNN_name.down.sql
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+const (
+	lockKey      = 7041998 //Key of Postgres advisory lock for migrations
+	createTable  = `CREATE TABLE IF NOT EXISTS public.schema_migrations ("version" int4 NOT NULL PRIMARY KEY, "name" text NOT NULL, "applied_at" timestamptz NOT NULL)`
+	tableExists  = `SELECT to_regclass('public.schema_migrations') IS NOT NULL`
+	getApplied   = `SELECT "version", "applied_at" FROM public.schema_migrations ORDER BY "version"`
+	getVersion   = `SELECT COALESCE(MAX("version"), 0) FROM public.schema_migrations`
+	addApplied   = `INSERT INTO public.schema_migrations ("version", "name", "applied_at") VALUES ($1,$2,$3)`
+	delApplied   = `DELETE FROM public.schema_migrations WHERE "version" = $1`
+	lockMigrate  = `SELECT pg_advisory_lock($1)`
+	unlockMigrat = `SELECT pg_advisory_unlock($1)`
+)
+
+//ErrOutdated - error returned when database schema version is lower than the latest migration
+var ErrOutdated = errors.New("database schema is outdated")
+
+var sublog = log.With().Str("component", "migrations").Logger()
+
+//fileName - pattern of migration file name
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//Migration - one versioned schema change
+type Migration struct {
+	Version int    //Migration version. Versions are applied in ascending order
+	Name    string //Short migration description
+	Up      string //SQL applying the migration
+	Down    string //SQL reverting the migration
+}
+
+//Status - state of the migration in the database
+type Status struct {
+	Migration
+	Applied   bool      //Migration is applied
+	AppliedAt time.Time //Time of migration apply
+}
+
+//Migrator - applying embedded migrations to Postgres database
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+//New - creating new migrator for the database pool
+func New(pool *pgxpool.Pool) (*Migrator, error) {
+	m, err := load(files)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while loading migrations")
+		return nil, err
+	}
+	return &Migrator{pool: pool, migrations: m}, nil
+}
+
+//load - reading migrations from file system sorted by version
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, path := range entries {
+		name := path[len("sql/"):]
+		parts := fileName.FindStringSubmatch(name)
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		body, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d has different names %s and %s", version, m.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d must have both up and down files", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+//Latest - version of the latest known migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+//applied - checking that migrations table exists. Schema is not changed, so it is safe for probes and read-only users
+func (m *Migrator) applied(ctx context.Context) (bool, error) {
+	var exists bool
+	err := m.pool.QueryRow(ctx, tableExists).Scan(&exists)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return false, err
+	}
+	return exists, nil
+}
+
+//Version - current version of the database schema. Database without migrations table has version 0
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	exists, err := m.applied(ctx)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = m.pool.QueryRow(ctx, getVersion).Scan(&version)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return 0, err
+	}
+	return version, nil
+}
+
+//Check - checking that all migrations are applied
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version < m.Latest() {
+		sublog.Error().Msgf("Database schema version is %d. Latest version is %d", version, m.Latest())
+		return ErrOutdated
+	}
+	return nil
+}
+
+//Status - reading state of every known migration
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	exists, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time)
+	if exists {
+		applied, err = m.appliedAt(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		at, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: at})
+	}
+	return statuses, nil
+}
+
+//appliedAt - reading time of applying of every applied migration
+func (m *Migrator) appliedAt(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.pool.Query(ctx, getApplied)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+//Up - applying all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *pgxpool.Conn, version int) error {
+		for _, migration := range m.migrations {
+			if migration.Version <= version {
+				continue
+			}
+			sublog.Info().Msgf("Applying migration %d %s", migration.Version, migration.Name)
+			err := apply(ctx, conn, migration.Up, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, addApplied, migration.Version, migration.Name, time.Now())
+				return err
+			})
+			if err != nil {
+				sublog.Error().Err(err).Msgf("Error while applying migration %d", migration.Version)
+				return err
+			}
+		}
+		sublog.Info().Msgf("Database schema is up to date. Version is %d", m.Latest())
+		return nil
+	})
+}
+
+//Down - reverting the last applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(conn *pgxpool.Conn, version int) error {
+		if version == 0 {
+			sublog.Info().Msg("No migrations to revert")
+			return nil
+		}
+		migration := m.migrations[version-1]
+		sublog.Info().Msgf("Reverting migration %d %s", migration.Version, migration.Name)
+		err := apply(ctx, conn, migration.Down, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, delApplied, migration.Version)
+			return err
+		})
+		if err != nil {
+			sublog.Error().Err(err).Msgf("Error while reverting migration %d", migration.Version)
+			return err
+		}
+		return nil
+	})
+}
+
+//locked - running f under advisory lock, so only one instance changes the schema at a time
+func (m *Migrator) locked(ctx context.Context, f func(conn *pgxpool.Conn, version int) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while acquiring connection")
+		return err
+	}
+	defer conn.Release()
+	sublog.Debug().Msg("Waiting for migrations lock")
+	_, err = conn.Exec(ctx, lockMigrate, lockKey)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while taking migrations lock")
+		return err
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), unlockMigrat, lockKey)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while releasing migrations lock")
+		}
+	}()
+	_, err = conn.Exec(ctx, createTable)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	var version int
+	err = conn.QueryRow(ctx, getVersion).Scan(&version)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("database schema version %d is newer than latest known migration %d", version, m.Latest())
+	}
+	return f(conn, version)
+}
+
+//apply - executing migration SQL and version bookkeeping in one transaction
+func apply(ctx context.Context, conn *pgxpool.Conn, sql string, bookkeeping func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql)
+	if err == nil {
+		err = bookkeeping(tx)
+	}
+	if err != nil {
+		rerr := tx.Rollback(ctx)
+		if rerr != nil {
+			sublog.Error().Err(rerr).Msg("Error in rollback transaction")
+		}
+		return err
+	}
+	return tx.Commit(ctx)
+}
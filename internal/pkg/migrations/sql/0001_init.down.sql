@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.withdraws;
+DROP TABLE IF EXISTS public.orders;
+DROP TABLE IF EXISTS public.balance;
+DROP TABLE IF EXISTS public.users;
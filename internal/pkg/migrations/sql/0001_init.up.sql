@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS public.users (
+	id int4 NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"name" text NOT NULL UNIQUE,
+	"password" text NOT NULL,
+	"random_iv" text NOT NULL,
+	CONSTRAINT users_id_pk PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS public.balance (
+	id int4 NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"name" text NOT NULL UNIQUE,
+	"balance" float8 NOT NULL DEFAULT 0,
+	"withdraw" float8 NOT NULL DEFAULT 0,
+	CONSTRAINT balance_fk FOREIGN KEY (name) REFERENCES public.users("name"),
+	CONSTRAINT balance_id_pk PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS public.orders (
+	id int4 NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"order" text NOT NULL,
+	"name" text NOT NULL,
+	"status" text NOT NULL DEFAULT 'NEW',
+	"uploaded_at" timestamptz NOT NULL,
+	"accrual" float8 NOT NULL DEFAULT 0,
+	CONSTRAINT orders_fk FOREIGN KEY (name) REFERENCES public.users("name"),
+	CONSTRAINT orders_id_pk PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS public.withdraws (
+	id int4 NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"name" text NOT NULL,
+	"order" text NOT NULL,
+	"processed_at"  timestamptz,
+	"withdraw" float8 NOT NULL DEFAULT 0,
+	CONSTRAINT withdraws_fk FOREIGN KEY (name) REFERENCES public.users("name")
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS orders_order_user_idx ON public.orders ("order","name");
+CREATE UNIQUE INDEX IF NOT EXISTS orders_order_idx ON public.orders ("order");
+CREATE UNIQUE INDEX IF NOT EXISTS withdraws_user_order_idx ON public.withdraws ("order", "name");
+CREATE UNIQUE INDEX IF NOT EXISTS withdraws_order_idx ON public.withdraws ("order");
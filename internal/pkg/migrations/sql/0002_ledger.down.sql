@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.ledger;
+DROP FUNCTION IF EXISTS public.ledger_append_only();
+DROP SEQUENCE IF EXISTS public.ledger_transaction_seq;
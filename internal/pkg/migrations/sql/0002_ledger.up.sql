@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS public.ledger (
+	id int8 NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"transaction" int8 NOT NULL,
+	"account" text NOT NULL,
+	"name" text,
+	"kind" text NOT NULL,
+	"order" text NOT NULL DEFAULT '',
+	"amount" float8 NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	CONSTRAINT ledger_fk FOREIGN KEY (name) REFERENCES public.users("name"),
+	CONSTRAINT ledger_id_pk PRIMARY KEY (id)
+);
+CREATE SEQUENCE IF NOT EXISTS public.ledger_transaction_seq;
+CREATE INDEX IF NOT EXISTS ledger_account_name_idx ON public.ledger ("account", "name", "id");
+
+CREATE OR REPLACE FUNCTION public.ledger_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger entries can not be changed';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS ledger_append_only ON public.ledger;
+CREATE TRIGGER ledger_append_only BEFORE UPDATE ON public.ledger FOR EACH ROW EXECUTE FUNCTION public.ledger_append_only();
+
+-- Balances created before the ledger are moved into opening entries
+DO $$
+DECLARE
+	b record;
+	t int8;
+BEGIN
+	FOR b IN SELECT s."name", s."balance", s."withdraw" FROM public.balance s
+		WHERE NOT EXISTS (SELECT 1 FROM public.ledger l WHERE l."name" = s."name") AND (s."balance" <> 0 OR s."withdraw" <> 0)
+	LOOP
+		t := nextval('public.ledger_transaction_seq');
+		INSERT INTO public.ledger ("transaction", "account", "name", "kind", "order", "amount", "created_at") VALUES
+			(t, 'points', b."name", 'OPENING', '', b."balance" + b."withdraw", now()),
+			(t, 'opening', NULL, 'OPENING', '', -(b."balance" + b."withdraw"), now());
+		IF b."withdraw" > 0 THEN
+			t := nextval('public.ledger_transaction_seq');
+			INSERT INTO public.ledger ("transaction", "account", "name", "kind", "order", "amount", "created_at") VALUES
+				(t, 'points', b."name", 'WITHDRAWAL', '', -b."withdraw", now()),
+				(t, 'withdrawals', NULL, 'WITHDRAWAL', '', b."withdraw", now());
+		END IF;
+	END LOOP;
+END
+$$;
@@ -0,0 +1,4 @@
+ALTER TABLE public.balance ALTER COLUMN "balance" TYPE float8, ALTER COLUMN "withdraw" TYPE float8;
+ALTER TABLE public.orders ALTER COLUMN "accrual" TYPE float8;
+ALTER TABLE public.withdraws ALTER COLUMN "withdraw" TYPE float8;
+ALTER TABLE public.ledger ALTER COLUMN "amount" TYPE float8;
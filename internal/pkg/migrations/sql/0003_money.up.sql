@@ -0,0 +1,4 @@
+ALTER TABLE public.balance ALTER COLUMN "balance" TYPE numeric(20,2), ALTER COLUMN "withdraw" TYPE numeric(20,2);
+ALTER TABLE public.orders ALTER COLUMN "accrual" TYPE numeric(20,2);
+ALTER TABLE public.withdraws ALTER COLUMN "withdraw" TYPE numeric(20,2);
+ALTER TABLE public.ledger ALTER COLUMN "amount" TYPE numeric(20,2);
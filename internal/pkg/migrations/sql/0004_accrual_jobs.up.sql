@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS public.accrual_jobs (
+	"order" text NOT NULL,
+	"name" text NOT NULL,
+	"attempts" int4 NOT NULL DEFAULT 0,
+	"run_at" timestamptz NOT NULL,
+	"locked_by" text,
+	"locked_until" timestamptz,
+	CONSTRAINT accrual_jobs_order_pk PRIMARY KEY ("order"),
+	CONSTRAINT accrual_jobs_fk FOREIGN KEY ("order") REFERENCES public.orders("order") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS accrual_jobs_run_at_idx ON public.accrual_jobs ("run_at");
+
+-- Orders left unfinished before the queue are moved into the queue
+INSERT INTO public.accrual_jobs ("order", "name", "run_at")
+	SELECT o."order", o."name", now() FROM public.orders o WHERE o."status" NOT IN ('PROCESSED', 'INVALID')
+	ON CONFLICT DO NOTHING;
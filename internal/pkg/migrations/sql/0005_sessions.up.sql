@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public.sessions (
+	"id" text NOT NULL,
+	"name" text NOT NULL,
+	"device" text NOT NULL DEFAULT '',
+	"ip" text NOT NULL DEFAULT '',
+	"created_at" timestamptz NOT NULL,
+	"last_seen_at" timestamptz NOT NULL,
+	"expires_at" timestamptz NOT NULL,
+	CONSTRAINT sessions_id_pk PRIMARY KEY ("id"),
+	CONSTRAINT sessions_fk FOREIGN KEY ("name") REFERENCES public.users("name") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS sessions_name_idx ON public.sessions ("name");
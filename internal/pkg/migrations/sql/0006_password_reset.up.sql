@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS public.reset_tokens (
+	"hash" text NOT NULL,
+	"name" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"expires_at" timestamptz NOT NULL,
+	"used_at" timestamptz,
+	CONSTRAINT reset_tokens_hash_pk PRIMARY KEY ("hash"),
+	CONSTRAINT reset_tokens_fk FOREIGN KEY ("name") REFERENCES public.users("name") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS reset_tokens_name_idx ON public.reset_tokens ("name");
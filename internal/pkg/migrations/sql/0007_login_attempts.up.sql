@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS public.login_attempts (
+	"scope" text NOT NULL,
+	"key" text NOT NULL,
+	"failures" integer NOT NULL DEFAULT 0,
+	"last_failure_at" timestamptz NOT NULL,
+	"locked_until" timestamptz,
+	CONSTRAINT login_attempts_pk PRIMARY KEY ("scope", "key")
+);
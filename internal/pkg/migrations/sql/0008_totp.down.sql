@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.recovery_codes;
+ALTER TABLE public.sessions DROP COLUMN IF EXISTS "verified_at";
+ALTER TABLE public.users DROP COLUMN IF EXISTS "totp_step";
+ALTER TABLE public.users DROP COLUMN IF EXISTS "totp_enabled";
+ALTER TABLE public.users DROP COLUMN IF EXISTS "totp_secret";
@@ -0,0 +1,11 @@
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS "totp_secret" text NOT NULL DEFAULT '';
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS "totp_enabled" boolean NOT NULL DEFAULT false;
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS "totp_step" bigint NOT NULL DEFAULT 0;
+ALTER TABLE public.sessions ADD COLUMN IF NOT EXISTS "verified_at" timestamptz;
+CREATE TABLE IF NOT EXISTS public.recovery_codes (
+	"name" text NOT NULL,
+	"hash" text NOT NULL,
+	"used_at" timestamptz,
+	CONSTRAINT recovery_codes_pk PRIMARY KEY ("name", "hash"),
+	CONSTRAINT recovery_codes_fk FOREIGN KEY ("name") REFERENCES public.users("name") ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.audit_events;
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE public.users DROP COLUMN IF EXISTS "blocked";
+ALTER TABLE public.users DROP COLUMN IF EXISTS "role";
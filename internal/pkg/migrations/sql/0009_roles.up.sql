@@ -0,0 +1,14 @@
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS "role" text NOT NULL DEFAULT 'user';
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS "blocked" boolean NOT NULL DEFAULT false;
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE public.users ADD CONSTRAINT users_role_check CHECK ("role" IN ('user', 'support', 'admin'));
+CREATE TABLE IF NOT EXISTS public.audit_events (
+	"id" bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"actor" text NOT NULL,
+	"action" text NOT NULL,
+	"target" text NOT NULL DEFAULT '',
+	"reason" text NOT NULL DEFAULT '',
+	"created_at" timestamptz NOT NULL,
+	CONSTRAINT audit_events_id_pk PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON public.audit_events ("target", "created_at");
@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS audit_events_append_only ON public.audit_events;
+DROP FUNCTION IF EXISTS public.audit_events_append_only();
+DROP INDEX IF EXISTS public.audit_events_created_idx;
+DROP INDEX IF EXISTS public.audit_events_action_idx;
+DROP INDEX IF EXISTS public.audit_events_actor_idx;
+ALTER TABLE public.audit_events DROP COLUMN IF EXISTS "after";
+ALTER TABLE public.audit_events DROP COLUMN IF EXISTS "before";
+ALTER TABLE public.audit_events DROP COLUMN IF EXISTS "request_id";
+ALTER TABLE public.audit_events DROP COLUMN IF EXISTS "ip";
@@ -0,0 +1,14 @@
+ALTER TABLE public.audit_events ADD COLUMN IF NOT EXISTS "ip" text NOT NULL DEFAULT '';
+ALTER TABLE public.audit_events ADD COLUMN IF NOT EXISTS "request_id" text NOT NULL DEFAULT '';
+ALTER TABLE public.audit_events ADD COLUMN IF NOT EXISTS "before" jsonb;
+ALTER TABLE public.audit_events ADD COLUMN IF NOT EXISTS "after" jsonb;
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON public.audit_events ("actor", "created_at");
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON public.audit_events ("action", "created_at");
+CREATE INDEX IF NOT EXISTS audit_events_created_idx ON public.audit_events ("created_at");
+CREATE OR REPLACE FUNCTION public.audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_events_append_only ON public.audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON public.audit_events FOR EACH ROW EXECUTE FUNCTION public.audit_events_append_only();
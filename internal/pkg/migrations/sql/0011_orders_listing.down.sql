@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.orders_name_uploaded_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS orders_name_uploaded_idx ON public.orders ("name", "uploaded_at", "order");
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public.order_history (
+	"id" bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"order" text NOT NULL,
+	"status" text NOT NULL,
+	"accrual" numeric(20,2) NOT NULL DEFAULT 0,
+	"changed_at" timestamptz NOT NULL,
+	CONSTRAINT order_history_id_pk PRIMARY KEY ("id"),
+	CONSTRAINT order_history_fk FOREIGN KEY ("order") REFERENCES public.orders("order") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS order_history_order_idx ON public.order_history ("order", "id");
+INSERT INTO public.order_history ("order", "status", "changed_at") SELECT "order", 'NEW', "uploaded_at" FROM public.orders;
+INSERT INTO public.order_history ("order", "status", "accrual", "changed_at") SELECT "order", "status", "accrual", now() FROM public.orders WHERE "status" <> 'NEW';
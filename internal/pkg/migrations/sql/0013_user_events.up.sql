@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS public.user_events (
+	"id" bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"name" text NOT NULL,
+	"type" text NOT NULL,
+	"data" jsonb NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	CONSTRAINT user_events_id_pk PRIMARY KEY ("id"),
+	CONSTRAINT user_events_fk FOREIGN KEY ("name") REFERENCES public.users("name") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS user_events_name_idx ON public.user_events ("name", "id");
+CREATE INDEX IF NOT EXISTS user_events_created_idx ON public.user_events ("created_at");
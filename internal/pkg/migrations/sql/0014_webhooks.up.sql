@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS public.webhooks (
+	"id" bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"owner" text,
+	"url" text NOT NULL,
+	"secret" text NOT NULL,
+	"events" text[] NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	CONSTRAINT webhooks_id_pk PRIMARY KEY ("id"),
+	CONSTRAINT webhooks_owner_fk FOREIGN KEY ("owner") REFERENCES public.users("name") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS webhooks_owner_idx ON public.webhooks ("owner");
+CREATE TABLE IF NOT EXISTS public.webhook_deliveries (
+	"id" bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+	"webhook_id" bigint NOT NULL,
+	"name" text NOT NULL,
+	"event" text NOT NULL,
+	"payload" jsonb NOT NULL,
+	"status" text NOT NULL DEFAULT 'PENDING',
+	"attempts" integer NOT NULL DEFAULT 0,
+	"next_attempt_at" timestamptz NOT NULL,
+	"last_error" text NOT NULL DEFAULT '',
+	"created_at" timestamptz NOT NULL,
+	CONSTRAINT webhook_deliveries_id_pk PRIMARY KEY ("id"),
+	CONSTRAINT webhook_deliveries_fk FOREIGN KEY ("webhook_id") REFERENCES public.webhooks("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON public.webhook_deliveries ("next_attempt_at") WHERE "status" = 'PENDING';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON public.webhook_deliveries ("webhook_id", "id");
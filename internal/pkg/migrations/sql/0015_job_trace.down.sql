@@ -0,0 +1 @@
+ALTER TABLE public.accrual_jobs DROP COLUMN IF EXISTS "trace";
@@ -0,0 +1,2 @@
+-- W3C traceparent of the request created the job. Accrual polling spans are linked to it
+ALTER TABLE public.accrual_jobs ADD COLUMN IF NOT EXISTS "trace" text;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.user_events_name_seq_idx;
+CREATE INDEX IF NOT EXISTS user_events_name_idx ON public.user_events ("name", "id");
+ALTER TABLE public.user_events DROP COLUMN IF EXISTS "seq";
+ALTER TABLE public.balance DROP COLUMN IF EXISTS "event_seq";
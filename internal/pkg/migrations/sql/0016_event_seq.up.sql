@@ -0,0 +1,9 @@
+-- Per-user sequence of events. It is assigned under the balance row lock, so events of one user are committed in sequence order.
+-- Existing events keep their identifiers as sequence numbers, so clients resume streams by the same Last-Event-ID
+ALTER TABLE public.balance ADD COLUMN IF NOT EXISTS "event_seq" bigint NOT NULL DEFAULT 0;
+ALTER TABLE public.user_events ADD COLUMN IF NOT EXISTS "seq" bigint;
+UPDATE public.user_events SET "seq" = "id" WHERE "seq" IS NULL;
+UPDATE public.balance b SET "event_seq" = e."seq" FROM (SELECT "name", max("seq") AS "seq" FROM public.user_events GROUP BY "name") e WHERE e."name" = b."name";
+ALTER TABLE public.user_events ALTER COLUMN "seq" SET NOT NULL;
+DROP INDEX IF EXISTS public.user_events_name_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS user_events_name_seq_idx ON public.user_events ("name", "seq");
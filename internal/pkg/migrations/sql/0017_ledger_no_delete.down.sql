@@ -0,0 +1,7 @@
+CREATE OR REPLACE FUNCTION public.ledger_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger entries can not be changed';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS ledger_append_only ON public.ledger;
+CREATE TRIGGER ledger_append_only BEFORE UPDATE ON public.ledger FOR EACH ROW EXECUTE FUNCTION public.ledger_append_only();
@@ -0,0 +1,8 @@
+-- Ledger entries can be neither changed nor removed. Corrections are posted as new entries
+CREATE OR REPLACE FUNCTION public.ledger_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS ledger_append_only ON public.ledger;
+CREATE TRIGGER ledger_append_only BEFORE UPDATE OR DELETE ON public.ledger FOR EACH ROW EXECUTE FUNCTION public.ledger_append_only();
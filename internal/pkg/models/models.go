@@ -10,25 +10,38 @@ type User struct {
 	Name     string `json:"login"`    //Unique user name
 	Password string `json:"password"` //Hashed password
 	Random   string `json:"-"`        //Random IV
+
+	TOTPSecret  string `json:"-"` //Base32 TOTP secret. Empty if 2FA is not enrolled
+	TOTPEnabled bool   `json:"-"` //2FA enrolment is confirmed and second login step is required
+
+	Role    string `json:"-"` //User's role. Allowed values are "user", "support" and "admin"
+	Blocked bool   `json:"-"` //Login of the user is refused
 }
 
+//User roles
+const (
+	RoleUser    = "user"    //End user
+	RoleSupport = "support" //Support operator. Can look up users and re-queue orders
+	RoleAdmin   = "admin"   //Administrator. Can block users and adjust balances
+)
+
 //Balance - struct for handling balance
 type Balance struct {
-	Balance   float32 `json:"current"`   //Accrual balace
-	Withdraws float32 `json:"withdrawn"` //Sum of withdrawns
+	Balance   Money `json:"current"`   //Accrual balace
+	Withdraws Money `json:"withdrawn"` //Sum of withdrawns
 }
 
 type Accrual struct {
-	Order  string  `json:"order"`             //Order number.
-	Status string  `json:"status"`            //Order status. Allowed values are "REGISTERED", "INVALID", "PROCESSING", "PROCESSED". Status "INVALID" or "PROCESSED" are final.
-	Value  float32 `json:"accrual,omitempty"` //Calculated accrual value.
+	Order  string `json:"order"`             //Order number.
+	Status string `json:"status"`            //Order status. Allowed values are "REGISTERED", "INVALID", "PROCESSING", "PROCESSED". Status "INVALID" or "PROCESSED" are final.
+	Value  Money  `json:"accrual,omitempty"` //Calculated accrual value.
 }
 
 //Order - struct for handling orders
 type Order struct {
 	Number  string    `json:"number"`      //Unique order number
 	Status  string    `json:"status"`      //Order status. Availible states: NEW, PROCESSING, INVALID, PROCESSED
-	AccRual float32   `json:"accrual"`     //Calculated bonus value
+	AccRual Money     `json:"accrual"`     //Calculated bonus value
 	Upload  time.Time `json:"uploaded_at"` //Order time. Time in format RFC3339
 }
 
@@ -44,11 +57,104 @@ func (o *Order) MarshalJSON() ([]byte, error) {
 	})
 }
 
+//OrderStatus - change of order status in order timeline
+type OrderStatus struct {
+	Status  string    `json:"status"`            //New order status
+	AccRual Money     `json:"accrual,omitempty"` //Accrual value set with the status
+	Changed time.Time `json:"changed_at"`        //Time of the change. Time in format RFC3339
+}
+
+//MarshalJSON - marshaling time.Time to time string in RFC3339 format
+func (o *OrderStatus) MarshalJSON() ([]byte, error) {
+	type Alias OrderStatus
+	return json.Marshal(&struct {
+		*Alias
+		Changed string `json:"changed_at"`
+	}{
+		Alias:   (*Alias)(o),
+		Changed: o.Changed.Format(time.RFC3339),
+	})
+}
+
+//User event types
+const (
+	EventOrder   = "order"   //Order status changed
+	EventBalance = "balance" //User's points moved
+)
+
+//UserEvent - notification of the change of user's data delivered to the user's event stream
+type UserEvent struct {
+	ID      int64           `json:"id"`         //Number of the event in user's sequence. Events of the user are committed in order of it
+	User    string          `json:"user"`       //Owner of the changed data
+	Type    string          `json:"type"`       //Event type
+	Data    json.RawMessage `json:"data"`       //Changed data
+	Created time.Time       `json:"created_at"` //Time of the change
+}
+
+//Webhook event types
+const (
+	WebhookOrderProcessed = "order.processed"   //Order accrual calculated
+	WebhookOrderInvalid   = "order.invalid"     //Accrual system refused to calculate order
+	WebhookWithdrawal     = "balance.withdrawn" //Points withdrawn for order
+)
+
+//WebhookEvents - all event types available for subscription
+var WebhookEvents = []string{WebhookOrderProcessed, WebhookOrderInvalid, WebhookWithdrawal}
+
+//Webhook delivery statuses
+const (
+	DeliveryPending   = "PENDING"   //Delivery is waiting for the next attempt
+	DeliveryDelivered = "DELIVERED" //Receiver answered 2xx
+	DeliveryDead      = "DEAD"      //All attempts failed. Delivery is kept until redelivered manually
+)
+
+//Webhook - subscription of partner system to loyalty events
+type Webhook struct {
+	ID      int64     `json:"id"`               //Subscription identifier
+	Owner   string    `json:"owner,omitempty"`  //User whose events are delivered. Empty for events of all users
+	URL     string    `json:"url"`              //Receiver address
+	Secret  string    `json:"secret,omitempty"` //Key of payload signature. Shown only on creation
+	Events  []string  `json:"events"`           //Subscribed event types
+	Created time.Time `json:"created_at"`       //Time of subscription
+}
+
+//WebhookDelivery - event waiting for delivery or delivered to the subscription
+type WebhookDelivery struct {
+	ID        int64           `json:"id"`                   //Delivery identifier. Receivers use it to skip duplicates
+	Webhook   int64           `json:"webhook_id"`           //Subscription identifier
+	User      string          `json:"user"`                 //Owner of the changed data
+	Event     string          `json:"event"`                //Event type
+	Payload   json.RawMessage `json:"payload"`              //Event data
+	Status    string          `json:"status"`               //Delivery status
+	Attempts  int             `json:"attempts"`             //Count of made attempts
+	Next      time.Time       `json:"next_attempt_at"`      //Time of the next attempt
+	LastError string          `json:"last_error,omitempty"` //Reason of the last failed attempt
+	Created   time.Time       `json:"created_at"`           //Time of the event
+	URL       string          `json:"-"`                    //Receiver address. Filled for claimed deliveries
+	Secret    string          `json:"-"`                    //Key of payload signature. Filled for claimed deliveries
+}
+
+//OrderCursor - position of the order in listing. Orders are ordered by upload time and number
+type OrderCursor struct {
+	Upload time.Time //Upload time of the last order of the previous page
+	Number string    //Number of the last order of the previous page
+}
+
+//OrderQuery - conditions of user's orders page. Empty fields are not checked
+type OrderQuery struct {
+	Statuses []string     //Allowed order statuses
+	From     time.Time    //Orders uploaded at or after the time
+	To       time.Time    //Orders uploaded before the time
+	Desc     bool         //Newest orders first. Oldest first by default
+	After    *OrderCursor //Page starts after the position. Nil for the first page
+	Limit    int          //Maximum number of orders. Zero means no limit
+}
+
 //Withdrawn - struct for handling withdrawns
 type Withdraw struct {
 	Number    string    `json:"number"`       //Order number
 	Processed time.Time `json:"processed_at"` //Order processing time. Time in format RFC3339
-	Withdraw  float32   `json:"sum"`          //Witdrawn sum
+	Withdraw  Money     `json:"sum"`          //Witdrawn sum
 }
 
 //MarshalJSON - marshaling time.Time to time string in RFC3339 format
@@ -62,3 +168,179 @@ func (w *Withdraw) MarshalJSON() ([]byte, error) {
 		Processed: w.Processed.Format(time.RFC3339),
 	})
 }
+
+//LedgerEntry - struct for handling movements of user's points
+type LedgerEntry struct {
+	Kind    string    `json:"type"`            //Movement type. Availible types: ACCRUAL, WITHDRAWAL, ADJUSTMENT, OPENING
+	Order   string    `json:"order,omitempty"` //Order number the movement is tied to
+	Amount  Money     `json:"amount"`          //Signed movement sum. Positive for credits and negative for debits
+	Balance Money     `json:"balance"`         //Running balance after the movement
+	Created time.Time `json:"processed_at"`    //Movement time. Time in format RFC3339
+}
+
+//MarshalJSON - marshaling time.Time to time string in RFC3339 format
+func (l *LedgerEntry) MarshalJSON() ([]byte, error) {
+	type Alias LedgerEntry
+	return json.Marshal(&struct {
+		*Alias
+		Created string `json:"processed_at"`
+	}{
+		Alias:   (*Alias)(l),
+		Created: l.Created.Format(time.RFC3339),
+	})
+}
+
+//Job - claimed order waiting for accrual calculation
+type Job struct {
+	Order    string //Order number
+	User     string //Order owner
+	Attempts int    //Count of claims including the current one
+	Trace    string //W3C traceparent of the request created the job. Empty if the request was not traced
+}
+
+//Session - user's login session
+type Session struct {
+	ID       string    `json:"id"`           //Session identifier
+	User     string    `json:"-"`            //Session owner
+	Device   string    `json:"device"`       //User agent of the client opened the session
+	IP       string    `json:"ip"`           //Client address the session was opened or last seen from
+	Created  time.Time `json:"created_at"`   //Login time. Time in format RFC3339
+	LastSeen time.Time `json:"last_seen_at"` //Time of the last request. Time in format RFC3339
+	Expires  time.Time `json:"expires_at"`   //Absolute session expiration time. Time in format RFC3339
+	Current  bool      `json:"current"`      //Session is used by the request
+	Verified time.Time `json:"-"`            //Time of the last 2FA check in the session. Zero if there was no check
+}
+
+//MarshalJSON - marshaling time.Time to time string in RFC3339 format
+func (s *Session) MarshalJSON() ([]byte, error) {
+	type Alias Session
+	return json.Marshal(&struct {
+		*Alias
+		Created  string `json:"created_at"`
+		LastSeen string `json:"last_seen_at"`
+		Expires  string `json:"expires_at"`
+	}{
+		Alias:    (*Alias)(s),
+		Created:  s.Created.Format(time.RFC3339),
+		LastSeen: s.LastSeen.Format(time.RFC3339),
+		Expires:  s.Expires.Format(time.RFC3339),
+	})
+}
+
+//ResetToken - single-use password reset token. Only hash of the token is stored
+type ResetToken struct {
+	Hash    string    //SHA-256 hash of the token
+	User    string    //Token owner
+	Created time.Time //Issue time
+	Expires time.Time //Token is not accepted after this time
+}
+
+//Login throttle scopes
+const (
+	ThrottleUser  = "user"  //Failed logins of the account
+	ThrottleIP    = "ip"    //Failed logins from the client address
+	ThrottleReset = "reset" //Password reset requests of the account from the client address
+)
+
+//LoginThrottle - failed login attempts of the account or the client address
+type LoginThrottle struct {
+	Scope       string    //Throttle scope. Allowed values are "user", "ip" and "reset"
+	Key         string    //Login, client address or both of them for "reset" scope
+	Failures    int       //Count of failed attempts in a row
+	LastFailure time.Time //Time of the last failed attempt
+	LockedUntil time.Time //Login is refused until this time. Zero if not locked
+}
+
+const (
+	LoginFreeFailures = 3                //Count of failures in a row without delay before the next attempt
+	LoginMaxDelay     = 30 * time.Second //Upper bound of the delay between attempts
+)
+
+//LoginDelay - progressive delay before the next attempt after failures in a row. Delay doubles on every failure
+func LoginDelay(failures int) time.Duration {
+	if failures < LoginFreeFailures {
+		return 0
+	}
+	shift := failures - LoginFreeFailures
+	if shift > 5 {
+		return LoginMaxDelay
+	}
+	delay := time.Second << uint(shift)
+	if delay > LoginMaxDelay {
+		return LoginMaxDelay
+	}
+	return delay
+}
+
+//Wait - time until the next attempt is allowed by the throttle state
+func (t LoginThrottle) Wait(now time.Time) time.Duration {
+	wait := t.LastFailure.Add(LoginDelay(t.Failures)).Sub(now)
+	if locked := t.LockedUntil.Sub(now); locked > wait {
+		wait = locked
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+//AuditEvent - append-only record of the security or money relevant action
+type AuditEvent struct {
+	ID        int64           `json:"id"`               //Event identifier. Events are ordered by it
+	Actor     string          `json:"actor"`            //Login of the user or name of the subsystem performed the action
+	Action    string          `json:"action"`           //Action name like "user.block"
+	Target    string          `json:"target"`           //Object of the action like "user:<login>" or "order:<number>"
+	Reason    string          `json:"reason"`           //Reason given by the actor
+	IP        string          `json:"ip"`               //Client address. Empty for background actions
+	RequestID string          `json:"request_id"`       //Identifier of HTTP request. Empty for background actions
+	Before    json.RawMessage `json:"before,omitempty"` //State of the target before the action
+	After     json.RawMessage `json:"after,omitempty"`  //State of the target after the action
+	Created   time.Time       `json:"created_at"`       //Time of the action. Time in format RFC3339
+}
+
+//AuditFilter - conditions of audit events query. Empty fields are not checked
+type AuditFilter struct {
+	Actor     string    //Exact actor
+	Action    string    //Exact action
+	Target    string    //Exact target
+	IP        string    //Exact client address
+	RequestID string    //Exact request identifier
+	From      time.Time //Events created at or after the time
+	To        time.Time //Events created before the time
+	After     int64     //Events with identifier greater than the value. Used as cursor of the next page
+	Limit     int       //Maximum number of events. Zero means no limit
+}
+
+//MarshalJSON - marshaling time.Time to time string in RFC3339 format
+func (e *AuditEvent) MarshalJSON() ([]byte, error) {
+	type Alias AuditEvent
+	return json.Marshal(&struct {
+		*Alias
+		Created string `json:"created_at"`
+	}{
+		Alias:   (*Alias)(e),
+		Created: e.Created.Format(time.RFC3339),
+	})
+}
+
+//Health statuses
+const (
+	HealthUp       = "up"        //Instance or component works
+	HealthDown     = "down"      //Component does not answer or answers with error
+	HealthReady    = "ready"     //All components work. Instance accepts requests
+	HealthNotReady = "not_ready" //Some component does not work
+	HealthDraining = "draining"  //Instance is shutting down and must not get new requests
+)
+
+//ComponentHealth - result of the check of one dependency
+type ComponentHealth struct {
+	Status  string  `json:"status"`           //HealthUp or HealthDown
+	Latency float64 `json:"latency_ms"`       //Duration of the check in milliseconds
+	Detail  string  `json:"detail,omitempty"` //Reason of the failure or additional information
+}
+
+//Health - state of the instance with results of dependency checks
+type Health struct {
+	Status     string                     `json:"status"`               //State of the instance
+	Components map[string]ComponentHealth `json:"components,omitempty"` //Results of dependency checks by component name
+}
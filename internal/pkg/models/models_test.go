@@ -0,0 +1,55 @@
+package models
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: time.Second},
+		{failures: 4, want: 2 * time.Second},
+		{failures: 7, want: 16 * time.Second},
+		{failures: 8, want: LoginMaxDelay},
+		{failures: 100, want: LoginMaxDelay},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.failures), func(t *testing.T) {
+			require.Equal(t, tt.want, LoginDelay(tt.failures))
+		})
+	}
+}
+
+func TestLoginThrottle_Wait(t *testing.T) {
+	now := time.Now()
+	require.Equal(t, time.Duration(0), LoginThrottle{}.Wait(now))
+	require.Equal(t, time.Second, LoginThrottle{Failures: 3, LastFailure: now}.Wait(now))
+	require.Equal(t, time.Duration(0), LoginThrottle{Failures: 3, LastFailure: now.Add(-time.Minute)}.Wait(now))
+	require.Equal(t, time.Minute, LoginThrottle{Failures: 3, LastFailure: now, LockedUntil: now.Add(time.Minute)}.Wait(now))
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks("10.0.0.0/8, 127.0.0.1,::1")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.0/8,127.0.0.1/32,::1/128", networks.String())
+	require.True(t, networks.Contains(net.ParseIP("10.1.2.3")))
+	require.True(t, networks.Contains(net.ParseIP("127.0.0.1")))
+	require.True(t, networks.Contains(net.ParseIP("::1")))
+	require.False(t, networks.Contains(net.ParseIP("127.0.0.2")))
+	networks, err = ParseNetworks("")
+	require.NoError(t, err)
+	require.Empty(t, networks)
+	_, err = ParseNetworks("10.0.0.0/33")
+	require.Error(t, err)
+	_, err = ParseNetworks("proxy")
+	require.Error(t, err)
+}
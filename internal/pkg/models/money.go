@@ -0,0 +1,148 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//Money - exact amount of loyalty points with 2 decimal places. Stored as integer count of hundredths
+type Money int64
+
+//Point - one whole loyalty point
+const Point Money = 100
+
+//moneyDecimals - count of allowed decimal places
+const moneyDecimals = 2
+
+var (
+	ErrMoneyFormat    = errors.New("invalid money format")                 //Value is not a decimal number
+	ErrMoneyPrecision = errors.New("money value has more than 2 decimals") //Value has more precision than allowed
+	ErrMoneyRange     = errors.New("money value is out of range")          //Value does not fit into Money
+)
+
+//ParseMoney - parsing decimal string like "729.98" into Money without loss of precision
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+	units, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		units, fraction = s[:i], s[i+1:]
+		if fraction == "" {
+			return 0, ErrMoneyFormat
+		}
+	}
+	if units == "" || !isDigits(units) || !isDigits(fraction) {
+		return 0, ErrMoneyFormat
+	}
+	if len(fraction) > moneyDecimals {
+		if strings.Trim(fraction[moneyDecimals:], "0") != "" {
+			return 0, ErrMoneyPrecision
+		}
+		fraction = fraction[:moneyDecimals]
+	}
+	fraction += strings.Repeat("0", moneyDecimals-len(fraction))
+	v, err := strconv.ParseInt(units+fraction, 10, 64)
+	if err != nil {
+		return 0, ErrMoneyRange
+	}
+	if negative {
+		v = -v
+	}
+	return Money(v), nil
+}
+
+//isDigits - checking that string contains only decimal digits
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//String - formatting Money as decimal string without trailing zeros
+func (m Money) String() string {
+	v := uint64(m)
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		v = uint64(-m)
+	}
+	units := v / uint64(Point)
+	cents := v % uint64(Point)
+	s := fmt.Sprintf("%s%d", sign, units)
+	if cents != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%02d", cents), "0")
+	}
+	return s
+}
+
+//MarshalJSON - marshaling Money to JSON number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+//UnmarshalJSON - unmarshaling Money from JSON number or string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	v, err := ParseMoney(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+//Scan - reading Money from database value. Implements sql.Scanner
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case string:
+		return m.scanString(v)
+	case []byte:
+		return m.scanString(string(v))
+	case int64:
+		*m = Money(v) * Point
+		return nil
+	case float64:
+		*m = Money(math.Round(v * float64(Point)))
+		return nil
+	}
+	return fmt.Errorf("can not scan %T into Money", src)
+}
+
+//scanString - reading Money from numeric text. Database may return more decimals than Money holds
+func (m *Money) scanString(s string) error {
+	v, err := ParseMoney(s)
+	if errors.Is(err, ErrMoneyPrecision) {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return ferr
+		}
+		*m = Money(math.Round(f * float64(Point)))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+//Value - writing Money as numeric text. Implements driver.Valuer
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  Money
+		err   error
+	}{
+		{
+			name:  "Whole points",
+			value: "500",
+			want:  500 * Point,
+		},
+		{
+			name:  "Two decimals",
+			value: "729.98",
+			want:  72998,
+		},
+		{
+			name:  "One decimal",
+			value: "0.5",
+			want:  50,
+		},
+		{
+			name:  "Trailing zeros",
+			value: "10.500",
+			want:  1050,
+		},
+		{
+			name:  "Negative value",
+			value: "-1.05",
+			want:  -105,
+		},
+		{
+			name:  "Too many decimals",
+			value: "1.005",
+			err:   ErrMoneyPrecision,
+		},
+		{
+			name:  "Exponent",
+			value: "1e3",
+			err:   ErrMoneyFormat,
+		},
+		{
+			name:  "Empty fraction",
+			value: "1.",
+			err:   ErrMoneyFormat,
+		},
+		{
+			name:  "Not a number",
+			value: "abc",
+			err:   ErrMoneyFormat,
+		},
+		{
+			name:  "Overflow",
+			value: "92233720368547758.08",
+			err:   ErrMoneyRange,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.value)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	tests := []struct {
+		value Money
+		want  string
+	}{
+		{value: 0, want: "0"},
+		{value: 500 * Point, want: "500"},
+		{value: 72998, want: "729.98"},
+		{value: 50, want: "0.5"},
+		{value: -100, want: "-1"},
+		{value: -5, want: "-0.05"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.value.String())
+		})
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	type withdraw struct {
+		Sum Money `json:"sum"`
+	}
+	w := withdraw{}
+	require.NoError(t, json.Unmarshal([]byte(`{"sum": 729.98}`), &w))
+	require.Equal(t, Money(72998), w.Sum)
+	body, err := json.Marshal(w)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"sum": 729.98}`, string(body))
+	require.NoError(t, json.Unmarshal([]byte(`{"sum": "12.3"}`), &w))
+	require.Equal(t, Money(1230), w.Sum)
+	err = json.Unmarshal([]byte(`{"sum": 0.001}`), &w)
+	require.ErrorIs(t, err, ErrMoneyPrecision)
+}
+
+func TestMoney_Scan(t *testing.T) {
+	tests := []struct {
+		name string
+		src  interface{}
+		want Money
+	}{
+		{name: "Numeric text", src: "729.98", want: 72998},
+		{name: "Numeric bytes", src: []byte("0.50"), want: 50},
+		{name: "Integer", src: int64(5), want: 5 * Point},
+		{name: "Float", src: float64(729.98), want: 72998},
+		{name: "Null", src: nil, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Money
+			require.NoError(t, m.Scan(tt.src))
+			require.Equal(t, tt.want, m)
+		})
+	}
+	v, err := Money(72998).Value()
+	require.NoError(t, err)
+	require.Equal(t, "729.98", v)
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+//Networks - list of IP networks like trusted proxies
+type Networks []*net.IPNet
+
+//ParseNetworks - parsing comma separated list of CIDRs. Single address is taken as network of one host
+func ParseNetworks(s string) (Networks, error) {
+	var networks Networks
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+//Contains - checking that the address belongs to any of networks
+func (n Networks) Contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//String - comma separated list of CIDRs
+func (n Networks) String() string {
+	items := make([]string, 0, len(n))
+	for _, network := range n {
+		items = append(items, network.String())
+	}
+	return strings.Join(items, ",")
+}
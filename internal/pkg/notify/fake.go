@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//Fake - notifier keeping sent messages in memory
+type Fake struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+var _ Notifier = (*Fake)(nil)
+
+//NewFake - creating fake notifier without messages
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+//Notify - storing the message
+func (f *Fake) Notify(ctx context.Context, msg Message) error {
+	if msg.Sent.IsZero() {
+		msg.Sent = time.Now()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+//Messages - all messages sent to the recipient in sending order
+func (f *Fake) Messages(to string) []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	messages := make([]Message, 0)
+	for _, msg := range f.messages {
+		if msg.To == to {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
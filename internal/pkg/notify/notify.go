@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+)
+
+var sublog = log.With().Str("component", "notify").Logger()
+
+//Message - notification for the user
+type Message struct {
+	To      string    `json:"to"`      //Recipient's login
+	Subject string    `json:"subject"` //Short message title
+	Body    string    `json:"body"`    //Message text
+	Sent    time.Time `json:"sent"`    //Time of sending
+}
+
+//Notifier - delivering notifications to users
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error //Sending the message. Implementations fill Sent if it is zero
+}
+
+//New - creating notifier by configuration. Messages are written to NOTIFY_FILE if it is set, otherwise to the application log
+func New(cfg *config.Config) Notifier {
+	if cfg.NotifyFile != "" {
+		return NewFile(cfg.NotifyFile)
+	}
+	return NewLog()
+}
+
+//Log - notifier writing messages to the application log. Intended for offline use only: message body is logged as is
+type Log struct{}
+
+var _ Notifier = (*Log)(nil)
+
+//NewLog - creating log notifier
+func NewLog() *Log {
+	return &Log{}
+}
+
+//Notify - writing the message to the log
+func (l *Log) Notify(ctx context.Context, msg Message) error {
+	if msg.Sent.IsZero() {
+		msg.Sent = time.Now()
+	}
+	sublog.Info().Str("to", msg.To).Str("subject", msg.Subject).Msg(msg.Body)
+	return nil
+}
+
+//File - notifier appending messages to the file, one JSON object per line
+type File struct {
+	mu   sync.Mutex
+	path string
+}
+
+var _ Notifier = (*File)(nil)
+
+//NewFile - creating file notifier. File is created on the first message
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+//Notify - appending the message to the file
+func (f *File) Notify(ctx context.Context, msg Message) error {
+	if msg.Sent.IsZero() {
+		msg.Sent = time.Now()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while opening notification file %v", f.path)
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while writing notification file %v", f.path)
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while closing notification file %v", f.path)
+		return err
+	}
+	sublog.Debug().Msgf("Notification for %v written to %v", msg.To, f.path)
+	return nil
+}
@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_Notify(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	n := NewFile(path)
+	messages := []Message{
+		{To: "user1", Subject: "Password reset", Body: "token1"},
+		{To: "user2", Subject: "Password reset", Body: "token2"},
+	}
+	for _, msg := range messages {
+		require.NoError(t, n.Notify(context.Background(), msg))
+	}
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	got := make([]Message, 0)
+	for scanner.Scan() {
+		msg := Message{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &msg))
+		require.False(t, msg.Sent.IsZero())
+		msg.Sent = messages[0].Sent
+		got = append(got, msg)
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, messages, got)
+}
+
+func TestFile_NotifyError(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	n := NewFile(filepath.Join(t.TempDir(), "missing", "notifications.jsonl"))
+	require.Error(t, n.Notify(context.Background(), Message{To: "user1"}))
+}
+
+func TestLog_Notify(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	require.NoError(t, NewLog().Notify(context.Background(), Message{To: "user1", Body: "token1"}))
+}
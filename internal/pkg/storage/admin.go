@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
+)
+
+//Postgres
+
+const (
+	setRole          = `UPDATE public.users SET "role" = $1 WHERE "name" = $2`
+	setBlocked       = `UPDATE public.users SET "blocked" = $1 WHERE "name" = $2`
+	deleteAllSession = `DELETE FROM public.sessions WHERE "name" = $1`
+	lockOrder        = `SELECT "name", "status" FROM public.orders WHERE "order" = $1 FOR UPDATE`
+	resetOrder       = `UPDATE public.orders SET "status" = 'NEW', "accrual" = 0 WHERE "order" = $1`
+	requeueJob       = `INSERT INTO public.accrual_jobs ("order", "name", "run_at", "trace") VALUES ($1,$2,$3,$4) ON CONFLICT ("order") DO UPDATE SET "run_at" = $3, "attempts" = 0, "locked_by" = NULL, "locked_until" = NULL, "trace" = $4`
+)
+
+func (s *Database) SetRole(ctx context.Context, login, role string) (err error) {
+	ctx, done := s.operation(ctx, "SetRole", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Setting role %v to user %v", role, login)
+	tag, err := s.conn.Exec(ctx, setRole, role, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Database) SetBlocked(ctx context.Context, login string, blocked bool) (err error) {
+	ctx, done := s.operation(ctx, "SetBlocked", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Setting blocked %v to user %v", blocked, login)
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	tag, err := tx.Exec(ctx, setBlocked, blocked, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if blocked {
+		_, err = tx.Exec(ctx, deleteAllSession, login)
+		if err != nil {
+			sublog.Error().Err(err).Msg("")
+			return err
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
+	return nil
+}
+
+//RequeueOrder - resetting not processed order to NEW and scheduling accrual job immediately
+func (s *Database) RequeueOrder(ctx context.Context, order string) (err error) {
+	ctx, done := s.operation(ctx, "RequeueOrder", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Re-queueing order %v", order)
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	var login, status string
+	err = tx.QueryRow(ctx, lockOrder, order).Scan(&login, &status)
+	if err != nil {
+		sublog.Info().Err(err).Msgf("Order %v not found", order)
+		return err
+	}
+	if status == "PROCESSED" {
+		return ErrOrderCompleted
+	}
+	_, err = tx.Exec(ctx, resetOrder, order)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	err = recordStatus(ctx, tx, login, order, "NEW", 0, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, requeueJob, order, login, time.Now(), tracing.Parent(ctx))
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
+	return nil
+}
+
+//In-memory
+
+func (s *Memory) SetRole(ctx context.Context, login, role string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Setting role %v to user %v", role, login)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[login]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	user.Role = role
+	s.users[login] = user
+	return nil
+}
+
+func (s *Memory) SetBlocked(ctx context.Context, login string, blocked bool) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Setting blocked %v to user %v", blocked, login)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[login]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	user.Blocked = blocked
+	s.users[login] = user
+	if blocked {
+		for id, session := range s.sessions {
+			if session.User == login {
+				delete(s.sessions, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Memory) RequeueOrder(ctx context.Context, order string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Re-queueing order %v", order)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[order]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	if o.Status == "PROCESSED" {
+		return ErrOrderCompleted
+	}
+	o.Status = "NEW"
+	o.AccRual = 0
+	s.orders[order] = o
+	s.recordStatus(o.name, order, "NEW", 0, time.Now())
+	s.jobs[order] = memoryJob{
+		Job:   models.Job{Order: order, User: o.name, Trace: tracing.Parent(ctx)},
+		runAt: time.Now(),
+	}
+	return nil
+}
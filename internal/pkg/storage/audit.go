@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//AuditSystem - actor of audit events written by storage itself
+const AuditSystem = "system"
+
+//ledgerEvent - audit event of the movement of user's points. It is written in the same transaction as ledger entries
+func ledgerEvent(login, kind, order string, balance, delta models.Money, at time.Time) models.AuditEvent {
+	before, _ := json.Marshal(map[string]interface{}{"balance": balance})
+	after, _ := json.Marshal(map[string]interface{}{"balance": balance + delta, "delta": delta, "order": order})
+	return models.AuditEvent{
+		Actor:   AuditSystem,
+		Action:  "ledger." + strings.ToLower(kind),
+		Target:  "user:" + login,
+		Before:  before,
+		After:   after,
+		Created: at,
+	}
+}
+
+//Postgres
+
+const (
+	createAuditEvent = `INSERT INTO public.audit_events ("actor", "action", "target", "reason", "ip", "request_id", "before", "after", "created_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`
+	getAuditEvents   = `SELECT "id", "actor", "action", "target", "reason", "ip", "request_id", "before", "after", "created_at" FROM public.audit_events`
+)
+
+//auditArgs - arguments of createAuditEvent query. Empty before and after values are stored as NULL
+func auditArgs(e models.AuditEvent) []interface{} {
+	return []interface{}{e.Actor, e.Action, e.Target, e.Reason, e.IP, e.RequestID, []byte(e.Before), []byte(e.After), e.Created}
+}
+
+//auditQuery - building audit events query with conditions of the filter
+func auditQuery(filter models.AuditFilter) (string, []interface{}) {
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.Actor != "" {
+		add(`"actor" = $%d`, filter.Actor)
+	}
+	if filter.Action != "" {
+		add(`"action" = $%d`, filter.Action)
+	}
+	if filter.Target != "" {
+		add(`"target" = $%d`, filter.Target)
+	}
+	if filter.IP != "" {
+		add(`"ip" = $%d`, filter.IP)
+	}
+	if filter.RequestID != "" {
+		add(`"request_id" = $%d`, filter.RequestID)
+	}
+	if !filter.From.IsZero() {
+		add(`"created_at" >= $%d`, filter.From)
+	}
+	if !filter.To.IsZero() {
+		add(`"created_at" < $%d`, filter.To)
+	}
+	if filter.After > 0 {
+		add(`"id" > $%d`, filter.After)
+	}
+	query := getAuditEvents
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY "id"`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	return query, args
+}
+
+func (s *Database) CreateAuditEvent(ctx context.Context, event models.AuditEvent) (err error) {
+	ctx, done := s.operation(ctx, "CreateAuditEvent", s.timeouts.Write, &err)
+	defer done()
+	_, err = s.conn.Exec(ctx, createAuditEvent, auditArgs(event)...)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while writing audit event %v", event.Action)
+		return err
+	}
+	return nil
+}
+
+func (s *Database) GetAuditEvents(ctx context.Context, filter models.AuditFilter) (_ []models.AuditEvent, err error) {
+	ctx, done := s.operation(ctx, "GetAuditEvents", s.timeouts.Read, &err)
+	defer done()
+	query, args := auditQuery(filter)
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]models.AuditEvent, 0)
+	for rows.Next() {
+		e := models.AuditEvent{}
+		var before, after []byte
+		err = rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &e.Reason, &e.IP, &e.RequestID, &before, &after, &e.Created)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		e.Before, e.After = before, after
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+//In-memory
+
+//appendAudit - storing audit event with next identifier. Caller must hold the write lock
+func (s *Memory) appendAudit(event models.AuditEvent) {
+	event.ID = int64(len(s.audit) + 1)
+	s.audit = append(s.audit, event)
+}
+
+func (s *Memory) CreateAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appendAudit(event)
+	return nil
+}
+
+//auditMatch - checking the event against conditions of the filter
+func auditMatch(e models.AuditEvent, filter models.AuditFilter) bool {
+	switch {
+	case filter.Actor != "" && e.Actor != filter.Actor:
+		return false
+	case filter.Action != "" && e.Action != filter.Action:
+		return false
+	case filter.Target != "" && e.Target != filter.Target:
+		return false
+	case filter.IP != "" && e.IP != filter.IP:
+		return false
+	case filter.RequestID != "" && e.RequestID != filter.RequestID:
+		return false
+	case !filter.From.IsZero() && e.Created.Before(filter.From):
+		return false
+	case !filter.To.IsZero() && !e.Created.Before(filter.To):
+		return false
+	}
+	return e.ID > filter.After
+}
+
+func (s *Memory) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := make([]models.AuditEvent, 0)
+	for _, e := range s.audit {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+		if auditMatch(e, filter) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
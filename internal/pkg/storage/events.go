@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//EventsChannel - Postgres notification channel of user events
+const EventsChannel = "user_events"
+
+//orderEvent - user event of order status change
+func orderEvent(login, order, status string, accrual models.Money, at time.Time) models.UserEvent {
+	data, _ := json.Marshal(map[string]interface{}{"number": order, "status": status, "accrual": accrual})
+	return models.UserEvent{User: login, Type: models.EventOrder, Data: data, Created: at}
+}
+
+//balanceEvent - user event of points movement with balance after it
+func balanceEvent(login, kind, order string, balance, delta models.Money, at time.Time) models.UserEvent {
+	data, _ := json.Marshal(map[string]interface{}{"current": balance, "delta": delta, "kind": strings.ToLower(kind), "order": order})
+	return models.UserEvent{User: login, Type: models.EventBalance, Data: data, Created: at}
+}
+
+//Postgres
+
+const (
+	nextEventSeq    = `UPDATE public.balance SET "event_seq" = "event_seq" + 1 WHERE "name" = $1 RETURNING "event_seq"`
+	createUserEvent = `INSERT INTO public.user_events ("name", "seq", "type", "data", "created_at") VALUES ($1,$2,$3,$4,$5)`
+	notifyUserEvent = `SELECT pg_notify('` + EventsChannel + `', $1)`
+	getUserEvents   = `SELECT "seq", "type", "data", "created_at" FROM public.user_events WHERE "name" = $1 AND "seq" > $2 ORDER BY "seq" LIMIT $3`
+	pruneUserEvents = `DELETE FROM public.user_events WHERE "created_at" < $1`
+)
+
+//publishEvent - storing user event and notifying listeners of all instances inside the transaction. Notification is sent on commit.
+//Event gets the next number of user's sequence. Balance row stays locked until the transaction end, so events of one user are committed in order of their numbers
+func publishEvent(ctx context.Context, tx pgx.Tx, event models.UserEvent) error {
+	err := tx.QueryRow(ctx, nextEventSeq, event.User).Scan(&event.ID)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while numbering %v event of user %v", event.Type, event.User)
+		return err
+	}
+	_, err = tx.Exec(ctx, createUserEvent, event.User, event.ID, event.Type, []byte(event.Data), event.Created)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while storing %v event of user %v", event.Type, event.User)
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, notifyUserEvent, string(payload))
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while notifying %v event of user %v", event.Type, event.User)
+		return err
+	}
+	return nil
+}
+
+func (s *Database) GetUserEvents(ctx context.Context, login string, after int64, limit int) (_ []models.UserEvent, err error) {
+	ctx, done := s.operation(ctx, "GetUserEvents", s.timeouts.Read, &err)
+	defer done()
+	rows, err := s.conn.Query(ctx, getUserEvents, login, after, limit)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]models.UserEvent, 0)
+	for rows.Next() {
+		e := models.UserEvent{User: login}
+		var data []byte
+		err = rows.Scan(&e.ID, &e.Type, &data, &e.Created)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		e.Data = data
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+//ListenUserEvents - listening the notification channel on dedicated connection. Error is returned if the connection is lost
+func (s *Database) ListenUserEvents(ctx context.Context, deliver func(models.UserEvent)) error {
+	conn, err := s.conn.Acquire(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while acquiring listen connection")
+		return err
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), "UNLISTEN *")
+		conn.Release()
+	}()
+	_, err = conn.Exec(ctx, "LISTEN "+EventsChannel)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while subscribing to user events")
+		return err
+	}
+	sublog.Info().Msg("Listening user events")
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			sublog.Error().Err(err).Msg("Error while waiting for user events")
+			return err
+		}
+		event := models.UserEvent{}
+		err = json.Unmarshal([]byte(n.Payload), &event)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Invalid user event notification")
+			continue
+		}
+		deliver(event)
+	}
+}
+
+func (s *Database) PruneUserEvents(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, done := s.operation(ctx, "PruneUserEvents", s.timeouts.Write, &err)
+	defer done()
+	tag, err := s.conn.Exec(ctx, pruneUserEvents, before)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+//In-memory
+
+//publishEvent - storing user event and delivering it to listeners. Caller must hold the write lock
+func (s *Memory) publishEvent(event models.UserEvent) {
+	s.eventSeq[event.User]++
+	event.ID = s.eventSeq[event.User]
+	s.events = append(s.events, event)
+	for _, deliver := range s.listeners {
+		deliver(event)
+	}
+}
+
+func (s *Memory) GetUserEvents(ctx context.Context, login string, after int64, limit int) ([]models.UserEvent, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := make([]models.UserEvent, 0)
+	for _, e := range s.events {
+		if len(events) == limit {
+			break
+		}
+		if e.User == login && e.ID > after {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+//ListenUserEvents - delivering events published by this storage until ctx is done. Deliver is called under the storage lock and must not block
+func (s *Memory) ListenUserEvents(ctx context.Context, deliver func(models.UserEvent)) error {
+	s.mu.Lock()
+	s.listener++
+	id := s.listener
+	s.listeners[id] = deliver
+	s.mu.Unlock()
+	<-ctx.Done()
+	s.mu.Lock()
+	delete(s.listeners, id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Memory) PruneUserEvents(ctx context.Context, before time.Time) (int64, error) {
+	if err := alive(ctx); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := make([]models.UserEvent, 0, len(s.events))
+	for _, e := range s.events {
+		if !e.Created.Before(before) {
+			kept = append(kept, e)
+		}
+	}
+	removed := int64(len(s.events) - len(kept))
+	s.events = kept
+	return removed, nil
+}
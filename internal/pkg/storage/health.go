@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+)
+
+//Postgres
+
+//Ping - checking that the database answers on one of the pool connections
+func (s *Database) Ping(ctx context.Context) (err error) {
+	ctx, done := s.operation(ctx, "Ping", s.timeouts.Read, &err)
+	defer done()
+	err = s.conn.Ping(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Database ping failed")
+		return err
+	}
+	return nil
+}
+
+//SchemaVersion - requesting applied schema version and version of the latest migration known to the application
+func (s *Database) SchemaVersion(ctx context.Context) (_, _ int, err error) {
+	ctx, done := s.operation(ctx, "SchemaVersion", s.timeouts.Read, &err)
+	defer done()
+	version, err := s.migrator.Version(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return version, s.migrator.Latest(), nil
+}
+
+//In-memory
+
+//Ping - in-memory storage is always available
+func (s *Memory) Ping(ctx context.Context) error {
+	return alive(ctx)
+}
+
+//SchemaVersion - in-memory storage has no schema. Both versions are zero
+func (s *Memory) SchemaVersion(ctx context.Context) (int, int, error) {
+	if err := alive(ctx); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//Postgres
+
+const (
+	recordOrderStatus = `INSERT INTO public.order_history ("order", "status", "accrual", "changed_at") VALUES ($1,$2,$3,$4)`
+	getOrder          = `SELECT "order", "status", "accrual", "uploaded_at" FROM public.orders WHERE "order" = $1 AND "name" = $2`
+	getOrderHistory   = `SELECT "status", "accrual", "changed_at" FROM public.order_history WHERE "order" = $1 ORDER BY "id"`
+)
+
+//recordStatus - appending status change to the order timeline, notifying the owner and enqueueing webhooks of final status inside the transaction
+func recordStatus(ctx context.Context, tx pgx.Tx, login, order, status string, accrual models.Money, at time.Time) error {
+	_, err := tx.Exec(ctx, recordOrderStatus, order, status, accrual, at)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while recording status %v of order %v", status, order)
+		return err
+	}
+	if event := orderWebhook(status); event != "" {
+		err = enqueueWebhooks(ctx, tx, login, event, orderPayload(order, status, accrual, at), at)
+		if err != nil {
+			return err
+		}
+	}
+	return publishEvent(ctx, tx, orderEvent(login, order, status, accrual, at))
+}
+
+func (s *Database) GetOrder(ctx context.Context, login, order string) (_ models.Order, err error) {
+	ctx, done := s.operation(ctx, "GetOrder", s.timeouts.Read, &err)
+	defer done()
+	sublog.Info().Msgf("Requesting order %v of user %v", order, login)
+	o := models.Order{}
+	err = s.conn.QueryRow(ctx, getOrder, order, login).Scan(&o.Number, &o.Status, &o.AccRual, &o.Upload)
+	if err != nil {
+		sublog.Info().Err(err).Msgf("Order %v of user %v not found", order, login)
+		return o, err
+	}
+	return o, nil
+}
+
+func (s *Database) GetOrderHistory(ctx context.Context, order string) (_ []models.OrderStatus, err error) {
+	ctx, done := s.operation(ctx, "GetOrderHistory", s.timeouts.Read, &err)
+	defer done()
+	sublog.Info().Msgf("Requesting timeline of order %v", order)
+	rows, err := s.conn.Query(ctx, getOrderHistory, order)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	history := make([]models.OrderStatus, 0)
+	for rows.Next() {
+		change := models.OrderStatus{}
+		err = rows.Scan(&change.Status, &change.AccRual, &change.Changed)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+//In-memory
+
+//recordStatus - appending status change to the order timeline, notifying the owner and enqueueing webhooks of final status. Caller must hold the write lock
+func (s *Memory) recordStatus(login, order, status string, accrual models.Money, at time.Time) {
+	s.history[order] = append(s.history[order], models.OrderStatus{Status: status, AccRual: accrual, Changed: at})
+	if event := orderWebhook(status); event != "" {
+		s.enqueueWebhooks(login, event, orderPayload(order, status, accrual, at), at)
+	}
+	s.publishEvent(orderEvent(login, order, status, accrual, at))
+}
+
+func (s *Memory) GetOrder(ctx context.Context, login, order string) (models.Order, error) {
+	if err := alive(ctx); err != nil {
+		return models.Order{}, err
+	}
+	sublog.Info().Msgf("Requesting order %v of user %v", order, login)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.orders[order]
+	if !ok || o.name != login {
+		return models.Order{}, pgx.ErrNoRows
+	}
+	return o.Order, nil
+}
+
+func (s *Memory) GetOrderHistory(ctx context.Context, order string) ([]models.OrderStatus, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	sublog.Info().Msgf("Requesting timeline of order %v", order)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := make([]models.OrderStatus, len(s.history[order]))
+	copy(history, s.history[order])
+	return history, nil
+}
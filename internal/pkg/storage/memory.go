@@ -0,0 +1,492 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
+)
+
+//In-memory
+
+//memoryOrder - order with owner's name
+type memoryOrder struct {
+	models.Order
+	name string
+}
+
+//memoryWithdraw - withdraw with owner's name
+type memoryWithdraw struct {
+	models.Withdraw
+	name string
+}
+
+//memoryEntry - ledger entry with account
+type memoryEntry struct {
+	models.LedgerEntry
+	transaction int64
+	account     string
+	name        string
+}
+
+//memoryJob - accrual job with claim state
+type memoryJob struct {
+	models.Job
+	runAt       time.Time
+	lockedBy    string
+	lockedUntil time.Time
+}
+
+//Memory - in-memory storage. Behaves like Database, but keeps all data in process memory
+type Memory struct {
+	mu        sync.RWMutex
+	users     map[string]models.User
+	orders    map[string]memoryOrder
+	withdraws map[string]memoryWithdraw
+	ledger    []memoryEntry
+	jobs      map[string]memoryJob
+	sessions  map[string]models.Session
+	resets    map[string]memoryResetToken
+	throttles map[string]models.LoginThrottle
+	totpSteps map[string]int64
+	recovery  map[string]memoryRecoveryCode
+	audit     []models.AuditEvent
+	history   map[string][]models.OrderStatus
+	events    []models.UserEvent
+	eventSeq  map[string]int64
+	listeners map[int]func(models.UserEvent)
+	listener  int
+
+	webhooks     map[int64]models.Webhook
+	lastWebhook  int64
+	deliveries   map[int64]models.WebhookDelivery
+	lastDelivery int64
+}
+
+var _ Storage = (*Memory)(nil)
+
+//NewMemory - creating new empty in-memory storage
+func NewMemory() *Memory {
+	sublog.Info().Msg("In-memory storage object successfully created")
+	return &Memory{
+		users:     make(map[string]models.User),
+		orders:    make(map[string]memoryOrder),
+		withdraws: make(map[string]memoryWithdraw),
+		ledger:    make([]memoryEntry, 0),
+		jobs:      make(map[string]memoryJob),
+		sessions:  make(map[string]models.Session),
+		resets:    make(map[string]memoryResetToken),
+		throttles: make(map[string]models.LoginThrottle),
+		totpSteps: make(map[string]int64),
+		history:   make(map[string][]models.OrderStatus),
+		eventSeq:  make(map[string]int64),
+		listeners: make(map[int]func(models.UserEvent)),
+		recovery:  make(map[string]memoryRecoveryCode),
+		audit:     make([]models.AuditEvent, 0),
+
+		webhooks:   make(map[int64]models.Webhook),
+		deliveries: make(map[int64]models.WebhookDelivery),
+	}
+}
+
+//uniqueViolation - creating the same error as Postgres returns on unique index violation
+func uniqueViolation(index string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23505",
+		Message:        fmt.Sprintf("duplicate key value violates unique constraint \"%s\"", index),
+		ConstraintName: index,
+	}
+}
+
+//foreignKeyViolation - creating the same error as Postgres returns on foreign key violation
+func foreignKeyViolation(table, key string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23503",
+		Message:        fmt.Sprintf("insert or update on table \"%s\" violates foreign key constraint \"%s\"", table, key),
+		TableName:      table,
+		ConstraintName: key,
+	}
+}
+
+//Close - nothing to release. Data stays available
+func (s *Memory) Close() {}
+
+func (s *Memory) CreateUser(ctx context.Context, login, password, v string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Creating user %v", login)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[login]; ok {
+		err := uniqueViolation("users_name_key")
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	s.users[login] = models.User{Name: login, Password: password, Random: v, Role: models.RoleUser}
+	sublog.Info().Msgf("User with name '%s' created", login)
+	return nil
+}
+
+func (s *Memory) GetUser(ctx context.Context, login string) (models.User, error) {
+	if err := alive(ctx); err != nil {
+		return models.User{}, err
+	}
+	sublog.Info().Msgf("Requesting user's %v data", login)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[login]
+	if !ok {
+		sublog.Error().Err(pgx.ErrNoRows).Msg("")
+		return models.User{}, pgx.ErrNoRows
+	}
+	sublog.Debug().Msgf("Found user %s with password %s and random %s", user.Name, user.Password, user.Random)
+	return user, nil
+}
+
+func (s *Memory) GetOrders(ctx context.Context, login string) ([]models.Order, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	sublog.Info().Msgf("Requesting orders for user %v", login)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orders := make([]models.Order, 0)
+	for _, order := range s.orders {
+		if order.name == login {
+			orders = append(orders, order.Order)
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].Upload.After(orders[j].Upload)
+	})
+	sublog.Debug().Msgf("Result order slice: %v", orders)
+	return orders, nil
+}
+
+func (s *Memory) CreateOrder(ctx context.Context, order, user string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Creating new order %v", order)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.orders[order]; ok {
+		var err error
+		if o.name == user {
+			err = uniqueViolation("orders_order_user_idx")
+		} else {
+			err = uniqueViolation("orders_order_idx")
+		}
+		sublog.Info().Err(err).Msg("")
+		return err
+	}
+	if _, ok := s.users[user]; !ok {
+		err := foreignKeyViolation("orders", "orders_fk")
+		sublog.Info().Err(err).Msg("")
+		return err
+	}
+	now := time.Now()
+	s.orders[order] = memoryOrder{
+		Order: models.Order{
+			Number: order,
+			Status: "NEW",
+			Upload: now,
+		},
+		name: user,
+	}
+	s.recordStatus(user, order, "NEW", 0, now)
+	s.jobs[order] = memoryJob{
+		Job:   models.Job{Order: order, User: user, Trace: tracing.Parent(ctx)},
+		runAt: now,
+	}
+	sublog.Info().Msgf("Order %v created", order)
+	return nil
+}
+
+func (s *Memory) UpdateOrder(ctx context.Context, order, status string, accrual models.Money) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Debug().Msgf("Updating order %v with new status %v and accrual value %v", order, status, accrual)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[order]
+	if !ok {
+		sublog.Debug().Msgf("Order %v not found. Nothing to update", order)
+		return nil
+	}
+	if o.Status != status {
+		s.recordStatus(o.name, order, status, accrual, time.Now())
+	}
+	o.Status = status
+	o.AccRual = accrual
+	s.orders[order] = o
+	sublog.Info().Msgf("Order %v updated", order)
+	return nil
+}
+
+func (s *Memory) GetBalance(ctx context.Context, login string) (models.Balance, error) {
+	if err := alive(ctx); err != nil {
+		return models.Balance{}, err
+	}
+	sublog.Info().Msgf("Requesting balance for user %v", login)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.users[login]; !ok {
+		sublog.Error().Err(pgx.ErrNoRows).Msg("")
+		return models.Balance{}, pgx.ErrNoRows
+	}
+	return s.balance(login), nil
+}
+
+//balance - calculating user's balance from the ledger. Caller must hold the lock
+func (s *Memory) balance(login string) models.Balance {
+	balance := models.Balance{}
+	for _, e := range s.ledger {
+		if e.account != accountPoints || e.name != login {
+			continue
+		}
+		balance.Balance += e.Amount
+		if e.Kind == entryWithdrawal {
+			balance.Withdraws -= e.Amount
+		}
+	}
+	return balance
+}
+
+func (s *Memory) UpdateBalance(ctx context.Context, login string, accrual models.Money) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msg("Updating balance")
+	sublog.Debug().Msgf("User is %v and delta is %v", login, accrual)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changeBalance(login, entryAdjustment, "", accrual)
+}
+
+func (s *Memory) AdjustBalance(ctx context.Context, login string, delta models.Money, event models.AuditEvent) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Adjusting balance of user %v by %v", login, delta)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.changeBalance(login, entryAdjustment, "", delta)
+	if err != nil {
+		return err
+	}
+	s.appendAudit(event)
+	return nil
+}
+
+//changeBalance - posting the movement of user's points to the ledger. Caller must hold the write lock
+func (s *Memory) changeBalance(login, kind, order string, delta models.Money) error {
+	if _, ok := s.users[login]; !ok {
+		sublog.Error().Err(pgx.ErrNoRows).Msg("Error in get user balance request")
+		return pgx.ErrNoRows
+	}
+	balance := s.balance(login)
+	if delta < 0 && balance.Balance+delta < 0 {
+		sublog.Error().Msg("Balance is not enough")
+		return ErrBalanceTooLow
+	}
+	id := int64(len(s.ledger)/2 + 1)
+	now := time.Now()
+	s.ledger = append(s.ledger,
+		memoryEntry{
+			LedgerEntry: models.LedgerEntry{Kind: kind, Order: order, Amount: delta, Created: now},
+			transaction: id,
+			account:     accountPoints,
+			name:        login,
+		},
+		memoryEntry{
+			LedgerEntry: models.LedgerEntry{Kind: kind, Order: order, Amount: delta * -1, Created: now},
+			transaction: id,
+			account:     counterAccounts[kind],
+		},
+	)
+	s.appendAudit(ledgerEvent(login, kind, order, balance.Balance, delta, now))
+	s.publishEvent(balanceEvent(login, kind, order, balance.Balance+delta, delta, now))
+	sublog.Debug().Msgf("Ledger transaction %v: %v %v for user %v", id, kind, delta, login)
+	sublog.Info().Msgf("User %v updated", login)
+	return nil
+}
+
+func (s *Memory) CreateWithdraw(ctx context.Context, sum models.Money, login, order string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msg("Updating withdraw")
+	sublog.Debug().Msgf("User is %v. withdraw sum is %v for order %v", login, sum, order)
+	if sum <= 0 {
+		sublog.Info().Msgf("Withdraw sum %v is not positive", sum)
+		return ErrInvalidSum
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.withdraws[order]; ok {
+		var err error
+		if w.name == login {
+			err = uniqueViolation("withdraws_user_order_idx")
+		} else {
+			err = uniqueViolation("withdraws_order_idx")
+		}
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	err := s.changeBalance(login, entryWithdrawal, order, sum*-1)
+	if err != nil {
+		sublog.Info().Msg("Update balance failed")
+		return err
+	}
+	now := time.Now()
+	s.withdraws[order] = memoryWithdraw{
+		Withdraw: models.Withdraw{
+			Number:    order,
+			Processed: now,
+			Withdraw:  sum,
+		},
+		name: login,
+	}
+	s.enqueueWebhooks(login, models.WebhookWithdrawal, withdrawPayload(order, sum, now), now)
+	sublog.Info().Msg("Update orders withdraw complete")
+	return nil
+}
+
+func (s *Memory) CompleteOrder(ctx context.Context, order, login, worker, status string, accrual models.Money) (bool, error) {
+	if err := alive(ctx); err != nil {
+		return false, err
+	}
+	sublog.Info().Msgf("Completing order %v with status %v", order, status)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[order]; worker != "" && (!ok || j.lockedBy != worker) {
+		sublog.Info().Msgf("Accrual job %v is not held by worker %v", order, worker)
+		return false, ErrLeaseLost
+	}
+	o, ok := s.orders[order]
+	delete(s.jobs, order)
+	if !ok || o.name != login || o.Status == "PROCESSED" || o.Status == "INVALID" {
+		sublog.Info().Msgf("Order %v already completed. Skipping accrual", order)
+		return false, nil
+	}
+	s.recordStatus(login, order, status, accrual, time.Now())
+	if accrual > 0 {
+		err := s.changeBalance(login, entryAccrual, order, accrual)
+		if err != nil {
+			return false, err
+		}
+	}
+	o.Status = status
+	o.AccRual = accrual
+	s.orders[order] = o
+	sublog.Info().Msgf("Order %v completed", order)
+	return true, nil
+}
+
+func (s *Memory) GetWithdraws(ctx context.Context, login string) ([]models.Withdraw, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	sublog.Info().Msgf("Requesting user's %v withdraws", login)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	withdraws := make([]models.Withdraw, 0)
+	for _, w := range s.withdraws {
+		if w.name == login && w.Withdraw.Withdraw > 0 {
+			withdraws = append(withdraws, w.Withdraw)
+		}
+	}
+	sort.SliceStable(withdraws, func(i, j int) bool {
+		return withdraws[i].Processed.After(withdraws[j].Processed)
+	})
+	sublog.Debug().Msgf("Result withdraw slice: %v", withdraws)
+	return withdraws, nil
+}
+
+func (s *Memory) GetLedger(ctx context.Context, login string) ([]models.LedgerEntry, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	sublog.Info().Msgf("Requesting user's %v ledger", login)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]models.LedgerEntry, 0)
+	var balance models.Money
+	for _, e := range s.ledger {
+		if e.account != accountPoints || e.name != login {
+			continue
+		}
+		balance += e.Amount
+		entry := e.LedgerEntry
+		entry.Balance = balance
+		entries = append(entries, entry)
+	}
+	sublog.Debug().Msgf("Result ledger slice: %v", entries)
+	return entries, nil
+}
+
+//ClaimJobs - claiming up to limit due accrual jobs. Claimed jobs are hidden from other workers until lease expires
+func (s *Memory) ClaimJobs(ctx context.Context, worker string, limit int, lease time.Duration) ([]models.Job, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	due := make([]memoryJob, 0)
+	for _, j := range s.jobs {
+		if !j.runAt.After(now) && j.lockedUntil.Before(now) {
+			due = append(due, j)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].runAt.Before(due[j].runAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	jobs := make([]models.Job, 0, len(due))
+	for _, j := range due {
+		j.Attempts++
+		j.lockedBy = worker
+		j.lockedUntil = now.Add(lease)
+		s.jobs[j.Order] = j
+		jobs = append(jobs, j.Job)
+	}
+	if len(jobs) > 0 {
+		sublog.Debug().Msgf("Worker %v claimed %v accrual jobs", worker, len(jobs))
+	}
+	return jobs, nil
+}
+
+//RescheduleJob - releasing accrual job claimed by the worker until delay passes
+func (s *Memory) RescheduleJob(ctx context.Context, order, worker string, delay time.Duration) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[order]
+	if !ok || j.lockedBy != worker {
+		sublog.Info().Msgf("Accrual job %v is not held by worker %v", order, worker)
+		return ErrLeaseLost
+	}
+	j.runAt = time.Now().Add(delay)
+	j.lockedBy = ""
+	j.lockedUntil = time.Time{}
+	s.jobs[order] = j
+	sublog.Debug().Msgf("Accrual job %v rescheduled in %v", order, delay)
+	return nil
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/helpers"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestMemory_CreateUser(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tests := []struct {
+		name     string
+		login    string
+		conflict bool
+	}{
+		{
+			name:     "Create user 1",
+			login:    "username1",
+			conflict: false,
+		},
+		{
+			name:     "Create user 2",
+			login:    "username2",
+			conflict: false,
+		},
+		{
+			name:     "Create user duplicate",
+			login:    "username1",
+			conflict: true,
+		},
+	}
+	db := NewMemory()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.CreateUser(context.Background(), tt.login, "password", "iv")
+			if !tt.conflict {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.True(t, helpers.UserConflict(err))
+		})
+	}
+}
+
+func TestMemory_GetUser(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	db := NewMemory()
+	want := models.User{
+		Name:     "user1",
+		Password: "password1",
+		Random:   "random1",
+		Role:     models.RoleUser,
+	}
+	err := db.CreateUser(context.Background(), want.Name, want.Password, want.Random)
+	require.NoError(t, err)
+	got, err := db.GetUser(context.Background(), "user1")
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	_, err = db.GetUser(context.Background(), "user2")
+	require.Error(t, err)
+	require.True(t, helpers.EmptyRow(err))
+}
+
+func TestMemory_CreateOrder(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	type args struct {
+		order string
+		user  string
+	}
+	tests := []struct {
+		name   string
+		args   args
+		unique bool
+		exists bool
+		fail   bool
+	}{
+		{
+			name: "valid new order",
+			args: args{
+				order: "12345678",
+				user:  "user1",
+			},
+		},
+		{
+			name: "Not unique order",
+			args: args{
+				order: "12345678",
+				user:  "user2",
+			},
+			unique: true,
+			fail:   true,
+		},
+		{
+			name: "Order already created by user",
+			args: args{
+				order: "12345678",
+				user:  "user1",
+			},
+			exists: true,
+			fail:   true,
+		},
+		{
+			name: "Unknown user",
+			args: args{
+				order: "87654321",
+				user:  "user3",
+			},
+			fail: true,
+		},
+	}
+	db := NewMemory()
+	require.NoError(t, db.CreateUser(context.Background(), "user1", "password", "random1"))
+	require.NoError(t, db.CreateUser(context.Background(), "user2", "password", "random2"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.CreateOrder(context.Background(), tt.args.order, tt.args.user)
+			assert.Equal(t, tt.fail, err != nil)
+			if err != nil {
+				assert.Equal(t, tt.unique, helpers.OrderUnique(err))
+				assert.Equal(t, tt.exists, helpers.OrderExists(err))
+			}
+		})
+	}
+}
+
+func TestMemory_Orders(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	db := NewMemory()
+	require.NoError(t, db.CreateUser(context.Background(), "user111", "password111", "random111"))
+	require.NoError(t, db.CreateOrder(context.Background(), "123455", "user111"))
+	require.NoError(t, db.CreateOrder(context.Background(), "12345678903", "user111"))
+	require.NoError(t, db.UpdateOrder(context.Background(), "123455", "PROCESSED", 500*models.Point))
+	got, err := db.GetOrders(context.Background(), "user111")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, "12345678903", got[0].Number)
+	require.Equal(t, "NEW", got[0].Status)
+	require.Equal(t, "123455", got[1].Number)
+	require.Equal(t, "PROCESSED", got[1].Status)
+	require.Equal(t, 500*models.Point, got[1].AccRual)
+	got, err = db.GetOrders(context.Background(), "user112")
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func TestMemory_Balance(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	db := NewMemory()
+	require.NoError(t, db.CreateUser(context.Background(), "user111", "password111", "random111"))
+	require.NoError(t, db.UpdateBalance(context.Background(), "user111", 5000*models.Point))
+	tests := []struct {
+		name    string
+		user    string
+		order   string
+		sum     models.Money
+		wantErr error
+		want    models.Balance
+	}{
+		{
+			name:  "valid withdraw",
+			user:  "user111",
+			order: "123455",
+			sum:   1000 * models.Point,
+			want: models.Balance{
+				Balance:   4000 * models.Point,
+				Withdraws: 1000 * models.Point,
+			},
+		},
+		{
+			name:    "balance too low",
+			user:    "user111",
+			order:   "12345678903",
+			sum:     5000 * models.Point,
+			wantErr: ErrBalanceTooLow,
+			want: models.Balance{
+				Balance:   4000 * models.Point,
+				Withdraws: 1000 * models.Point,
+			},
+		},
+		{
+			name:  "duplicated withdraw order",
+			user:  "user111",
+			order: "123455",
+			sum:   1 * models.Point,
+			want: models.Balance{
+				Balance:   4000 * models.Point,
+				Withdraws: 1000 * models.Point,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.CreateWithdraw(context.Background(), tt.sum, tt.user, tt.order)
+			switch {
+			case tt.name == "duplicated withdraw order":
+				require.True(t, helpers.UserConflict(err))
+			case tt.wantErr != nil:
+				require.ErrorIs(t, err, tt.wantErr)
+				require.True(t, helpers.BalanceTooLow(err))
+			default:
+				require.NoError(t, err)
+			}
+			got, err := db.GetBalance(context.Background(), tt.user)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+	withdraws, err := db.GetWithdraws(context.Background(), "user111")
+	require.NoError(t, err)
+	require.Len(t, withdraws, 1)
+	require.Equal(t, "123455", withdraws[0].Number)
+	require.Equal(t, 1000*models.Point, withdraws[0].Withdraw)
+	_, err = db.GetBalance(context.Background(), "user112")
+	require.True(t, helpers.EmptyRow(err))
+}
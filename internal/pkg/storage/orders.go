@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//Postgres
+
+const (
+	listOrders    = `SELECT "order", "status", "accrual", "uploaded_at" FROM public.orders WHERE "name" = $1`
+	pendingOrders = `SELECT "status", COUNT(*) FROM public.orders WHERE "status" NOT IN ('PROCESSED', 'INVALID') GROUP BY "status"`
+)
+
+//pendingStatuses - statuses of orders waiting for accrual. Counters of all of them are returned even if there are no such orders
+var pendingStatuses = []string{"NEW", "REGISTERED", "PROCESSING"}
+
+//noPending - pending orders counters with zero values
+func noPending() map[string]int64 {
+	counts := make(map[string]int64, len(pendingStatuses))
+	for _, status := range pendingStatuses {
+		counts[status] = 0
+	}
+	return counts
+}
+
+//ordersQuery - building keyset query of user's orders. It is served by orders_name_uploaded_idx index
+func ordersQuery(login string, query models.OrderQuery) (string, []interface{}) {
+	args := []interface{}{login}
+	where := make([]string, 0)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if len(query.Statuses) > 0 {
+		add(`"status" = ANY($%d)`, query.Statuses)
+	}
+	if !query.From.IsZero() {
+		add(`"uploaded_at" >= $%d`, query.From)
+	}
+	if !query.To.IsZero() {
+		add(`"uploaded_at" < $%d`, query.To)
+	}
+	direction, compare := "ASC", ">"
+	if query.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if query.After != nil {
+		args = append(args, query.After.Upload, query.After.Number)
+		where = append(where, fmt.Sprintf(`("uploaded_at", "order") %s ($%d, $%d)`, compare, len(args)-1, len(args)))
+	}
+	sql := listOrders
+	if len(where) > 0 {
+		sql += " AND " + strings.Join(where, " AND ")
+	}
+	sql += fmt.Sprintf(` ORDER BY "uploaded_at" %s, "order" %s`, direction, direction)
+	if query.Limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+	return sql, args
+}
+
+func (s *Database) ListOrders(ctx context.Context, login string, query models.OrderQuery) (_ []models.Order, err error) {
+	ctx, done := s.operation(ctx, "ListOrders", s.timeouts.Read, &err)
+	defer done()
+	sublog.Info().Msgf("Listing orders of user %v", login)
+	sql, args := ordersQuery(login, query)
+	rows, err := s.conn.Query(ctx, sql, args...)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	orders := make([]models.Order, 0)
+	for rows.Next() {
+		order := models.Order{}
+		err = rows.Scan(&order.Number, &order.Status, &order.AccRual, &order.Upload)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+//CountPendingOrders - counting orders of all users without final status
+func (s *Database) CountPendingOrders(ctx context.Context) (_ map[string]int64, err error) {
+	ctx, done := s.operation(ctx, "CountPendingOrders", s.timeouts.Read, &err)
+	defer done()
+	rows, err := s.conn.Query(ctx, pendingOrders)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	counts := noPending()
+	for rows.Next() {
+		var status string
+		var count int64
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+//In-memory
+
+//cursorBefore - comparing positions of orders by upload time and number
+func cursorBefore(a, b models.OrderCursor) bool {
+	if a.Upload.Equal(b.Upload) {
+		return a.Number < b.Number
+	}
+	return a.Upload.Before(b.Upload)
+}
+
+//orderCursor - position of the order in listing
+func orderCursor(o models.Order) models.OrderCursor {
+	return models.OrderCursor{Upload: o.Upload, Number: o.Number}
+}
+
+//orderMatch - checking the order against conditions of the query except the limit
+func orderMatch(o models.Order, query models.OrderQuery) bool {
+	if len(query.Statuses) > 0 {
+		found := false
+		for _, status := range query.Statuses {
+			if o.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !query.From.IsZero() && o.Upload.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && !o.Upload.Before(query.To) {
+		return false
+	}
+	if query.After != nil {
+		if query.Desc {
+			return cursorBefore(orderCursor(o), *query.After)
+		}
+		return cursorBefore(*query.After, orderCursor(o))
+	}
+	return true
+}
+
+//CountPendingOrders - counting orders of all users without final status
+func (s *Memory) CountPendingOrders(ctx context.Context) (map[string]int64, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := noPending()
+	for _, order := range s.orders {
+		if order.Status != "PROCESSED" && order.Status != "INVALID" {
+			counts[order.Status]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *Memory) ListOrders(ctx context.Context, login string, query models.OrderQuery) ([]models.Order, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	sublog.Info().Msgf("Listing orders of user %v", login)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orders := make([]models.Order, 0)
+	for _, order := range s.orders {
+		if order.name == login && orderMatch(order.Order, query) {
+			orders = append(orders, order.Order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if query.Desc {
+			i, j = j, i
+		}
+		return cursorBefore(orderCursor(orders[i]), orderCursor(orders[j]))
+	})
+	if query.Limit > 0 && len(orders) > query.Limit {
+		orders = orders[:query.Limit]
+	}
+	return orders, nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//memoryResetToken - reset token with usage time
+type memoryResetToken struct {
+	models.ResetToken
+	used time.Time
+}
+
+//Postgres
+
+const (
+	updatePassword    = `UPDATE public.users SET "password" = $1, "random_iv" = $2 WHERE "name" = $3`
+	deleteSessions    = `DELETE FROM public.sessions WHERE "name" = $1 AND "id" <> $2`
+	createResetToken  = `INSERT INTO public.reset_tokens ("hash", "name", "created_at", "expires_at") VALUES ($1,$2,$3,$4)`
+	consumeResetToken = `UPDATE public.reset_tokens SET "used_at" = $1 WHERE "hash" = $2 AND "used_at" IS NULL AND "expires_at" > $1 RETURNING "name"`
+)
+
+//UpdatePassword - changing user's password and random IV. All user's sessions except keep are revoked in the same transaction
+func (s *Database) UpdatePassword(ctx context.Context, login, password, v, keep string) (err error) {
+	ctx, done := s.operation(ctx, "UpdatePassword", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Changing password of user %v", login)
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	err = setPassword(ctx, tx, login, password, v, keep)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
+	sublog.Info().Msgf("Password of user %v changed", login)
+	return nil
+}
+
+func (s *Database) CreateResetToken(ctx context.Context, token models.ResetToken) (err error) {
+	ctx, done := s.operation(ctx, "CreateResetToken", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Creating password reset token for user %v", token.User)
+	_, err = s.conn.Exec(ctx, createResetToken, token.Hash, token.User, token.Created, token.Expires)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+//ResetPassword - consuming reset token and changing password of its owner. All owner's sessions are revoked
+func (s *Database) ResetPassword(ctx context.Context, hash, password, v string) (_ string, err error) {
+	ctx, done := s.operation(ctx, "ResetPassword", s.timeouts.Write, &err)
+	defer done()
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return "", err
+	}
+	defer rollback(tx)
+	var login string
+	err = tx.QueryRow(ctx, consumeResetToken, time.Now(), hash).Scan(&login)
+	if err != nil {
+		sublog.Info().Err(err).Msg("Reset token is unknown, used or expired")
+		return "", err
+	}
+	err = setPassword(ctx, tx, login, password, v, "")
+	if err != nil {
+		return "", err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return "", err
+	}
+	sublog.Info().Msgf("Password of user %v reset", login)
+	return login, nil
+}
+
+//setPassword - updating user's password and revoking sessions inside the transaction
+func setPassword(ctx context.Context, tx pgx.Tx, login, password, v, keep string) error {
+	tag, err := tx.Exec(ctx, updatePassword, password, v, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	tag, err = tx.Exec(ctx, deleteSessions, login, keep)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	sublog.Debug().Msgf("%v sessions of user %v revoked", tag.RowsAffected(), login)
+	return nil
+}
+
+//In-memory
+
+func (s *Memory) UpdatePassword(ctx context.Context, login, password, v, keep string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Changing password of user %v", login)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setPassword(login, password, v, keep)
+}
+
+func (s *Memory) CreateResetToken(ctx context.Context, token models.ResetToken) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Creating password reset token for user %v", token.User)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[token.User]; !ok {
+		err := foreignKeyViolation("reset_tokens", "reset_tokens_fk")
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if _, ok := s.resets[token.Hash]; ok {
+		err := uniqueViolation("reset_tokens_hash_pk")
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	s.resets[token.Hash] = memoryResetToken{ResetToken: token}
+	return nil
+}
+
+func (s *Memory) ResetPassword(ctx context.Context, hash, password, v string) (string, error) {
+	if err := alive(ctx); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	token, ok := s.resets[hash]
+	if !ok || !token.used.IsZero() || !now.Before(token.Expires) {
+		sublog.Info().Msg("Reset token is unknown, used or expired")
+		return "", pgx.ErrNoRows
+	}
+	err := s.setPassword(token.User, password, v, "")
+	if err != nil {
+		return "", err
+	}
+	token.used = now
+	s.resets[hash] = token
+	sublog.Info().Msgf("Password of user %v reset", token.User)
+	return token.User, nil
+}
+
+//setPassword - updating user's password and revoking sessions. Caller must hold the lock
+func (s *Memory) setPassword(login, password, v, keep string) error {
+	user, ok := s.users[login]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	user.Password = password
+	user.Random = v
+	s.users[login] = user
+	for id, session := range s.sessions {
+		if session.User == login && id != keep {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
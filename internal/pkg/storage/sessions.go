@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//Postgres
+
+const (
+	createSession = `INSERT INTO public.sessions ("id", "name", "device", "ip", "created_at", "last_seen_at", "expires_at", "verified_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`
+	getSession    = `SELECT "id", "name", "device", "ip", "created_at", "last_seen_at", "expires_at", "verified_at" FROM public.sessions WHERE "id" = $1`
+	touchSession  = `UPDATE public.sessions SET "last_seen_at" = $1, "ip" = $2 WHERE "id" = $3`
+	getSessions   = `SELECT "id", "name", "device", "ip", "created_at", "last_seen_at", "expires_at", "verified_at" FROM public.sessions WHERE "name" = $1 ORDER BY "last_seen_at" DESC`
+	deleteSession = `DELETE FROM public.sessions WHERE "name" = $1 AND "id" = $2`
+	verifySession = `UPDATE public.sessions SET "verified_at" = $1 WHERE "id" = $2`
+)
+
+//nullTime - converting zero time to NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+//scanSession - reading session from the row
+func scanSession(row pgx.Row) (models.Session, error) {
+	session := models.Session{}
+	var verified *time.Time
+	err := row.Scan(&session.ID, &session.User, &session.Device, &session.IP, &session.Created, &session.LastSeen, &session.Expires, &verified)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if verified != nil {
+		session.Verified = *verified
+	}
+	return session, nil
+}
+
+func (s *Database) CreateSession(ctx context.Context, session models.Session) (err error) {
+	ctx, done := s.operation(ctx, "CreateSession", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Creating new session for user %v", session.User)
+	_, err = s.conn.Exec(ctx, createSession, session.ID, session.User, session.Device, session.IP, session.Created, session.LastSeen, session.Expires, nullTime(session.Verified))
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+func (s *Database) GetSession(ctx context.Context, id string) (_ models.Session, err error) {
+	ctx, done := s.operation(ctx, "GetSession", s.timeouts.Read, &err)
+	defer done()
+	session, err := scanSession(s.conn.QueryRow(ctx, getSession, id))
+	if err != nil {
+		sublog.Debug().Err(err).Msgf("Session %v not found", id)
+		return models.Session{}, err
+	}
+	return session, nil
+}
+
+func (s *Database) TouchSession(ctx context.Context, id, ip string, seen time.Time) (err error) {
+	ctx, done := s.operation(ctx, "TouchSession", s.timeouts.Write, &err)
+	defer done()
+	_, err = s.conn.Exec(ctx, touchSession, seen, ip, id)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+func (s *Database) GetSessions(ctx context.Context, login string) (_ []models.Session, err error) {
+	ctx, done := s.operation(ctx, "GetSessions", s.timeouts.Read, &err)
+	defer done()
+	sublog.Info().Msgf("Requesting user's %v sessions", login)
+	rows, err := s.conn.Query(ctx, getSessions, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := make([]models.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *Database) DeleteSession(ctx context.Context, login, id string) (err error) {
+	ctx, done := s.operation(ctx, "DeleteSession", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Revoking session of user %v", login)
+	tag, err := s.conn.Exec(ctx, deleteSession, login, id)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Database) VerifySession(ctx context.Context, id string, at time.Time) (err error) {
+	ctx, done := s.operation(ctx, "VerifySession", s.timeouts.Write, &err)
+	defer done()
+	_, err = s.conn.Exec(ctx, verifySession, at, id)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+//In-memory
+
+func (s *Memory) CreateSession(ctx context.Context, session models.Session) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Creating new session for user %v", session.User)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[session.User]; !ok {
+		err := foreignKeyViolation("sessions", "sessions_fk")
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if _, ok := s.sessions[session.ID]; ok {
+		err := uniqueViolation("sessions_id_pk")
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *Memory) GetSession(ctx context.Context, id string) (models.Session, error) {
+	if err := alive(ctx); err != nil {
+		return models.Session{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		sublog.Debug().Msgf("Session %v not found", id)
+		return models.Session{}, pgx.ErrNoRows
+	}
+	return session, nil
+}
+
+func (s *Memory) TouchSession(ctx context.Context, id, ip string, seen time.Time) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	session.LastSeen = seen
+	session.IP = ip
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *Memory) GetSessions(ctx context.Context, login string) ([]models.Session, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	sublog.Info().Msgf("Requesting user's %v sessions", login)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := make([]models.Session, 0)
+	for _, session := range s.sessions {
+		if session.User == login {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+func (s *Memory) DeleteSession(ctx context.Context, login, id string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Revoking session of user %v", login)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.User != login {
+		return pgx.ErrNoRows
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *Memory) VerifySession(ctx context.Context, id string, at time.Time) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	session.Verified = at
+	s.sessions[id] = session
+	return nil
+}
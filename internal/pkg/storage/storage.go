@@ -4,78 +4,205 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math"
 	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/t1mon-ggg/gophermart/internal/pkg/migrations"
 	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/tracing"
 )
 
+//Storage - interface of the application data storage
+type Storage interface {
+	CreateUser(ctx context.Context, login, password, v string) error                                                                        //Creating new user with empty balance
+	GetUser(ctx context.Context, login string) (models.User, error)                                                                         //Requesting user's data
+	CreateOrder(ctx context.Context, order, user string) error                                                                              //Creating new user's order
+	GetOrders(ctx context.Context, login string) ([]models.Order, error)                                                                    //Requesting all user's orders
+	ListOrders(ctx context.Context, login string, query models.OrderQuery) ([]models.Order, error)                                          //Requesting page of user's orders matching the query
+	CountPendingOrders(ctx context.Context) (map[string]int64, error)                                                                       //Counting orders of all users without final status by status
+	GetOrder(ctx context.Context, login, order string) (models.Order, error)                                                                //Requesting user's order. Returns pgx.ErrNoRows if user has no such order
+	GetOrderHistory(ctx context.Context, order string) ([]models.OrderStatus, error)                                                        //Requesting all status changes of the order from the oldest
+	UpdateOrder(ctx context.Context, order, status string, accrual models.Money) error                                                      //Updating order status and accrual value
+	GetBalance(ctx context.Context, login string) (models.Balance, error)                                                                   //Requesting user's balance
+	UpdateBalance(ctx context.Context, login string, accrual models.Money) error                                                            //Changing user's balance by delta
+	AdjustBalance(ctx context.Context, login string, delta models.Money, event models.AuditEvent) error                                     //Changing user's balance by delta and writing the audit event in one transaction
+	CreateWithdraw(ctx context.Context, sum models.Money, login, order string) error                                                        //Creating new withdraw for the order
+	CompleteOrder(ctx context.Context, order, login, worker, status string, accrual models.Money) (bool, error)                             //Setting final order status and adding accrual to user's balance. Returns false if the order was already completed and nothing was changed. Not empty worker must hold the job, otherwise ErrLeaseLost is returned
+	GetWithdraws(ctx context.Context, login string) ([]models.Withdraw, error)                                                              //Requesting all user's withdraws
+	GetLedger(ctx context.Context, login string) ([]models.LedgerEntry, error)                                                              //Requesting all movements of user's points with running balance
+	ClaimJobs(ctx context.Context, worker string, limit int, lease time.Duration) ([]models.Job, error)                                     //Claiming due accrual jobs. Claimed jobs are hidden from other workers until lease expires
+	RescheduleJob(ctx context.Context, order, worker string, delay time.Duration) error                                                     //Releasing job claimed by the worker and postponing next claim by delay. Returns ErrLeaseLost if the job is not held by the worker
+	CreateSession(ctx context.Context, session models.Session) error                                                                        //Creating new login session
+	GetSession(ctx context.Context, id string) (models.Session, error)                                                                      //Requesting session by identifier
+	TouchSession(ctx context.Context, id, ip string, seen time.Time) error                                                                  //Updating session's last request time and address
+	GetSessions(ctx context.Context, login string) ([]models.Session, error)                                                                //Requesting all user's sessions
+	DeleteSession(ctx context.Context, login, id string) error                                                                              //Revoking user's session. Returns pgx.ErrNoRows if user has no such session
+	UpdatePassword(ctx context.Context, login, password, v, keep string) error                                                              //Changing user's password and random IV and revoking all user's sessions except keep
+	CreateResetToken(ctx context.Context, token models.ResetToken) error                                                                    //Storing new password reset token
+	ResetPassword(ctx context.Context, hash, password, v string) (string, error)                                                            //Consuming reset token, changing password of its owner and revoking all owner's sessions. Returns owner's name or pgx.ErrNoRows if token is unknown, used or expired
+	GetThrottle(ctx context.Context, scope, key string) (models.LoginThrottle, error)                                                       //Requesting failed login attempts of the account or the address. Returns pgx.ErrNoRows if there were no failures
+	RecordFailure(ctx context.Context, scope, key string, at time.Time, window time.Duration) (models.LoginThrottle, error)                 //Counting failed login attempt. Counter starts again if the previous failure is older than window
+	ReserveAttempt(ctx context.Context, scope, key string, at time.Time, window time.Duration) (models.LoginThrottle, time.Duration, error) //Counting the attempt as failed if it is allowed, otherwise returning time to wait. Check and count are atomic
+	ReleaseAttempt(ctx context.Context, scope, key string) error                                                                            //Uncounting reserved attempt which was not failed
+	LockThrottle(ctx context.Context, scope, key string, until time.Time) error                                                             //Refusing login of the account or the address until the time
+	ResetThrottle(ctx context.Context, scope, key string) error                                                                             //Removing failed attempts and lock of the account or the address
+	VerifySession(ctx context.Context, id string, at time.Time) error                                                                       //Storing time of 2FA check in the session
+	SetTOTP(ctx context.Context, login, secret string) error                                                                                //Storing not confirmed TOTP secret. Returns pgx.ErrNoRows if user is unknown or 2FA is already enabled
+	EnableTOTP(ctx context.Context, login string, step int64, codes []string) error                                                         //Confirming 2FA enrolment with TOTP code of the time step and replacing recovery codes with the hashes. Returns pgx.ErrNoRows if there is no pending enrolment or the step was already used
+	DisableTOTP(ctx context.Context, login string) error                                                                                    //Removing TOTP secret and recovery codes
+	UseTOTPStep(ctx context.Context, login string, step int64) error                                                                        //Accepting TOTP code of the time step once. Returns pgx.ErrNoRows if the step or a later one was already used
+	UseRecoveryCode(ctx context.Context, login, hash string) error                                                                          //Consuming recovery code by hash. Returns pgx.ErrNoRows if code is unknown or used
+	SetRole(ctx context.Context, login, role string) error                                                                                  //Changing user's role. Returns pgx.ErrNoRows if user is unknown
+	SetBlocked(ctx context.Context, login string, blocked bool) error                                                                       //Blocking or unblocking user. Blocking revokes all user's sessions. Returns pgx.ErrNoRows if user is unknown
+	RequeueOrder(ctx context.Context, order string) error                                                                                   //Returning order to accrual job queue. Returns pgx.ErrNoRows if order is unknown and ErrOrderCompleted if order is processed
+	CreateAuditEvent(ctx context.Context, event models.AuditEvent) error                                                                    //Appending event to audit trail
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)                                             //Requesting audit events matching the filter in order of events
+	GetUserEvents(ctx context.Context, login string, after int64, limit int) ([]models.UserEvent, error)                                    //Requesting user's events with identifier greater than after in order of events
+	ListenUserEvents(ctx context.Context, deliver func(models.UserEvent)) error                                                             //Delivering events of all users published by any instance until ctx is done
+	PruneUserEvents(ctx context.Context, before time.Time) (int64, error)                                                                   //Removing events created before the time. Returns number of removed events
+	CreateWebhook(ctx context.Context, hook models.Webhook) (models.Webhook, error)                                                         //Creating subscription. Returns it with identifier and creation time
+	GetWebhooks(ctx context.Context, owner string) ([]models.Webhook, error)                                                                //Requesting user's subscriptions without secrets. Empty owner requests subscriptions to events of all users
+	GetWebhook(ctx context.Context, id int64) (models.Webhook, error)                                                                       //Requesting subscription without secret. Returns pgx.ErrNoRows if subscription is unknown
+	DeleteWebhook(ctx context.Context, id int64) error                                                                                      //Removing subscription with all its deliveries. Returns pgx.ErrNoRows if subscription is unknown
+	GetDeliveries(ctx context.Context, webhook int64, status string, limit int) ([]models.WebhookDelivery, error)                           //Requesting newest deliveries of the subscription. Empty status requests deliveries in any status
+	GetDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error)                                                              //Requesting delivery. Returns pgx.ErrNoRows if delivery is unknown
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)                                  //Claiming due deliveries with receiver address and secret. Claimed deliveries are hidden from other workers until lease expires
+	UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery, claimed time.Time) error                                           //Storing status, next attempt time and last error of the delivery claimed with attempts and lease end. Returns ErrLeaseLost if it was claimed again
+	RedeliverDelivery(ctx context.Context, id int64) error                                                                                  //Returning delivery to the queue with attempts counter reset. Returns pgx.ErrNoRows if delivery is unknown
+	Ping(ctx context.Context) error                                                                                                         //Checking that storage answers
+	SchemaVersion(ctx context.Context) (int, int, error)                                                                                    //Requesting applied and latest known schema versions. In-memory storage has no schema and reports zeros
+	Close()                                                                                                                                 //Releasing storage resources. Storage must not be used after it
+}
+
+//ErrBalanceTooLow - error returned when user's balance is not enough for a withdraw
+var ErrBalanceTooLow = errors.New("we need to build more ziggurats")
+
+//ErrInvalidSum - error returned when withdraw sum is not positive
+var ErrInvalidSum = errors.New("sum must be positive")
+
+//ErrLeaseLost - error returned when accrual job or webhook delivery is not held by the worker any more. It was completed or claimed by another worker after lease expired
+var ErrLeaseLost = errors.New("lease lost")
+
+//ErrOrderCompleted - error returned when processed order is re-queued for accrual
+var ErrOrderCompleted = errors.New("order already processed")
+
+//ErrCanceled - error returned when storage request is cancelled by the caller or stopped by timeout. Returned errors also match the context error
+var ErrCanceled = errors.New("storage request cancelled")
+
+//canceledError - error of the storage request interrupted by its context
+type canceledError struct {
+	err error
+}
+
+func (e *canceledError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrCanceled, e.err)
+}
+
+func (e *canceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+func (e *canceledError) Unwrap() error {
+	return e.err
+}
+
+//canceled - turning error caused by done context or by cancelled query into ErrCanceled. Other errors are returned as is
+func canceled(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ErrCanceled) {
+		return err
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code != "57014" {
+		return err
+	}
+	if pgErr != nil || ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return &canceledError{err: err}
+	}
+	return err
+}
+
+//alive - checking that the caller still waits for the result. Returns ErrCanceled if ctx is done
+func alive(ctx context.Context) error {
+	return canceled(ctx, ctx.Err())
+}
+
+//Ledger entry kinds
+const (
+	entryAccrual    = "ACCRUAL"    //Points credited for processed order
+	entryWithdrawal = "WITHDRAWAL" //Points debited for withdraw order
+	entryAdjustment = "ADJUSTMENT" //Points credited or debited without order
+	entryOpening    = "OPENING"    //Opening balance transferred from balance snapshot
+)
+
+//Ledger accounts. Every movement posts two entries: one to user's points account and one to the counter system account
+const (
+	accountPoints = "points" //User's points account
+)
+
+//counterAccounts - system accounts for every entry kind
+var counterAccounts = map[string]string{
+	entryAccrual:    "accrual",
+	entryWithdrawal: "withdrawals",
+	entryAdjustment: "adjustments",
+	entryOpening:    "opening",
+}
+
 //Postgres
 
 const (
-	dbSchema = `
-	CREATE TABLE IF NOT EXISTS users (
-		id int4 NOT NULL GENERATED ALWAYS AS IDENTITY,
-		"name" text NOT NULL UNIQUE,
-		"password" text NOT NULL,
-		"random_iv" text NOT NULL,
-		CONSTRAINT users_id_pk PRIMARY KEY (id)
-	);
-
-	CREATE TABLE IF NOT EXISTS balance (
-		id int4 NOT NULL GENERATED ALWAYS AS IDENTITY,
-		"name" text NOT NULL UNIQUE,
-		"balance" float8 NOT NULL DEFAULT 0,
-		"withdraw" float8 NOT NULL DEFAULT 0,
-		CONSTRAINT balance_fk FOREIGN KEY (name) REFERENCES public.users("name"),
-		CONSTRAINT balance_id_pk PRIMARY KEY (id)
-	);
-
-	CREATE TABLE IF NOT EXISTS public.orders (
-		id int4 NOT NULL GENERATED ALWAYS AS IDENTITY,
-		"order" text NOT NULL,
-		"name" text NOT NULL,
-		"status" text NOT NULL DEFAULT 'NEW',
-		"uploaded_at" timestamptz NOT NULL,
-		"accrual" float8 NOT NULL DEFAULT 0,
-		CONSTRAINT orders_fk FOREIGN KEY (name) REFERENCES public.users("name"),
-		CONSTRAINT orders_id_pk PRIMARY KEY (id)
-	);
-	CREATE TABLE IF NOT EXISTS public.withdraws (
-		id int4 NOT NULL GENERATED ALWAYS AS IDENTITY,
-		"name" text NOT NULL,
-		"order" text NOT NULL,
-		"processed_at"  timestamptz,
-		"withdraw" float8 NOT NULL DEFAULT 0,
-		CONSTRAINT withdraws_fk FOREIGN KEY (name) REFERENCES public.users("name")
-	);
-	CREATE UNIQUE INDEX IF NOT EXISTS orders_order_user_idx ON public.orders ("order","name");
-	CREATE UNIQUE INDEX IF NOT EXISTS orders_order_idx ON public.orders ("order");
-	CREATE UNIQUE INDEX IF NOT EXISTS withdraws_user_order_idx ON public.withdraws ("order", "name");
-	CREATE UNIQUE INDEX IF NOT EXISTS withdraws_order_idx ON public.withdraws ("order");
-	`
 	createUser        = `INSERT INTO public.users ("name","password","random_iv") VALUES ($1,$2,$3)`
 	createUserBalance = `INSERT INTO public.balance ("name") VALUES ($1)`
-	getUser           = `SELECT "password", "random_iv" from public.users where "name" = $1`
+	getUser           = `SELECT "password", "random_iv", "totp_secret", "totp_enabled", "role", "blocked" from public.users where "name" = $1`
 	createOrder       = `INSERT INTO public.orders ("order","name","uploaded_at") VALUES ($1,$2,$3)`
+	createJob         = `INSERT INTO public.accrual_jobs ("order","name","run_at","trace") VALUES ($1,$2,$3,$4)`
+	claimJobs         = `UPDATE public.accrual_jobs SET "attempts" = "attempts" + 1, "locked_by" = $1, "locked_until" = $2 WHERE "order" IN (SELECT "order" FROM public.accrual_jobs WHERE "run_at" <= $3 AND ("locked_until" IS NULL OR "locked_until" < $3) ORDER BY "run_at" LIMIT $4 FOR UPDATE SKIP LOCKED) RETURNING "order", "name", "attempts", COALESCE("trace", '')`
+	rescheduleJob     = `UPDATE public.accrual_jobs SET "run_at" = $1, "locked_by" = NULL, "locked_until" = NULL WHERE "order" = $2 AND "locked_by" = $3`
+	deleteJob         = `DELETE FROM public.accrual_jobs WHERE "order" = $1`
+	releaseJob        = `DELETE FROM public.accrual_jobs WHERE "order" = $1 AND "locked_by" = $2`
 	getOrders         = `SELECT "order", "status", "accrual", "uploaded_at" from public.orders where "name" = $1 ORDER BY "uploaded_at" DESC`
-	getBalance        = `SELECT "balance", "withdraw" FROM public.balance where "name" = $1`
+	getBalance        = `SELECT COALESCE(SUM(l."amount"), 0), COALESCE(-SUM(l."amount") FILTER (WHERE l."kind" = 'WITHDRAWAL'), 0) FROM public.users u LEFT JOIN public.ledger l ON l."name" = u."name" AND l."account" = 'points' WHERE u."name" = $1 GROUP BY u."name"`
 	updateOrder       = `UPDATE public.orders SET status=$1, accrual=$2 WHERE "order" = $3`
-	updateBalance     = `UPDATE public.balance SET balance=$1, withdraw=$2 WHERE "name" = $3`
+	lockBalance       = `SELECT "balance" FROM public.balance WHERE "name" = $1 FOR UPDATE`
+	creditBalance     = `UPDATE public.balance SET balance = balance + $1 WHERE "name" = $2`
+	debitBalance      = `UPDATE public.balance SET balance = balance - $1, withdraw = withdraw + $1 WHERE "name" = $2`
+	ledgerBalance     = `SELECT COALESCE(SUM("amount"), 0) FROM public.ledger WHERE "account" = 'points' AND "name" = $1`
+	nextTransaction   = `SELECT nextval('public.ledger_transaction_seq')`
+	createEntry       = `INSERT INTO public.ledger ("transaction", "account", "name", "kind", "order", "amount", "created_at") VALUES ($1,$2,$3,$4,$5,$6,$7)`
+	getLedger         = `SELECT "kind", "order", "amount", SUM("amount") OVER (ORDER BY "id"), "created_at" FROM public.ledger WHERE "account" = 'points' AND "name" = $1 ORDER BY "id"`
+	completeOrder     = `UPDATE public.orders SET status=$1, accrual=$2 WHERE "order" = $3 AND "name" = $4 AND status NOT IN ('PROCESSED', 'INVALID')`
 	createWithdraw    = `INSERT INTO public.withdraws ("name", "order", "processed_at", "withdraw")  VALUES ($1,$2,$3,$4)`
 	getWithdraws      = `SELECT "order", "withdraw", "processed_at" FROM public.withdraws WHERE "name" = $1 ORDER BY "processed_at" DESC`
 )
 
+//Timeouts - limits of one storage operation. Zero value means no limit except the caller's context
+type Timeouts struct {
+	Read  time.Duration //Limit of requests reading data
+	Write time.Duration //Limit of requests and transactions changing data
+}
+
 type Database struct {
-	conn *pgxpool.Pool
+	conn     *pgxpool.Pool
+	migrator *migrations.Migrator
+	timeouts Timeouts
 }
 
+var _ Storage = (*Database)(nil)
+
 var sublog = log.With().Str("component", "storage").Logger()
 
-func New(path string) (*Database, error) {
+//tracer - tracer of storage operations
+var tracer = otel.Tracer("github.com/t1mon-ggg/gophermart/internal/pkg/storage")
+
+//New - connecting to Postgres database. If migrate is true pending migrations are applied, otherwise outdated schema is an error
+func New(path string, migrate bool) (*Database, error) {
 	db := Database{}
 	d, err := open(path)
 	if err != nil {
@@ -84,9 +211,10 @@ func New(path string) (*Database, error) {
 	}
 	db.conn = d
 
-	err = db.create()
+	err = db.create(migrate)
 	if err != nil {
 		sublog.Error().Msg("Fatal error on table create. Quiting")
+		d.Close()
 		return nil, err
 	}
 	sublog.Info().Msg("Database object successfully created")
@@ -94,6 +222,34 @@ func New(path string) (*Database, error) {
 
 }
 
+//SetTimeouts - limiting every following storage operation
+func (s *Database) SetTimeouts(t Timeouts) {
+	s.timeouts = t
+}
+
+//operation - tracing the operation and limiting it by timeout. Returned function must be deferred: it releases the context, turns error stored in err
+//into ErrCanceled if it is caused by cancellation and ends the span. Span is started only inside traced request or job, so polling loops don't create
+//a trace per query. Missing rows are not treated as span errors
+func (s *Database) operation(ctx context.Context, name string, timeout time.Duration, err *error) (context.Context, func()) {
+	span := trace.SpanFromContext(ctx)
+	if span.SpanContext().IsValid() {
+		ctx, span = tracer.Start(ctx, "storage."+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationKey.String(name)))
+	}
+	cancel := func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {
+		*err = canceled(ctx, *err)
+		if *err != nil && !errors.Is(*err, pgx.ErrNoRows) {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		cancel()
+		span.End()
+	}
+}
+
 func open(s string) (*pgxpool.Pool, error) {
 	sublog.Info().Msg("Connectiong to database")
 	db, err := pgxpool.Connect(context.Background(), s)
@@ -105,9 +261,19 @@ func open(s string) (*pgxpool.Pool, error) {
 	return db, nil
 }
 
-func (s *Database) create() error {
-	sublog.Info().Msg("Creating databse scheme")
-	_, err := s.conn.Exec(context.Background(), dbSchema)
+//create - applying schema migrations or checking that schema is up to date
+func (s *Database) create(migrate bool) error {
+	m, err := migrations.New(s.conn)
+	if err != nil {
+		return err
+	}
+	s.migrator = m
+	if !migrate {
+		sublog.Info().Msg("Checking databse scheme version")
+		return m.Check(context.Background())
+	}
+	sublog.Info().Msg("Migrating databse scheme")
+	err = m.Up(context.Background())
 	if err != nil {
 		sublog.Error().Err(err).Msg("")
 		return err
@@ -116,15 +282,17 @@ func (s *Database) create() error {
 	return nil
 }
 
-func (s *Database) CreateUser(login, password, v string) error {
+func (s *Database) CreateUser(ctx context.Context, login, password, v string) (err error) {
+	ctx, done := s.operation(ctx, "CreateUser", s.timeouts.Write, &err)
+	defer done()
 	sublog.Info().Msgf("Creating user %v", login)
-	_, err := s.conn.Exec(context.Background(), createUser, login, password, v)
+	_, err = s.conn.Exec(ctx, createUser, login, password, v)
 	if err != nil {
 		sublog.Error().Err(err).Msg("")
 		return err
 	}
 	sublog.Info().Msgf("User with name '%s' created", login)
-	_, err = s.conn.Exec(context.Background(), createUserBalance, login)
+	_, err = s.conn.Exec(ctx, createUserBalance, login)
 	if err != nil {
 		sublog.Error().Err(err).Msg("")
 		return err
@@ -132,12 +300,14 @@ func (s *Database) CreateUser(login, password, v string) error {
 	return nil
 }
 
-func (s *Database) GetUser(login string) (models.User, error) {
+func (s *Database) GetUser(ctx context.Context, login string) (_ models.User, err error) {
+	ctx, done := s.operation(ctx, "GetUser", s.timeouts.Read, &err)
+	defer done()
 	sublog.Info().Msgf("Requesting user's %v data", login)
 	user := models.User{}
 	var password string
 	var random string
-	err := s.conn.QueryRow(context.Background(), getUser, login).Scan(&password, &random)
+	err = s.conn.QueryRow(ctx, getUser, login).Scan(&password, &random, &user.TOTPSecret, &user.TOTPEnabled, &user.Role, &user.Blocked)
 	if err != nil {
 		sublog.Error().Err(err).Msg("")
 		return user, err
@@ -150,10 +320,12 @@ func (s *Database) GetUser(login string) (models.User, error) {
 	return user, nil
 }
 
-func (s *Database) GetOrders(login string) ([]models.Order, error) {
+func (s *Database) GetOrders(ctx context.Context, login string) (_ []models.Order, err error) {
+	ctx, done := s.operation(ctx, "GetOrders", s.timeouts.Read, &err)
+	defer done()
 	sublog.Info().Msgf("Requesting orders for user %v", login)
 	orders := make([]models.Order, 0)
-	rows, err := s.conn.Query(context.Background(), getOrders, login)
+	rows, err := s.conn.Query(ctx, getOrders, login)
 	if err != nil {
 		sublog.Error().Err(err).Msg("")
 		return nil, err
@@ -163,7 +335,7 @@ func (s *Database) GetOrders(login string) ([]models.Order, error) {
 		order := models.Order{}
 		var number string
 		var status string
-		var accrual float32
+		var accrual models.Money
 		var upload time.Time
 		err = rows.Scan(&number, &status, &accrual, &upload)
 		if err != nil {
@@ -181,34 +353,88 @@ func (s *Database) GetOrders(login string) ([]models.Order, error) {
 	return orders, nil
 }
 
-func (s *Database) CreateOrder(order, user string) error {
+func (s *Database) CreateOrder(ctx context.Context, order, user string) (err error) {
+	ctx, done := s.operation(ctx, "CreateOrder", s.timeouts.Write, &err)
+	defer done()
 	sublog.Info().Msgf("Creating new order %v", order)
-	_, err := s.conn.Exec(context.Background(), createOrder, order, user, time.Now())
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	now := time.Now()
+	_, err = tx.Exec(ctx, createOrder, order, user, now)
 	if err != nil {
 		sublog.Info().Err(err).Msg("")
 		return err
 	}
+	_, err = tx.Exec(ctx, createJob, order, user, now, tracing.Parent(ctx))
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	err = recordStatus(ctx, tx, user, order, "NEW", 0, now)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
 	sublog.Info().Msgf("Order %v created", order)
 	return nil
 }
 
-func (s *Database) UpdateOrder(order, status string, accrual float32) error {
+func (s *Database) UpdateOrder(ctx context.Context, order, status string, accrual models.Money) (err error) {
+	ctx, done := s.operation(ctx, "UpdateOrder", s.timeouts.Write, &err)
+	defer done()
 	sublog.Debug().Msgf("Updating order %v with new status %v and accrual value %v", order, status, accrual)
-	_, err := s.conn.Exec(context.Background(), updateOrder, status, accrual, order)
+	tx, err := s.conn.Begin(ctx)
 	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	var login, old string
+	err = tx.QueryRow(ctx, lockOrder, order).Scan(&login, &old)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			sublog.Debug().Msgf("Order %v not found. Nothing to update", order)
+			return nil
+		}
 		sublog.Debug().Err(err).Msg("")
 		return err
 	}
+	_, err = tx.Exec(ctx, updateOrder, status, accrual, order)
+	if err != nil {
+		sublog.Debug().Err(err).Msg("")
+		return err
+	}
+	if old != status {
+		err = recordStatus(ctx, tx, login, order, status, accrual, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
 	sublog.Info().Msgf("Order %v updated", order)
 	return nil
 }
 
-func (s *Database) GetBalance(login string) (models.Balance, error) {
+func (s *Database) GetBalance(ctx context.Context, login string) (_ models.Balance, err error) {
+	ctx, done := s.operation(ctx, "GetBalance", s.timeouts.Read, &err)
+	defer done()
 	balance := models.Balance{}
-	var b float32
-	var w float32
+	var b models.Money
+	var w models.Money
 	sublog.Info().Msgf("Requesting balance for user %v", login)
-	err := s.conn.QueryRow(context.Background(), getBalance, login).Scan(&b, &w)
+	err = s.conn.QueryRow(ctx, getBalance, login).Scan(&b, &w)
 	if err != nil {
 		sublog.Error().Err(err).Msg("")
 		return balance, err
@@ -218,55 +444,239 @@ func (s *Database) GetBalance(login string) (models.Balance, error) {
 	return balance, nil
 }
 
-func (s *Database) UpdateBalance(login string, accrual float32) error {
+func (s *Database) UpdateBalance(ctx context.Context, login string, accrual models.Money) (err error) {
+	ctx, done := s.operation(ctx, "UpdateBalance", s.timeouts.Write, &err)
+	defer done()
 	sublog.Info().Msg("Updating balance")
 	sublog.Debug().Msgf("User is %v and delta is %v", login, accrual)
-	balance, err := s.GetBalance(login)
+	tx, err := s.conn.Begin(ctx)
 	if err != nil {
-		sublog.Error().Err(err).Msg("Error in get user balance request")
+		sublog.Error().Err(err).Msg("Error in begin transaction")
 		return err
 	}
-	log.Debug().Msgf("Old balance is %v", balance)
-	balance.Balance += accrual
-	if balance.Balance < 0 {
-		sublog.Error().Msg("Balance is not enough")
-		return errors.New("we need to build more ziggurats")
-	}
-	if accrual < 0 {
-		balance.Withdraws += float32(math.Abs(float64(accrual)))
+	defer rollback(tx)
+	err = changeBalance(ctx, tx, login, entryAdjustment, "", accrual)
+	if err != nil {
+		return err
 	}
-	sublog.Debug().Msgf("New balance is %v. New withdraws is %v", balance.Balance, balance.Withdraws)
-	_, err = s.conn.Exec(context.Background(), updateBalance, balance.Balance, balance.Withdraws, login)
+	err = tx.Commit(ctx)
 	if err != nil {
-		sublog.Error().Err(err).Msg("Error in update user balance request")
+		sublog.Error().Err(err).Msg("Error in commit transaction")
 		return err
 	}
 	sublog.Info().Msgf("User %v updated", login)
 	return nil
 }
 
-func (s *Database) CreateWithdraw(sum float32, login, order string) error {
+func (s *Database) AdjustBalance(ctx context.Context, login string, delta models.Money, event models.AuditEvent) (err error) {
+	ctx, done := s.operation(ctx, "AdjustBalance", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Adjusting balance of user %v by %v", login, delta)
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	err = changeBalance(ctx, tx, login, entryAdjustment, "", delta)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, createAuditEvent, auditArgs(event)...)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while writing audit event %v", event.Action)
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
+	sublog.Info().Msgf("Balance of user %v adjusted", login)
+	return nil
+}
+
+func (s *Database) CreateWithdraw(ctx context.Context, sum models.Money, login, order string) (err error) {
+	ctx, done := s.operation(ctx, "CreateWithdraw", s.timeouts.Write, &err)
+	defer done()
 	sublog.Info().Msg("Updating withdraw")
-	sublog.Debug().Msgf("User is %v. withdraw sum is %.2f for order %v", login, sum, order)
-	sum = sum * -1
-	err := s.UpdateBalance(login, sum)
+	sublog.Debug().Msgf("User is %v. withdraw sum is %v for order %v", login, sum, order)
+	if sum <= 0 {
+		sublog.Info().Msgf("Withdraw sum %v is not positive", sum)
+		return ErrInvalidSum
+	}
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	err = changeBalance(ctx, tx, login, entryWithdrawal, order, sum*-1)
 	if err != nil {
 		sublog.Info().Msg("Update balance failed")
 		return err
 	}
-	_, err = s.conn.Exec(context.Background(), createWithdraw, login, order, time.Now(), float32(math.Abs(float64(sum))))
+	now := time.Now()
+	_, err = tx.Exec(ctx, createWithdraw, login, order, now, sum)
 	if err != nil {
 		sublog.Error().Err(err).Msg("")
 		return err
 	}
+	err = enqueueWebhooks(ctx, tx, login, models.WebhookWithdrawal, withdrawPayload(order, sum, now), now)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
 	sublog.Info().Msg("Update orders withdraw complete")
 	return nil
 }
 
-func (s *Database) GetWithdraws(login string) ([]models.Withdraw, error) {
+func (s *Database) CompleteOrder(ctx context.Context, order, login, worker, status string, accrual models.Money) (_ bool, err error) {
+	ctx, done := s.operation(ctx, "CompleteOrder", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Completing order %v with status %v", order, status)
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return false, err
+	}
+	defer rollback(tx)
+	if worker != "" {
+		tag, err := tx.Exec(ctx, releaseJob, order, worker)
+		if err != nil {
+			sublog.Error().Err(err).Msg("")
+			return false, err
+		}
+		if tag.RowsAffected() == 0 {
+			sublog.Info().Msgf("Accrual job %v is not held by worker %v", order, worker)
+			return false, ErrLeaseLost
+		}
+	}
+	tag, err := tx.Exec(ctx, completeOrder, status, accrual, order, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return false, err
+	}
+	applied := tag.RowsAffected() != 0
+	if !applied {
+		sublog.Info().Msgf("Order %v already completed. Skipping accrual", order)
+	}
+	if applied {
+		err = recordStatus(ctx, tx, login, order, status, accrual, time.Now())
+		if err != nil {
+			return false, err
+		}
+	}
+	if applied && accrual > 0 {
+		err = changeBalance(ctx, tx, login, entryAccrual, order, accrual)
+		if err != nil {
+			return false, err
+		}
+	}
+	_, err = tx.Exec(ctx, deleteJob, order)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return false, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return false, err
+	}
+	sublog.Info().Msgf("Order %v completed", order)
+	return applied, nil
+}
+
+//changeBalance - posting the movement of user's points to the ledger inside the transaction.
+//Balance snapshot row stays locked until the transaction end, so movements of one user are serialized
+func changeBalance(ctx context.Context, tx pgx.Tx, login, kind, order string, delta models.Money) error {
+	var snapshot models.Money
+	err := tx.QueryRow(ctx, lockBalance, login).Scan(&snapshot)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in get user balance request")
+		return err
+	}
+	var balance models.Money
+	err = tx.QueryRow(ctx, ledgerBalance, login).Scan(&balance)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in get user ledger balance request")
+		return err
+	}
+	log.Debug().Msgf("Old balance is %v", balance)
+	if snapshot != balance {
+		sublog.Warn().Msgf("Balance snapshot %v of user %v differs from ledger balance %v", snapshot, login, balance)
+	}
+	if delta < 0 && balance+delta < 0 {
+		sublog.Error().Msg("Balance is not enough")
+		return ErrBalanceTooLow
+	}
+	err = appendEntry(ctx, tx, login, kind, order, delta)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = tx.Exec(ctx, createAuditEvent, auditArgs(ledgerEvent(login, kind, order, balance, delta, now))...)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error while writing ledger audit event")
+		return err
+	}
+	err = publishEvent(ctx, tx, balanceEvent(login, kind, order, balance+delta, delta, now))
+	if err != nil {
+		return err
+	}
+	if kind == entryWithdrawal {
+		_, err = tx.Exec(ctx, debitBalance, delta*-1, login)
+	} else {
+		_, err = tx.Exec(ctx, creditBalance, delta, login)
+	}
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in update user balance request")
+		return err
+	}
+	return nil
+}
+
+//appendEntry - writing the pair of ledger entries: delta to user's points account and -delta to the counter system account
+func appendEntry(ctx context.Context, tx pgx.Tx, login, kind, order string, delta models.Money) error {
+	var id int64
+	err := tx.QueryRow(ctx, nextTransaction).Scan(&id)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in ledger transaction id request")
+		return err
+	}
+	now := time.Now()
+	_, err = tx.Exec(ctx, createEntry, id, accountPoints, login, kind, order, delta, now)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in ledger entry insert")
+		return err
+	}
+	_, err = tx.Exec(ctx, createEntry, id, counterAccounts[kind], nil, kind, order, delta*-1, now)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in ledger entry insert")
+		return err
+	}
+	sublog.Debug().Msgf("Ledger transaction %v: %v %v for user %v", id, kind, delta, login)
+	return nil
+}
+
+//rollback - rolling back not committed transaction
+func rollback(tx pgx.Tx) {
+	err := tx.Rollback(context.Background())
+	if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+		sublog.Error().Err(err).Msg("Error in rollback transaction")
+	}
+}
+
+func (s *Database) GetWithdraws(ctx context.Context, login string) (_ []models.Withdraw, err error) {
+	ctx, done := s.operation(ctx, "GetWithdraws", s.timeouts.Read, &err)
+	defer done()
 	sublog.Info().Msgf("Requesting user's %v withdraws", login)
 	withdraws := make([]models.Withdraw, 0)
-	rows, err := s.conn.Query(context.Background(), getWithdraws, login)
+	rows, err := s.conn.Query(ctx, getWithdraws, login)
 	if err != nil {
 		sublog.Error().Err(err).Msg("")
 		return nil, err
@@ -276,7 +686,7 @@ func (s *Database) GetWithdraws(login string) ([]models.Withdraw, error) {
 		w := models.Withdraw{}
 		var number string
 		var processed time.Time
-		var withdraw float32
+		var withdraw models.Money
 		err = rows.Scan(&number, &withdraw, &processed)
 		if err != nil {
 			sublog.Error().Err(err).Msg("Error while reading rows")
@@ -294,8 +704,91 @@ func (s *Database) GetWithdraws(login string) ([]models.Withdraw, error) {
 	return withdraws, nil
 }
 
+func (s *Database) GetLedger(ctx context.Context, login string) (_ []models.LedgerEntry, err error) {
+	ctx, done := s.operation(ctx, "GetLedger", s.timeouts.Read, &err)
+	defer done()
+	sublog.Info().Msgf("Requesting user's %v ledger", login)
+	entries := make([]models.LedgerEntry, 0)
+	rows, err := s.conn.Query(ctx, getLedger, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := models.LedgerEntry{}
+		err = rows.Scan(&e.Kind, &e.Order, &e.Amount, &e.Balance, &e.Created)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sublog.Debug().Msgf("Result ledger slice: %v", entries)
+	return entries, nil
+}
+
+//ClaimJobs - claiming up to limit due accrual jobs. Rows locked by other workers are skipped, so every job is claimed once per lease
+func (s *Database) ClaimJobs(ctx context.Context, worker string, limit int, lease time.Duration) (_ []models.Job, err error) {
+	ctx, done := s.operation(ctx, "ClaimJobs", s.timeouts.Write, &err)
+	defer done()
+	now := time.Now()
+	rows, err := s.conn.Query(ctx, claimJobs, worker, now.Add(lease), now, limit)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := make([]models.Job, 0)
+	for rows.Next() {
+		job := models.Job{}
+		err = rows.Scan(&job.Order, &job.User, &job.Attempts, &job.Trace)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if len(jobs) > 0 {
+		sublog.Debug().Msgf("Worker %v claimed %v accrual jobs", worker, len(jobs))
+	}
+	return jobs, rows.Err()
+}
+
+//RescheduleJob - releasing accrual job claimed by the worker until delay passes
+func (s *Database) RescheduleJob(ctx context.Context, order, worker string, delay time.Duration) (err error) {
+	ctx, done := s.operation(ctx, "RescheduleJob", s.timeouts.Write, &err)
+	defer done()
+	tag, err := s.conn.Exec(ctx, rescheduleJob, time.Now().Add(delay), order, worker)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		sublog.Info().Msgf("Accrual job %v is not held by worker %v", order, worker)
+		return ErrLeaseLost
+	}
+	sublog.Debug().Msgf("Accrual job %v rescheduled in %v", order, delay)
+	return nil
+}
+
+//Stat - statistics of the connection pool
+func (s *Database) Stat() *pgxpool.Stat {
+	return s.conn.Stat()
+}
+
+//Close - closing all connections of the pool. Waits for acquired connections to be released
+func (s *Database) Close() {
+	sublog.Info().Msg("Closing database connections")
+	s.conn.Close()
+}
+
 func (s *Database) DeleteContent(table string) error {
-	_, err := s.conn.Exec(context.Background(), fmt.Sprintf("DELETE from \"%s\"", table))
+	query := fmt.Sprintf("DELETE from \"%s\"", table)
+	if table == "audit_events" || table == "ledger" {
+		query = fmt.Sprintf("TRUNCATE public.%s", table) //Audit events and ledger are append-only. Trigger refuses deleting
+	}
+	_, err := s.conn.Exec(context.Background(), query)
 	if err != nil {
 		sublog.Error().Err(err).Msgf("Error while cleaning table %s", table)
 		return err
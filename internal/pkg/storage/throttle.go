@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//Postgres
+
+const (
+	getThrottle    = `SELECT "failures", "last_failure_at", "locked_until" FROM public.login_attempts WHERE "scope" = $1 AND "key" = $2`
+	recordFailure  = `INSERT INTO public.login_attempts AS a ("scope", "key", "failures", "last_failure_at") VALUES ($1,$2,1,$3) ON CONFLICT ("scope", "key") DO UPDATE SET "failures" = CASE WHEN a."last_failure_at" < $4 THEN 1 ELSE a."failures" + 1 END, "locked_until" = CASE WHEN a."last_failure_at" < $4 THEN NULL ELSE a."locked_until" END, "last_failure_at" = $3 RETURNING "failures", "last_failure_at", "locked_until"`
+	lockAttempts   = `SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2))`
+	releaseAttempt = `UPDATE public.login_attempts SET "failures" = GREATEST("failures" - 1, 0) WHERE "scope" = $1 AND "key" = $2`
+	lockThrottle   = `UPDATE public.login_attempts SET "locked_until" = $1 WHERE "scope" = $2 AND "key" = $3`
+	deleteThrottle = `DELETE FROM public.login_attempts WHERE "scope" = $1 AND "key" = $2`
+)
+
+func (s *Database) GetThrottle(ctx context.Context, scope, key string) (_ models.LoginThrottle, err error) {
+	ctx, done := s.operation(ctx, "GetThrottle", s.timeouts.Read, &err)
+	defer done()
+	throttle := models.LoginThrottle{Scope: scope, Key: key}
+	var locked *time.Time
+	err = s.conn.QueryRow(ctx, getThrottle, scope, key).Scan(&throttle.Failures, &throttle.LastFailure, &locked)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			sublog.Error().Err(err).Msg("")
+		}
+		return models.LoginThrottle{}, err
+	}
+	if locked != nil {
+		throttle.LockedUntil = *locked
+	}
+	return throttle, nil
+}
+
+//RecordFailure - counting failed login attempt. Counter starts again if previous failure is older than window
+func (s *Database) RecordFailure(ctx context.Context, scope, key string, at time.Time, window time.Duration) (_ models.LoginThrottle, err error) {
+	ctx, done := s.operation(ctx, "RecordFailure", s.timeouts.Write, &err)
+	defer done()
+	throttle := models.LoginThrottle{Scope: scope, Key: key}
+	var locked *time.Time
+	err = s.conn.QueryRow(ctx, recordFailure, scope, key, at, at.Add(-window)).Scan(&throttle.Failures, &throttle.LastFailure, &locked)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return models.LoginThrottle{}, err
+	}
+	if locked != nil {
+		throttle.LockedUntil = *locked
+	}
+	sublog.Debug().Msgf("Failed login attempt %v for %v %v", throttle.Failures, scope, key)
+	return throttle, nil
+}
+
+//ReserveAttempt - checking that the attempt is allowed and counting it as failed in one step. Attempts of the same account or address are serialized
+//by transaction advisory lock, so parallel attempts see failures of each other. Refused attempt is not counted and its wait is returned
+func (s *Database) ReserveAttempt(ctx context.Context, scope, key string, at time.Time, window time.Duration) (_ models.LoginThrottle, _ time.Duration, err error) {
+	ctx, done := s.operation(ctx, "ReserveAttempt", s.timeouts.Write, &err)
+	defer done()
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return models.LoginThrottle{}, 0, err
+	}
+	defer rollback(tx)
+	_, err = tx.Exec(ctx, lockAttempts, scope, key)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return models.LoginThrottle{}, 0, err
+	}
+	throttle := models.LoginThrottle{Scope: scope, Key: key}
+	var locked *time.Time
+	err = tx.QueryRow(ctx, getThrottle, scope, key).Scan(&throttle.Failures, &throttle.LastFailure, &locked)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		sublog.Error().Err(err).Msg("")
+		return models.LoginThrottle{}, 0, err
+	}
+	if locked != nil {
+		throttle.LockedUntil = *locked
+	}
+	if wait := throttle.Wait(at); wait > 0 {
+		return throttle, wait, nil
+	}
+	locked = nil
+	err = tx.QueryRow(ctx, recordFailure, scope, key, at, at.Add(-window)).Scan(&throttle.Failures, &throttle.LastFailure, &locked)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return models.LoginThrottle{}, 0, err
+	}
+	if locked != nil {
+		throttle.LockedUntil = *locked
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return models.LoginThrottle{}, 0, err
+	}
+	sublog.Debug().Msgf("Login attempt %v reserved for %v %v", throttle.Failures, scope, key)
+	return throttle, 0, nil
+}
+
+//ReleaseAttempt - uncounting reserved attempt which turned out not to be failed
+func (s *Database) ReleaseAttempt(ctx context.Context, scope, key string) (err error) {
+	ctx, done := s.operation(ctx, "ReleaseAttempt", s.timeouts.Write, &err)
+	defer done()
+	_, err = s.conn.Exec(ctx, releaseAttempt, scope, key)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+func (s *Database) LockThrottle(ctx context.Context, scope, key string, until time.Time) (err error) {
+	ctx, done := s.operation(ctx, "LockThrottle", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Locking login for %v %v until %v", scope, key, until.Format(time.RFC3339))
+	_, err = s.conn.Exec(ctx, lockThrottle, until, scope, key)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+func (s *Database) ResetThrottle(ctx context.Context, scope, key string) (err error) {
+	ctx, done := s.operation(ctx, "ResetThrottle", s.timeouts.Write, &err)
+	defer done()
+	_, err = s.conn.Exec(ctx, deleteThrottle, scope, key)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+//In-memory
+
+//throttleKey - key of throttle map
+func throttleKey(scope, key string) string {
+	return scope + ":" + key
+}
+
+func (s *Memory) GetThrottle(ctx context.Context, scope, key string) (models.LoginThrottle, error) {
+	if err := alive(ctx); err != nil {
+		return models.LoginThrottle{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	throttle, ok := s.throttles[throttleKey(scope, key)]
+	if !ok {
+		return models.LoginThrottle{}, pgx.ErrNoRows
+	}
+	return throttle, nil
+}
+
+func (s *Memory) RecordFailure(ctx context.Context, scope, key string, at time.Time, window time.Duration) (models.LoginThrottle, error) {
+	if err := alive(ctx); err != nil {
+		return models.LoginThrottle{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	throttle, ok := s.throttles[throttleKey(scope, key)]
+	if !ok || throttle.LastFailure.Before(at.Add(-window)) {
+		throttle = models.LoginThrottle{Scope: scope, Key: key}
+	}
+	throttle.Failures++
+	throttle.LastFailure = at
+	s.throttles[throttleKey(scope, key)] = throttle
+	sublog.Debug().Msgf("Failed login attempt %v for %v %v", throttle.Failures, scope, key)
+	return throttle, nil
+}
+
+func (s *Memory) ReserveAttempt(ctx context.Context, scope, key string, at time.Time, window time.Duration) (models.LoginThrottle, time.Duration, error) {
+	if err := alive(ctx); err != nil {
+		return models.LoginThrottle{}, 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	throttle, ok := s.throttles[throttleKey(scope, key)]
+	if !ok {
+		throttle = models.LoginThrottle{Scope: scope, Key: key}
+	}
+	if wait := throttle.Wait(at); wait > 0 {
+		return throttle, wait, nil
+	}
+	if throttle.LastFailure.Before(at.Add(-window)) {
+		throttle = models.LoginThrottle{Scope: scope, Key: key}
+	}
+	throttle.Failures++
+	throttle.LastFailure = at
+	s.throttles[throttleKey(scope, key)] = throttle
+	sublog.Debug().Msgf("Login attempt %v reserved for %v %v", throttle.Failures, scope, key)
+	return throttle, 0, nil
+}
+
+func (s *Memory) ReleaseAttempt(ctx context.Context, scope, key string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	throttle, ok := s.throttles[throttleKey(scope, key)]
+	if !ok || throttle.Failures == 0 {
+		return nil
+	}
+	throttle.Failures--
+	s.throttles[throttleKey(scope, key)] = throttle
+	return nil
+}
+
+func (s *Memory) LockThrottle(ctx context.Context, scope, key string, until time.Time) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Locking login for %v %v until %v", scope, key, until.Format(time.RFC3339))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	throttle, ok := s.throttles[throttleKey(scope, key)]
+	if !ok {
+		return nil
+	}
+	throttle.LockedUntil = until
+	s.throttles[throttleKey(scope, key)] = throttle
+	return nil
+}
+
+func (s *Memory) ResetThrottle(ctx context.Context, scope, key string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.throttles, throttleKey(scope, key))
+	return nil
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//memoryRecoveryCode - recovery code with usage time
+type memoryRecoveryCode struct {
+	name string
+	used time.Time
+}
+
+//Postgres
+
+const (
+	setTOTP             = `UPDATE public.users SET "totp_secret" = $1 WHERE "name" = $2 AND NOT "totp_enabled"`
+	enableTOTP          = `UPDATE public.users SET "totp_enabled" = true WHERE "name" = $1 AND "totp_secret" <> '' AND NOT "totp_enabled"`
+	disableTOTP         = `UPDATE public.users SET "totp_secret" = '', "totp_enabled" = false, "totp_step" = 0 WHERE "name" = $1`
+	useTOTPStep         = `UPDATE public.users SET "totp_step" = $1 WHERE "name" = $2 AND "totp_step" < $1`
+	deleteRecoveryCodes = `DELETE FROM public.recovery_codes WHERE "name" = $1`
+	createRecoveryCode  = `INSERT INTO public.recovery_codes ("name", "hash") VALUES ($1,$2)`
+	useRecoveryCode     = `UPDATE public.recovery_codes SET "used_at" = $1 WHERE "name" = $2 AND "hash" = $3 AND "used_at" IS NULL`
+)
+
+func (s *Database) SetTOTP(ctx context.Context, login, secret string) (err error) {
+	ctx, done := s.operation(ctx, "SetTOTP", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Starting 2FA enrolment of user %v", login)
+	tag, err := s.conn.Exec(ctx, setTOTP, secret, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Database) EnableTOTP(ctx context.Context, login string, step int64, codes []string) (err error) {
+	ctx, done := s.operation(ctx, "EnableTOTP", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Enabling 2FA of user %v", login)
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	tag, err := tx.Exec(ctx, useTOTPStep, step, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		sublog.Info().Msgf("TOTP code of user %v already used", login)
+		return pgx.ErrNoRows
+	}
+	tag, err = tx.Exec(ctx, enableTOTP, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	_, err = tx.Exec(ctx, deleteRecoveryCodes, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	for _, code := range codes {
+		_, err = tx.Exec(ctx, createRecoveryCode, login, code)
+		if err != nil {
+			sublog.Error().Err(err).Msg("")
+			return err
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
+	return nil
+}
+
+func (s *Database) DisableTOTP(ctx context.Context, login string) (err error) {
+	ctx, done := s.operation(ctx, "DisableTOTP", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Disabling 2FA of user %v", login)
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in begin transaction")
+		return err
+	}
+	defer rollback(tx)
+	_, err = tx.Exec(ctx, disableTOTP, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	_, err = tx.Exec(ctx, deleteRecoveryCodes, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in commit transaction")
+		return err
+	}
+	return nil
+}
+
+func (s *Database) UseTOTPStep(ctx context.Context, login string, step int64) (err error) {
+	ctx, done := s.operation(ctx, "UseTOTPStep", s.timeouts.Write, &err)
+	defer done()
+	tag, err := s.conn.Exec(ctx, useTOTPStep, step, login)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		sublog.Info().Msgf("TOTP code of user %v already used", login)
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Database) UseRecoveryCode(ctx context.Context, login, hash string) (err error) {
+	ctx, done := s.operation(ctx, "UseRecoveryCode", s.timeouts.Write, &err)
+	defer done()
+	tag, err := s.conn.Exec(ctx, useRecoveryCode, time.Now(), login, hash)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	sublog.Info().Msgf("Recovery code of user %v used", login)
+	return nil
+}
+
+//In-memory
+
+func (s *Memory) SetTOTP(ctx context.Context, login, secret string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Starting 2FA enrolment of user %v", login)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[login]
+	if !ok || user.TOTPEnabled {
+		return pgx.ErrNoRows
+	}
+	user.TOTPSecret = secret
+	s.users[login] = user
+	return nil
+}
+
+func (s *Memory) EnableTOTP(ctx context.Context, login string, step int64, codes []string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Enabling 2FA of user %v", login)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[login]
+	if !ok || user.TOTPEnabled || user.TOTPSecret == "" {
+		return pgx.ErrNoRows
+	}
+	if s.totpSteps[login] >= step {
+		sublog.Info().Msgf("TOTP code of user %v already used", login)
+		return pgx.ErrNoRows
+	}
+	s.totpSteps[login] = step
+	user.TOTPEnabled = true
+	s.users[login] = user
+	s.deleteRecoveryCodes(login)
+	for _, code := range codes {
+		s.recovery[login+":"+code] = memoryRecoveryCode{name: login}
+	}
+	return nil
+}
+
+func (s *Memory) DisableTOTP(ctx context.Context, login string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Disabling 2FA of user %v", login)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[login]
+	if !ok {
+		return nil
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	s.users[login] = user
+	delete(s.totpSteps, login)
+	s.deleteRecoveryCodes(login)
+	return nil
+}
+
+func (s *Memory) UseTOTPStep(ctx context.Context, login string, step int64) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[login]; !ok || s.totpSteps[login] >= step {
+		sublog.Info().Msgf("TOTP code of user %v already used", login)
+		return pgx.ErrNoRows
+	}
+	s.totpSteps[login] = step
+	return nil
+}
+
+func (s *Memory) UseRecoveryCode(ctx context.Context, login, hash string) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.recovery[login+":"+hash]
+	if !ok || !code.used.IsZero() {
+		return pgx.ErrNoRows
+	}
+	code.used = time.Now()
+	s.recovery[login+":"+hash] = code
+	sublog.Info().Msgf("Recovery code of user %v used", login)
+	return nil
+}
+
+//deleteRecoveryCodes - removing all user's recovery codes. Caller must hold the lock
+func (s *Memory) deleteRecoveryCodes(login string) {
+	for key, code := range s.recovery {
+		if code.name == login {
+			delete(s.recovery, key)
+		}
+	}
+}
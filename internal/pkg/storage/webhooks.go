@@ -0,0 +1,435 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//orderWebhook - webhook event of order status. Only final statuses are delivered to subscriptions
+func orderWebhook(status string) string {
+	switch status {
+	case "PROCESSED":
+		return models.WebhookOrderProcessed
+	case "INVALID":
+		return models.WebhookOrderInvalid
+	}
+	return ""
+}
+
+//orderPayload - webhook data of order status change
+func orderPayload(order, status string, accrual models.Money, at time.Time) json.RawMessage {
+	data, _ := json.Marshal(map[string]interface{}{"number": order, "status": status, "accrual": accrual, "changed_at": at})
+	return data
+}
+
+//withdrawPayload - webhook data of withdraw
+func withdrawPayload(order string, sum models.Money, at time.Time) json.RawMessage {
+	data, _ := json.Marshal(map[string]interface{}{"order": order, "sum": sum, "processed_at": at})
+	return data
+}
+
+//subscribed - checking that subscription receives the event of the user
+func subscribed(hook models.Webhook, login, event string) bool {
+	if hook.Owner != "" && hook.Owner != login {
+		return false
+	}
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+//Postgres
+
+const (
+	createWebhook     = `INSERT INTO public.webhooks ("owner", "url", "secret", "events", "created_at") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`
+	getWebhooks       = `SELECT "id", COALESCE("owner", ''), "url", "events", "created_at" FROM public.webhooks WHERE "owner" IS NOT DISTINCT FROM NULLIF($1, '') ORDER BY "id"`
+	getWebhook        = `SELECT "id", COALESCE("owner", ''), "url", "events", "created_at" FROM public.webhooks WHERE "id" = $1`
+	deleteWebhook     = `DELETE FROM public.webhooks WHERE "id" = $1`
+	enqueueWebhook    = `INSERT INTO public.webhook_deliveries ("webhook_id", "name", "event", "payload", "next_attempt_at", "created_at") SELECT "id", $1, $2, $3, $4, $4 FROM public.webhooks WHERE ("owner" IS NULL OR "owner" = $1) AND $2 = ANY("events")`
+	getDeliveries     = `SELECT "id", "webhook_id", "name", "event", "payload", "status", "attempts", "next_attempt_at", "last_error", "created_at" FROM public.webhook_deliveries WHERE "webhook_id" = $1 AND ($2 = '' OR "status" = $2) ORDER BY "id" DESC LIMIT $3`
+	getDelivery       = `SELECT "id", "webhook_id", "name", "event", "payload", "status", "attempts", "next_attempt_at", "last_error", "created_at" FROM public.webhook_deliveries WHERE "id" = $1`
+	claimDeliveries   = `UPDATE public.webhook_deliveries d SET "attempts" = d."attempts" + 1, "next_attempt_at" = $1 FROM public.webhooks w WHERE w."id" = d."webhook_id" AND d."id" IN (SELECT "id" FROM public.webhook_deliveries WHERE "status" = 'PENDING' AND "next_attempt_at" <= $2 ORDER BY "next_attempt_at" LIMIT $3 FOR UPDATE SKIP LOCKED) RETURNING d."id", d."webhook_id", d."name", d."event", d."payload", d."status", d."attempts", d."next_attempt_at", d."last_error", d."created_at", w."url", w."secret"`
+	updateDelivery    = `UPDATE public.webhook_deliveries SET "status" = $1, "next_attempt_at" = $2, "last_error" = $3 WHERE "id" = $4 AND "attempts" = $5 AND "next_attempt_at" = $6`
+	redeliverDelivery = `UPDATE public.webhook_deliveries SET "status" = 'PENDING', "attempts" = 0, "next_attempt_at" = $1, "last_error" = '' WHERE "id" = $2`
+)
+
+//enqueueWebhooks - writing event to the outbox of every matching subscription inside the transaction. Deliveries become visible to workers on commit
+func enqueueWebhooks(ctx context.Context, tx pgx.Tx, login, event string, payload json.RawMessage, at time.Time) error {
+	_, err := tx.Exec(ctx, enqueueWebhook, login, event, []byte(payload), at)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while enqueueing webhook %v of user %v", event, login)
+		return err
+	}
+	return nil
+}
+
+//scanDelivery - reading webhook delivery from the row
+func scanDelivery(row pgx.Row, dest ...interface{}) (models.WebhookDelivery, error) {
+	d := models.WebhookDelivery{}
+	var payload []byte
+	fields := []interface{}{&d.ID, &d.Webhook, &d.User, &d.Event, &payload, &d.Status, &d.Attempts, &d.Next, &d.LastError, &d.Created}
+	err := row.Scan(append(fields, dest...)...)
+	d.Payload = payload
+	return d, err
+}
+
+func (s *Database) CreateWebhook(ctx context.Context, hook models.Webhook) (_ models.Webhook, err error) {
+	ctx, done := s.operation(ctx, "CreateWebhook", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Creating webhook of user %q to %v", hook.Owner, hook.URL)
+	var owner interface{}
+	if hook.Owner != "" {
+		owner = hook.Owner
+	}
+	hook.Created = time.Now()
+	err = s.conn.QueryRow(ctx, createWebhook, owner, hook.URL, hook.Secret, hook.Events, hook.Created).Scan(&hook.ID)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return hook, err
+	}
+	return hook, nil
+}
+
+func (s *Database) GetWebhooks(ctx context.Context, owner string) (_ []models.Webhook, err error) {
+	ctx, done := s.operation(ctx, "GetWebhooks", s.timeouts.Read, &err)
+	defer done()
+	rows, err := s.conn.Query(ctx, getWebhooks, owner)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := make([]models.Webhook, 0)
+	for rows.Next() {
+		hook := models.Webhook{}
+		err = rows.Scan(&hook.ID, &hook.Owner, &hook.URL, &hook.Events, &hook.Created)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *Database) GetWebhook(ctx context.Context, id int64) (_ models.Webhook, err error) {
+	ctx, done := s.operation(ctx, "GetWebhook", s.timeouts.Read, &err)
+	defer done()
+	hook := models.Webhook{}
+	err = s.conn.QueryRow(ctx, getWebhook, id).Scan(&hook.ID, &hook.Owner, &hook.URL, &hook.Events, &hook.Created)
+	if err != nil {
+		sublog.Info().Err(err).Msgf("Webhook %v not found", id)
+		return hook, err
+	}
+	return hook, nil
+}
+
+func (s *Database) DeleteWebhook(ctx context.Context, id int64) (err error) {
+	ctx, done := s.operation(ctx, "DeleteWebhook", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Deleting webhook %v", id)
+	tag, err := s.conn.Exec(ctx, deleteWebhook, id)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Database) GetDeliveries(ctx context.Context, webhook int64, status string, limit int) (_ []models.WebhookDelivery, err error) {
+	ctx, done := s.operation(ctx, "GetDeliveries", s.timeouts.Read, &err)
+	defer done()
+	rows, err := s.conn.Query(ctx, getDeliveries, webhook, status, limit)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *Database) GetDelivery(ctx context.Context, id int64) (_ models.WebhookDelivery, err error) {
+	ctx, done := s.operation(ctx, "GetDelivery", s.timeouts.Read, &err)
+	defer done()
+	d, err := scanDelivery(s.conn.QueryRow(ctx, getDelivery, id))
+	if err != nil {
+		sublog.Info().Err(err).Msgf("Webhook delivery %v not found", id)
+		return d, err
+	}
+	return d, nil
+}
+
+//ClaimDeliveries - claiming up to limit due deliveries. Next attempt is postponed by lease, so crashed worker's deliveries are retried by others
+func (s *Database) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) (_ []models.WebhookDelivery, err error) {
+	ctx, done := s.operation(ctx, "ClaimDeliveries", s.timeouts.Write, &err)
+	defer done()
+	now := time.Now()
+	rows, err := s.conn.Query(ctx, claimDeliveries, now.Add(lease), now, limit)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var url, secret string
+		d, err := scanDelivery(rows, &url, &secret)
+		if err != nil {
+			sublog.Error().Err(err).Msg("Error while reading rows")
+			return nil, err
+		}
+		d.URL = url
+		d.Secret = secret
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+//UpdateDelivery - storing result of the delivery. Result is dropped if the delivery was claimed again after lease expired or returned to the queue
+func (s *Database) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery, claimed time.Time) (err error) {
+	ctx, done := s.operation(ctx, "UpdateDelivery", s.timeouts.Write, &err)
+	defer done()
+	tag, err := s.conn.Exec(ctx, updateDelivery, delivery.Status, delivery.Next, delivery.LastError, delivery.ID, delivery.Attempts, claimed)
+	if err != nil {
+		sublog.Error().Err(err).Msgf("Error while updating webhook delivery %v", delivery.ID)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		sublog.Info().Msgf("Webhook delivery %v was claimed again", delivery.ID)
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *Database) RedeliverDelivery(ctx context.Context, id int64) (err error) {
+	ctx, done := s.operation(ctx, "RedeliverDelivery", s.timeouts.Write, &err)
+	defer done()
+	sublog.Info().Msgf("Returning webhook delivery %v to the queue", id)
+	tag, err := s.conn.Exec(ctx, redeliverDelivery, time.Now(), id)
+	if err != nil {
+		sublog.Error().Err(err).Msg("")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+//In-memory
+
+//enqueueWebhooks - writing event to the outbox of every matching subscription. Caller must hold the write lock
+func (s *Memory) enqueueWebhooks(login, event string, payload json.RawMessage, at time.Time) {
+	for _, hook := range s.webhooks {
+		if !subscribed(hook, login, event) {
+			continue
+		}
+		s.lastDelivery++
+		s.deliveries[s.lastDelivery] = models.WebhookDelivery{
+			ID:      s.lastDelivery,
+			Webhook: hook.ID,
+			User:    login,
+			Event:   event,
+			Payload: payload,
+			Status:  models.DeliveryPending,
+			Next:    at,
+			Created: at,
+		}
+	}
+}
+
+func (s *Memory) CreateWebhook(ctx context.Context, hook models.Webhook) (models.Webhook, error) {
+	if err := alive(ctx); err != nil {
+		return models.Webhook{}, err
+	}
+	sublog.Info().Msgf("Creating webhook of user %q to %v", hook.Owner, hook.URL)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[hook.Owner]; hook.Owner != "" && !ok {
+		err := foreignKeyViolation("webhooks", "webhooks_owner_fk")
+		sublog.Error().Err(err).Msg("")
+		return hook, err
+	}
+	s.lastWebhook++
+	hook.ID = s.lastWebhook
+	hook.Created = time.Now()
+	s.webhooks[hook.ID] = hook
+	return hook, nil
+}
+
+func (s *Memory) GetWebhooks(ctx context.Context, owner string) ([]models.Webhook, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hooks := make([]models.Webhook, 0)
+	for _, hook := range s.webhooks {
+		if hook.Owner == owner {
+			hook.Secret = ""
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].ID < hooks[j].ID
+	})
+	return hooks, nil
+}
+
+func (s *Memory) GetWebhook(ctx context.Context, id int64) (models.Webhook, error) {
+	if err := alive(ctx); err != nil {
+		return models.Webhook{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hook, ok := s.webhooks[id]
+	if !ok {
+		return models.Webhook{}, pgx.ErrNoRows
+	}
+	hook.Secret = ""
+	return hook, nil
+}
+
+func (s *Memory) DeleteWebhook(ctx context.Context, id int64) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Deleting webhook %v", id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[id]; !ok {
+		return pgx.ErrNoRows
+	}
+	delete(s.webhooks, id)
+	for i, d := range s.deliveries {
+		if d.Webhook == id {
+			delete(s.deliveries, i)
+		}
+	}
+	return nil
+}
+
+func (s *Memory) GetDeliveries(ctx context.Context, webhook int64, status string, limit int) ([]models.WebhookDelivery, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	deliveries := make([]models.WebhookDelivery, 0)
+	for _, d := range s.deliveries {
+		if d.Webhook == webhook && (status == "" || d.Status == status) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (s *Memory) GetDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	if err := alive(ctx); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return models.WebhookDelivery{}, pgx.ErrNoRows
+	}
+	return d, nil
+}
+
+//ClaimDeliveries - claiming up to limit due deliveries. Next attempt is postponed by lease
+func (s *Memory) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	if err := alive(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	due := make([]models.WebhookDelivery, 0)
+	for _, d := range s.deliveries {
+		if d.Status == models.DeliveryPending && !d.Next.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Next.Before(due[j].Next)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i, d := range due {
+		d.Attempts++
+		d.Next = now.Add(lease)
+		s.deliveries[d.ID] = d
+		hook := s.webhooks[d.Webhook]
+		d.URL = hook.URL
+		d.Secret = hook.Secret
+		due[i] = d
+	}
+	return due, nil
+}
+
+//UpdateDelivery - storing result of the delivery. Result is dropped if the delivery was claimed again after lease expired or returned to the queue
+func (s *Memory) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery, claimed time.Time) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[delivery.ID]
+	if !ok || d.Attempts != delivery.Attempts || !d.Next.Equal(claimed) {
+		sublog.Info().Msgf("Webhook delivery %v was claimed again", delivery.ID)
+		return ErrLeaseLost
+	}
+	d.Status = delivery.Status
+	d.Next = delivery.Next
+	d.LastError = delivery.LastError
+	s.deliveries[d.ID] = d
+	return nil
+}
+
+func (s *Memory) RedeliverDelivery(ctx context.Context, id int64) error {
+	if err := alive(ctx); err != nil {
+		return err
+	}
+	sublog.Info().Msgf("Returning webhook delivery %v to the queue", id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	d.Status = models.DeliveryPending
+	d.Attempts = 0
+	d.Next = time.Now()
+	d.LastError = ""
+	s.deliveries[id] = d
+	return nil
+}
@@ -0,0 +1,105 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+//RFC 6238 parameters. Authenticator applications use them by default
+const (
+	Period = 30 * time.Second //Time step
+	Digits = 6                //Code length
+	Skew   = 1                //Count of time steps before and after the current one accepted for clock drift
+)
+
+//ErrInvalidSecret - secret is not valid base32 string
+var ErrInvalidSecret = errors.New("totp secret is invalid")
+
+var sublog = log.With().Str("component", "totp").Logger()
+
+//encoding - base32 without padding as expected by authenticator applications
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//NewSecret - generating random 160-bit secret in base32
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in random generator")
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+//URI - building otpauth URI for enrolment in authenticator application
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+//Step - number of the time step containing t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+//Code - calculating code of the time step
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSecret, err)
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+//Validate - checking the code at time t with allowed clock drift. Returns matched time step to prevent code reuse
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			sublog.Error().Err(err).Msg("")
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
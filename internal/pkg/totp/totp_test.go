@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+//rfcSecret - secret of RFC 6238 SHA1 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+	_, err := Code("not base32!", 1)
+	require.ErrorIs(t, err, ErrInvalidSecret)
+}
+
+func TestValidate(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	now := time.Unix(1234567890, 0)
+	tests := []struct {
+		name string
+		at   time.Time
+		code string
+		want bool
+	}{
+		{name: "Current step", at: now, code: "005924", want: true},
+		{name: "Previous step", at: now.Add(Period), code: "005924", want: true},
+		{name: "Next step", at: now.Add(-Period), code: "005924", want: true},
+		{name: "Too old", at: now.Add(2 * Period), code: "005924"},
+		{name: "Wrong code", at: now, code: "123456"},
+		{name: "Wrong length", at: now, code: "5924"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, tt.at)
+			require.Equal(t, tt.want, ok)
+			if ok {
+				require.Equal(t, Step(now), step)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	secret, err := NewSecret()
+	require.NoError(t, err)
+	u, err := url.Parse(URI("Gophermart", "user1", secret))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/Gophermart:user1", u.Path)
+	require.Equal(t, secret, u.Query().Get("secret"))
+	require.Equal(t, "Gophermart", u.Query().Get("issuer"))
+}
@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+)
+
+//Span exporters
+const (
+	ExporterNone   = "none"   //Spans are not recorded
+	ExporterStdout = "stdout" //Spans are written to stdout as JSON
+	ExporterOTLP   = "otlp"   //Spans are sent to OTLP/HTTP collector
+)
+
+//ServiceName - service name of exported spans
+const ServiceName = "gophermart"
+
+//traceparent - W3C trace context header
+const traceparent = "traceparent"
+
+var sublog = log.With().Str("component", "tracing").Logger()
+
+//propagator - W3C trace context and baggage propagation used for incoming and outgoing requests
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+//stdout - output of stdout exporter
+var stdout io.Writer = os.Stdout
+
+//Setup - configuring global tracer provider and propagator. Returned function flushes recorded spans and stops the exporter
+func Setup(cfg *config.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	exporter, err := newExporter(cfg)
+	if err != nil || exporter == nil {
+		return func(context.Context) error { return nil }, err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName))
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	sublog.Info().Msgf("Tracing enabled. Spans are exported to %v", cfg.TraceExporter)
+	return provider.Shutdown, nil
+}
+
+//newExporter - creating span exporter selected by TRACE_EXPORTER. Returns nil if tracing is disabled
+func newExporter(cfg *config.Config) (sdktrace.SpanExporter, error) {
+	switch cfg.TraceExporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case ExporterOTLP:
+		endpoint, err := url.Parse(cfg.TraceEndpoint)
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid trace endpoint %q", cfg.TraceEndpoint)
+		}
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint.Host)}
+		if endpoint.Scheme == "http" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if endpoint.Path != "" && endpoint.Path != "/" {
+			opts = append(opts, otlptracehttp.WithURLPath(endpoint.Path))
+		}
+		return otlptracehttp.New(context.Background(), opts...)
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", cfg.TraceExporter)
+}
+
+//Inject - writing trace context of ctx into headers of outgoing request
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+//Extract - reading trace context of incoming request
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+//Parent - W3C traceparent of the span in ctx. Empty if ctx has no span
+func Parent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier[traceparent]
+}
+
+//Link - link to the span stored by Parent. Empty or invalid traceparent gives no links
+func Link(parent string) []trace.Link {
+	if parent == "" {
+		return nil
+	}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{traceparent: parent})
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		sublog.Debug().Msgf("Invalid traceparent %q", parent)
+		return nil
+	}
+	return []trace.Link{{SpanContext: sc}}
+}
+
+//Detach - context without deadline and cancellation of ctx keeping its span. Used for writes which must survive shutdown
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
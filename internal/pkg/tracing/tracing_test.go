@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+)
+
+func TestSetup(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{name: "Disabled", cfg: config.Config{TraceExporter: ExporterNone}},
+		{name: "Default", cfg: config.Config{}},
+		{name: "Stdout", cfg: config.Config{TraceExporter: ExporterStdout}},
+		{name: "OTLP", cfg: config.Config{TraceExporter: ExporterOTLP, TraceEndpoint: "http://127.0.0.1:4318"}},
+		{name: "OTLP with path", cfg: config.Config{TraceExporter: ExporterOTLP, TraceEndpoint: "https://collector.local/otlp/v1/traces"}},
+		{name: "OTLP without host", cfg: config.Config{TraceExporter: ExporterOTLP, TraceEndpoint: "127.0.0.1:4318"}, wantErr: true},
+		{name: "Unknown exporter", cfg: config.Config{TraceExporter: "jaeger"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop, err := Setup(&tt.cfg)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, stop(context.Background()))
+		})
+	}
+}
+
+func TestSetup_stdout(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	out := &bytes.Buffer{}
+	stdout = out
+	defer func() { stdout = os.Stdout }()
+	stop, err := Setup(&config.Config{TraceExporter: ExporterStdout})
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(context.Background(), "stdout span")
+	span.End()
+	require.NoError(t, stop(context.Background()))
+	require.Contains(t, out.String(), `"Name":"stdout span"`)
+}
+
+func TestLink(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	require.Empty(t, Parent(context.Background()))
+	require.Nil(t, Link(""))
+	require.Nil(t, Link("00-invalid"))
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67},
+		TraceFlags: trace.FlagsSampled,
+	})
+	parent := Parent(trace.ContextWithSpanContext(context.Background(), sc))
+	require.Equal(t, "00-4bf92f35000000000000000000000000-00f0670000000000-01", parent)
+	links := Link(parent)
+	require.Len(t, links, 1)
+	require.Equal(t, sc.TraceID(), links[0].SpanContext.TraceID())
+	require.Equal(t, sc.SpanID(), links[0].SpanContext.SpanID())
+}
+
+func TestDetach(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}})
+	ctx, cancel := context.WithCancel(trace.ContextWithSpanContext(context.Background(), sc))
+	cancel()
+	detached := Detach(ctx)
+	require.NoError(t, detached.Err())
+	require.Equal(t, sc, trace.SpanContextFromContext(detached))
+}
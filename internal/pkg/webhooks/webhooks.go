@@ -0,0 +1,220 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+//Webhook request headers
+const (
+	SignatureHeader = "X-Gophermart-Signature" //Signature of the request body: "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+	EventHeader     = "X-Gophermart-Event"     //Event type
+	DeliveryHeader  = "X-Gophermart-Delivery"  //Delivery identifier. The same for all attempts of the delivery
+)
+
+var (
+	ErrBadResponse      = errors.New("unexpected answer from webhook receiver") //Receiver answered with status other than 2xx
+	ErrInvalidSignature = errors.New("invalid webhook signature")               //Signature header is malformed, expired or does not match the body
+	ErrInvalidURL       = errors.New("invalid webhook URL")                     //Receiver address is not absolute http or https URL
+	ErrForbiddenAddress = errors.New("webhook receiver address is not allowed") //Receiver host is local, private or otherwise internal address
+)
+
+var sublog = log.With().Str("component", "webhooks").Logger()
+
+//Envelope - body of webhook request
+type Envelope struct {
+	ID      int64           `json:"id"`         //Delivery identifier
+	Event   string          `json:"event"`      //Event type
+	User    string          `json:"user"`       //Owner of the changed data
+	Created time.Time       `json:"created_at"` //Time of the event
+	Data    json.RawMessage `json:"data"`       //Event data
+}
+
+//Sender - webhook receivers client
+type Sender interface {
+	Send(ctx context.Context, delivery models.WebhookDelivery) error //Sending one delivery attempt. Returns nil if receiver answered 2xx
+}
+
+//HTTPSender - sender of signed webhook requests
+type HTTPSender struct {
+	client *http.Client
+}
+
+var _ Sender = (*HTTPSender)(nil)
+
+//internalNetworks - networks refused as receivers in addition to loopback, private, link-local, multicast and unspecified addresses
+var internalNetworks, _ = models.ParseNetworks("0.0.0.0/8,100.64.0.0/10,192.0.0.0/24,198.18.0.0/15,240.0.0.0/4,64:ff9b::/96")
+
+//Allowed - checking that receiver address is public. Addresses of allowed networks are accepted even if they are internal
+func Allowed(ip net.IP, allowed models.Networks) bool {
+	if allowed.Contains(ip) {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || internalNetworks.Contains(ip))
+}
+
+//CheckURL - checking that receiver address is absolute http or https URL and all addresses of its host are allowed
+func CheckURL(ctx context.Context, raw string, allowed models.Networks) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if !Allowed(ip, allowed) {
+			return fmt.Errorf("%w: %v", ErrForbiddenAddress, ip)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	for _, addr := range addrs {
+		if !Allowed(addr.IP, allowed) {
+			return fmt.Errorf("%w: %v resolves to %v", ErrForbiddenAddress, u.Hostname(), addr.IP)
+		}
+	}
+	return nil
+}
+
+//New - creating webhook sender from configuration. Resolved address is checked again on every connection, so receiver can't switch
+//to internal address after subscription. Redirects and proxies from environment are not used
+func New(cfg *config.Config) *HTTPSender {
+	allowed := cfg.WebhookAllowedNetworks
+	dialer := &net.Dialer{
+		Timeout: cfg.WebhookTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !Allowed(ip, allowed) {
+				return fmt.Errorf("%w: %v", ErrForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &HTTPSender{
+		client: &http.Client{
+			Timeout:   cfg.WebhookTimeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+//NewSecret - generating random key of payload signature
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		sublog.Error().Err(err).Msg("Error in random generator")
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//mac - HMAC-SHA256 of the time and the body
+func mac(secret string, ts int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(ts, 10)))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+//Sign - signature header value of the body sent at the time
+func Sign(secret string, at time.Time, body []byte) string {
+	ts := at.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac(secret, ts, body)))
+}
+
+//Verify - checking signature header of the received body. Signatures older than tolerance are refused to prevent replay
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts int64
+	var sum []byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidSignature
+		}
+		var err error
+		switch kv[0] {
+		case "t":
+			ts, err = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			sum, err = hex.DecodeString(kv[1])
+		}
+		if err != nil {
+			return ErrInvalidSignature
+		}
+	}
+	if ts == 0 || sum == nil {
+		return ErrInvalidSignature
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal(sum, mac(secret, ts, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+//Send - posting signed delivery to the receiver
+func (s *HTTPSender) Send(ctx context.Context, delivery models.WebhookDelivery) error {
+	body, err := json.Marshal(Envelope{
+		ID:      delivery.ID,
+		Event:   delivery.Event,
+		User:    delivery.User,
+		Created: delivery.Created,
+		Data:    delivery.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, delivery.Event)
+	request.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	request.Header.Set(SignatureHeader, Sign(delivery.Secret, time.Now(), body))
+	sublog.Debug().Msgf("Sending webhook delivery %v to %v. Attempt %v", delivery.ID, delivery.URL, delivery.Attempts)
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%w: status code %d", ErrBadResponse, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/gophermart/internal/pkg/config"
+	"github.com/t1mon-ggg/gophermart/internal/pkg/models"
+)
+
+func TestVerify(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	now := time.Now()
+	body := []byte(`{"id":1}`)
+	header := Sign("secret", now, body)
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr bool
+	}{
+		{name: "Valid signature", secret: "secret", header: header, body: body, now: now},
+		{name: "Wrong secret", secret: "other", header: header, body: body, now: now, wantErr: true},
+		{name: "Changed body", secret: "secret", header: header, body: []byte(`{"id":2}`), now: now, wantErr: true},
+		{name: "Expired signature", secret: "secret", header: header, body: body, now: now.Add(10 * time.Minute), wantErr: true},
+		{name: "Malformed header", secret: "secret", header: "v1=abc", body: body, now: now, wantErr: true},
+		{name: "Empty header", secret: "secret", header: "", body: body, now: now, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, 5*time.Minute, tt.now)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidSignature)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHTTPSender_Send(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tests := []struct {
+		name    string
+		answer  int
+		wantErr error
+	}{
+		{name: "Delivered", answer: http.StatusNoContent},
+		{name: "Server error", answer: http.StatusInternalServerError, wantErr: ErrBadResponse},
+		{name: "Redirect is not followed", answer: http.StatusFound, wantErr: ErrBadResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received Envelope
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.NoError(t, Verify("secret", r.Header.Get(SignatureHeader), body, time.Minute, time.Now()))
+				require.Equal(t, models.WebhookOrderProcessed, r.Header.Get(EventHeader))
+				require.Equal(t, "42", r.Header.Get(DeliveryHeader))
+				require.NoError(t, json.Unmarshal(body, &received))
+				if tt.answer == http.StatusFound {
+					w.Header().Set("Location", "/elsewhere")
+				}
+				w.WriteHeader(tt.answer)
+			}))
+			defer ts.Close()
+			allowed, err := models.ParseNetworks("127.0.0.1")
+			require.NoError(t, err)
+			sender := New(&config.Config{WebhookTimeout: time.Second, WebhookAllowedNetworks: allowed})
+			err = sender.Send(context.Background(), models.WebhookDelivery{
+				ID:      42,
+				User:    "user1",
+				Event:   models.WebhookOrderProcessed,
+				Payload: json.RawMessage(`{"number":"79927398713"}`),
+				URL:     ts.URL,
+				Secret:  "secret",
+			})
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, int64(42), received.ID)
+			require.Equal(t, "user1", received.User)
+			require.JSONEq(t, `{"number":"79927398713"}`, string(received.Data))
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	allowed, err := models.ParseNetworks("10.1.0.0/16")
+	require.NoError(t, err)
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{ip: "127.0.0.1"},
+		{ip: "::1"},
+		{ip: "10.0.0.1"},
+		{ip: "10.1.2.3", want: true},
+		{ip: "172.16.0.1"},
+		{ip: "192.168.1.1"},
+		{ip: "169.254.169.254"},
+		{ip: "fe80::1"},
+		{ip: "fd00::1"},
+		{ip: "0.0.0.0"},
+		{ip: "::"},
+		{ip: "100.64.0.1"},
+		{ip: "::ffff:127.0.0.1"},
+		{ip: "224.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			require.Equal(t, tt.want, Allowed(net.ParseIP(tt.ip), allowed))
+		})
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	tests := []struct {
+		url     string
+		wantErr error
+	}{
+		{url: "https://93.184.216.34/hook"},
+		{url: "http://[2606:2800:220:1:248:1893:25c8:1946]:8080/hook"},
+		{url: "/hook", wantErr: ErrInvalidURL},
+		{url: "ftp://93.184.216.34/hook", wantErr: ErrInvalidURL},
+		{url: "http://127.0.0.1:8080/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://[::1]/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://169.254.169.254/latest/meta-data", wantErr: ErrForbiddenAddress},
+		{url: "http://192.168.0.1/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://0.0.0.0:8081/api/user/balance", wantErr: ErrForbiddenAddress},
+		{url: "http://localhost:8081/hook", wantErr: ErrForbiddenAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := CheckURL(context.Background(), tt.url, nil)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHTTPSender_SendForbidden(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	called := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, ts.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+	_, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	require.NoError(t, err)
+	sender := New(&config.Config{WebhookTimeout: time.Second})
+	//Host name passing the check on subscription is resolved on every delivery, internal address is refused at connection
+	for _, target := range []string{ts.URL, "http://localhost:" + port} {
+		err = sender.Send(context.Background(), models.WebhookDelivery{ID: 1, URL: target, Secret: "secret"})
+		require.ErrorIs(t, err, ErrForbiddenAddress)
+	}
+	require.False(t, called)
+	allowed, err := models.ParseNetworks("127.0.0.1")
+	require.NoError(t, err)
+	sender = New(&config.Config{WebhookTimeout: time.Second, WebhookAllowedNetworks: allowed})
+	err = sender.Send(context.Background(), models.WebhookDelivery{ID: 1, URL: redirect.URL, Secret: "secret"})
+	require.ErrorIs(t, err, ErrBadResponse)
+	require.False(t, called)
+}